@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/bagaking/openapi-proxy/proxy"
@@ -18,6 +23,8 @@ var (
 )
 
 func main() {
+	configFile := flag.String("config", "", "JSON 配置文件路径，为空时使用内置配置")
+	flag.Parse()
 
 	conf := proxy.Config{
 		ListenAddr: ":8899",
@@ -47,15 +54,39 @@ func main() {
 			},
 		},
 	}
-	if _, err := proxy.StartCursorProxy(conf, map[string]string{
+	mappings := map[string]string{
 		"gpt-4":         "ep-20250208163847-fv7w8",
 		"gpt-4o":        "ep-20250208163847-fv7w8",
 		"gpt-3.5-turbo": "ep-20250208163847-fv7w8",
 		"deepseek-r1":   "ep-20250208163847-fv7w8",
-	}); err != nil {
+	}
+
+	// 指定配置文件时使用文件中的配置和模型映射
+	if *configFile != "" {
+		loaded, err := proxy.LoadConfig(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load config:", err)
+			os.Exit(1)
+		}
+		conf, mappings = loaded, nil
+	}
+
+	p, err := proxy.NewCursorProxy(conf, mappings)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to create proxy:", err)
 		os.Exit(1)
 	}
 
-	// 阻止主程序退出，使服务持续运行
-	select {}
+	// 收到 SIGINT、SIGTERM 时优雅关闭：等待进行中的流式响应结束，再次收到信号时直接退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := p.Start(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "Proxy stopped:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,319 @@
+package openai
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ChatCompletionRequest chat/completions 请求
+type ChatCompletionRequest struct {
+	Model               string          `json:"model"`
+	Messages            []Message       `json:"messages"`
+	Stream              bool            `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	MaxTokens           *int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"`
+	Stop                StopSequences   `json:"stop,omitempty"`
+	N                   *int            `json:"n,omitempty"`
+	PresencePenalty     *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64        `json:"frequency_penalty,omitempty"`
+	Seed                *int64          `json:"seed,omitempty"`
+	User                string          `json:"user,omitempty"`
+	Tools               []Tool          `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool           `json:"parallel_tool_calls,omitempty"`
+	ResponseFormat      json.RawMessage `json:"response_format,omitempty"`
+	Logprobs            bool            `json:"logprobs,omitempty"`
+	TopLogprobs         *int            `json:"top_logprobs,omitempty"`
+}
+
+// StreamOptions 流式选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// MaxOutputTokens 返回请求的最大输出 token 数，未设置时返回 0
+func (r *ChatCompletionRequest) MaxOutputTokens() int {
+	if r.MaxCompletionTokens != nil {
+		return *r.MaxCompletionTokens
+	}
+	if r.MaxTokens != nil {
+		return *r.MaxTokens
+	}
+	return 0
+}
+
+// StopSequences 停止序列，兼容字符串和字符串数组两种写法
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = StopSequences{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*s = multi
+	return nil
+}
+
+// Message 聊天消息
+//
+// Content 总是文本形式，数组形式的内容会拼接其中的文本，原始内容保留在 Parts 中
+type Message struct {
+	Role             string        `json:"role"`
+	Content          string        `json:"-"`
+	Parts            []ContentPart `json:"-"`
+	Name             string        `json:"name,omitempty"`
+	ToolCalls        []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID       string        `json:"tool_call_id,omitempty"`
+	ReasoningContent string        `json:"reasoning_content,omitempty"`
+	Refusal          string        `json:"refusal,omitempty"`
+}
+
+// messageJSON Message 的序列化形式
+type messageJSON struct {
+	Role             string          `json:"role"`
+	Content          json.RawMessage `json:"content"`
+	Name             string          `json:"name,omitempty"`
+	ToolCalls        []ToolCall      `json:"tool_calls,omitempty"`
+	ToolCallID       string          `json:"tool_call_id,omitempty"`
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	Refusal          string          `json:"refusal,omitempty"`
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	out := messageJSON{
+		Role:             m.Role,
+		Name:             m.Name,
+		ToolCalls:        m.ToolCalls,
+		ToolCallID:       m.ToolCallID,
+		ReasoningContent: m.ReasoningContent,
+		Refusal:          m.Refusal,
+	}
+	var err error
+	switch {
+	case m.Parts != nil:
+		out.Content, err = json.Marshal(m.Parts)
+	case m.Content == "" && len(m.ToolCalls) > 0:
+		out.Content = json.RawMessage("null")
+	default:
+		out.Content, err = json.Marshal(m.Content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(out)
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var in messageJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*m = Message{
+		Role:             in.Role,
+		Name:             in.Name,
+		ToolCalls:        in.ToolCalls,
+		ToolCallID:       in.ToolCallID,
+		ReasoningContent: in.ReasoningContent,
+		Refusal:          in.Refusal,
+	}
+
+	content := strings.TrimSpace(string(in.Content))
+	switch {
+	case content == "" || content == "null":
+	case strings.HasPrefix(content, "["):
+		if err := json.Unmarshal(in.Content, &m.Parts); err != nil {
+			return err
+		}
+		var sb strings.Builder
+		for _, part := range m.Parts {
+			if part.Type == "text" {
+				sb.WriteString(part.Text)
+			}
+		}
+		m.Content = sb.String()
+	default:
+		if err := json.Unmarshal(in.Content, &m.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContentPart 多模态内容片段
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL 图片地址，支持 data URL
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Tool 工具定义
+type Tool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
+}
+
+// FunctionDefinition 函数定义
+type FunctionDefinition struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// ToolCall 工具调用，流式响应中通过 Index 关联同一个调用的多个分片
+type ToolCall struct {
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall 函数调用
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolChoice 解析后的 tool_choice
+type ToolChoice struct {
+	Mode     string // auto / none / required / function
+	Function string // Mode 为 function 时指定的函数名
+}
+
+// ParseToolChoice 解析 tool_choice，未设置时返回零值
+func ParseToolChoice(raw json.RawMessage) ToolChoice {
+	if len(raw) == 0 {
+		return ToolChoice{}
+	}
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		return ToolChoice{Mode: mode}
+	}
+	var named struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err == nil && named.Function.Name != "" {
+		return ToolChoice{Mode: "function", Function: named.Function.Name}
+	}
+	return ToolChoice{}
+}
+
+// MarshalToolChoice 把 ToolChoice 转换为 tool_choice 字段
+func MarshalToolChoice(choice ToolChoice) json.RawMessage {
+	var raw []byte
+	switch choice.Mode {
+	case "":
+		return nil
+	case "function":
+		raw, _ = json.Marshal(map[string]interface{}{
+			"type":     "function",
+			"function": map[string]string{"name": choice.Function},
+		})
+	default:
+		raw, _ = json.Marshal(choice.Mode)
+	}
+	return raw
+}
+
+// ChatCompletionResponse chat/completions 非流式响应
+type ChatCompletionResponse struct {
+	ID                string   `json:"id"`
+	Object            string   `json:"object"`
+	Created           int64    `json:"created"`
+	Model             string   `json:"model"`
+	Choices           []Choice `json:"choices"`
+	Usage             *Usage   `json:"usage,omitempty"`
+	SystemFingerprint string   `json:"system_fingerprint,omitempty"`
+}
+
+// Choice 非流式响应的候选结果
+type Choice struct {
+	Index        int       `json:"index"`
+	Message      Message   `json:"message"`
+	FinishReason string    `json:"finish_reason"`
+	Logprobs     *Logprobs `json:"logprobs,omitempty"`
+}
+
+// ChatCompletionChunk chat/completions 流式响应的分片
+type ChatCompletionChunk struct {
+	ID                string        `json:"id"`
+	Object            string        `json:"object"`
+	Created           int64         `json:"created"`
+	Model             string        `json:"model"`
+	Choices           []ChunkChoice `json:"choices"`
+	Usage             *Usage        `json:"usage,omitempty"`
+	SystemFingerprint string        `json:"system_fingerprint,omitempty"`
+}
+
+// ChunkChoice 流式分片中的候选结果
+type ChunkChoice struct {
+	Index        int       `json:"index"`
+	Delta        Delta     `json:"delta"`
+	FinishReason *string   `json:"finish_reason"`
+	Logprobs     *Logprobs `json:"logprobs,omitempty"`
+}
+
+// Delta 流式分片中的增量内容
+type Delta struct {
+	Role             string     `json:"role,omitempty"`
+	Content          string     `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	Refusal          string     `json:"refusal,omitempty"`
+}
+
+// Usage token 用量
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Logprobs token 概率信息
+type Logprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+// TokenLogprob 单个 token 的概率信息
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes,omitempty"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+// TopLogprob 候选 token 的概率
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes,omitempty"`
+}
+
+// 常用的 finish_reason
+const (
+	FinishReasonStop          = "stop"
+	FinishReasonLength        = "length"
+	FinishReasonToolCalls     = "tool_calls"
+	FinishReasonContentFilter = "content_filter"
+)
+
+// StringPtr 返回字符串指针，用于 finish_reason 等可为 null 的字段
+func StringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,97 @@
+package openai
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// EmbeddingRequest embeddings 请求
+type EmbeddingRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	EncodingFormat string          `json:"encoding_format,omitempty"`
+	Dimensions     *int            `json:"dimensions,omitempty"`
+	User           string          `json:"user,omitempty"`
+}
+
+// EmbeddingResponse embeddings 响应
+type EmbeddingResponse struct {
+	Object string         `json:"object"`
+	Data   []Embedding    `json:"data"`
+	Model  string         `json:"model"`
+	Usage  EmbeddingUsage `json:"usage"`
+}
+
+// Embedding 单个输入的向量
+type Embedding struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding EmbeddingVector `json:"embedding"`
+}
+
+// EmbeddingUsage embeddings 的 token 用量
+type EmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// EmbeddingVector 向量，兼容浮点数组和 base64（小端 float32）两种编码
+type EmbeddingVector []float32
+
+func (v *EmbeddingVector) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err == nil {
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		if len(raw)%4 != 0 {
+			return errors.New("invalid base64 embedding length")
+		}
+		out := make(EmbeddingVector, len(raw)/4)
+		for i := range out {
+			out[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+		}
+		*v = out
+		return nil
+	}
+	var floats []float32
+	if err := json.Unmarshal(data, &floats); err != nil {
+		return err
+	}
+	*v = floats
+	return nil
+}
+
+// Base64 返回 encoding_format 为 base64 时的编码
+func (v EmbeddingVector) Base64() string {
+	raw := make([]byte, len(v)*4)
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(f))
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// Truncate 截断到前 dimensions 维并重新做 L2 归一化
+func (v EmbeddingVector) Truncate(dimensions int) EmbeddingVector {
+	if dimensions <= 0 || dimensions >= len(v) {
+		return v
+	}
+	out := make(EmbeddingVector, dimensions)
+	copy(out, v[:dimensions])
+	var norm float64
+	for _, f := range out {
+		norm += float64(f) * float64(f)
+	}
+	if norm == 0 {
+		return out
+	}
+	norm = math.Sqrt(norm)
+	for i, f := range out {
+		out[i] = float32(float64(f) / norm)
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package openai
+
+// Error OpenAI 协议的错误对象
+type Error struct {
+	Message string      `json:"message"`
+	Type    string      `json:"type"`
+	Param   interface{} `json:"param"`
+	Code    interface{} `json:"code"`
+}
+
+// ErrorResponse OpenAI 协议的错误响应
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// ErrorTypeForStatus 根据 HTTP 状态码推断 OpenAI 错误类型
+func ErrorTypeForStatus(status int) string {
+	switch {
+	case status == 401:
+		return "authentication_error"
+	case status == 403:
+		return "permission_error"
+	case status == 404:
+		return "not_found_error"
+	case status == 429:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// NewErrorResponse 创建错误响应
+func NewErrorResponse(status int, message string, code interface{}) ErrorResponse {
+	return ErrorResponse{Error: Error{
+		Message: message,
+		Type:    ErrorTypeForStatus(status),
+		Code:    code,
+	}}
+}
@@ -0,0 +1,303 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 缓存后端类型
+const (
+	CacheBackendMemory = "memory" // 内存 LRU（默认）
+	CacheBackendDisk   = "disk"   // 磁盘，进程重启后仍然有效
+)
+
+// X-Cache 响应头的取值
+const (
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheBypass = "BYPASS"
+)
+
+// CacheConfig 响应缓存插件的配置
+type CacheConfig struct {
+	Backend         string         `json:"backend"`           // 缓存后端，CacheBackendMemory 或 CacheBackendDisk
+	MaxEntries      int            `json:"max_entries"`       // 内存缓存的条目上限，默认 1000
+	Dir             string         `json:"dir"`               // 磁盘缓存目录
+	TTLSeconds      int            `json:"ttl_seconds"`       // 缓存有效期，默认 3600 秒
+	ModelTTLSeconds map[string]int `json:"model_ttl_seconds"` // 按模型覆盖缓存有效期
+	Models          []string       `json:"models"`            // 启用缓存的模型，为空表示所有模型
+	KeyHeaders      []string       `json:"key_headers"`       // 额外参与计算缓存键的请求头
+	ShareAcrossKeys bool           `json:"share_across_keys"` // 不同客户端 key 共享缓存，默认按 key 隔离
+	IgnoreFields    []string       `json:"ignore_fields"`     // 计算缓存键时忽略的请求字段，默认忽略 user
+	AllowSampling   bool           `json:"allow_sampling"`    // 也缓存 temperature 不为 0 的 chat 请求
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Bypasses int64 `json:"bypasses"`
+	Stores   int64 `json:"stores"`
+}
+
+// CachePlugin 响应缓存插件，相同的确定性请求（如 temperature 为 0 的 chat 请求和 embeddings 请求）直接返回缓存的响应
+//
+// 缓存键是规范化后的请求体、客户端 key 和指定请求头的哈希，流式响应缓存完整的 SSE 事件并在命中时按事件回放
+type CachePlugin struct {
+	config CacheConfig
+	store  CacheStore
+	logger Logger
+
+	hits     atomic.Int64
+	misses   atomic.Int64
+	bypasses atomic.Int64
+	stores   atomic.Int64
+}
+
+// NewCachePlugin 创建新的缓存插件
+func NewCachePlugin(logger Logger, config CacheConfig) (*CachePlugin, error) {
+	p := &CachePlugin{logger: logger}
+	if err := p.apply(config); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Configure 配置插件，会重新创建缓存后端
+func (p *CachePlugin) Configure(config json.RawMessage) error {
+	var cfg CacheConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return err
+	}
+	return p.apply(cfg)
+}
+
+func (p *CachePlugin) apply(config CacheConfig) error {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 1000
+	}
+	if config.TTLSeconds <= 0 {
+		config.TTLSeconds = 3600
+	}
+	if config.IgnoreFields == nil {
+		config.IgnoreFields = []string{"user"}
+	}
+
+	switch config.Backend {
+	case "", CacheBackendMemory:
+		p.store = NewMemoryCacheStore(config.MaxEntries)
+	case CacheBackendDisk:
+		if config.Dir == "" {
+			return errors.New("cache dir is required for disk backend")
+		}
+		store, err := NewDiskCacheStore(config.Dir)
+		if err != nil {
+			return err
+		}
+		p.store = store
+	default:
+		return fmt.Errorf("unknown cache backend: %s", config.Backend)
+	}
+	p.config = config
+	return nil
+}
+
+// Stats 返回缓存统计
+func (p *CachePlugin) Stats() CacheStats {
+	return CacheStats{
+		Hits:     p.hits.Load(),
+		Misses:   p.misses.Load(),
+		Bypasses: p.bypasses.Load(),
+		Stores:   p.stores.Load(),
+	}
+}
+
+func (p *CachePlugin) BeforeRequest(ctx *RequestContext) error {
+	return nil
+}
+
+func (p *CachePlugin) AfterResponse(resp *http.Response) error {
+	return nil
+}
+
+// Respond 命中缓存时直接写出缓存的响应，未命中时返回记录响应的函数
+func (p *CachePlugin) Respond(w http.ResponseWriter, ctx *RequestContext) (bool, func(*Response), error) {
+	req := ctx.Request
+	if req.Method != http.MethodPost ||
+		(!strings.Contains(req.URL.Path, "/chat/completions") && !strings.Contains(req.URL.Path, "/embeddings")) {
+		return false, nil, nil
+	}
+
+	// 计算缓存键需要规范化完整的请求体
+	var requestBody map[string]interface{}
+	if err := ctx.Decode(&requestBody); err != nil {
+		return false, nil, nil
+	}
+	model, _ := requestBody["model"].(string)
+	if !p.cacheable(req, model, requestBody) {
+		return false, nil, nil
+	}
+
+	// no-cache 跳过读取但仍然更新缓存，no-store 完全不使用缓存
+	cacheControl := strings.ToLower(req.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") {
+		p.bypasses.Add(1)
+		w.Header().Set("X-Cache", CacheBypass)
+		return false, nil, nil
+	}
+
+	key := p.key(req, requestBody)
+	if strings.Contains(cacheControl, "no-cache") {
+		p.bypasses.Add(1)
+		w.Header().Set("X-Cache", CacheBypass)
+	} else if entry, ok := p.store.Get(key); ok {
+		if !entry.Expired() {
+			p.hits.Add(1)
+			p.logger.Info("Cache hit for model:", model)
+			writeCacheEntry(w, entry)
+			return true, nil, nil
+		}
+		_ = p.store.Delete(key)
+	}
+
+	if w.Header().Get("X-Cache") == "" {
+		p.misses.Add(1)
+		w.Header().Set("X-Cache", CacheMiss)
+	}
+	return false, func(resp *Response) {
+		p.record(key, model, resp)
+	}, nil
+}
+
+// cacheable 判断请求是否启用缓存：模型在配置中，且 chat 请求的 temperature 为 0
+func (p *CachePlugin) cacheable(req *http.Request, model string, requestBody map[string]interface{}) bool {
+	if len(p.config.Models) > 0 {
+		enabled := false
+		for _, m := range p.config.Models {
+			if m == model {
+				enabled = true
+				break
+			}
+		}
+		if !enabled {
+			return false
+		}
+	}
+	if strings.Contains(req.URL.Path, "/chat/completions") && !p.config.AllowSampling {
+		temperature, ok := requestBody["temperature"].(float64)
+		return ok && temperature == 0
+	}
+	return true
+}
+
+// key 计算缓存键：请求路径、客户端 key 的哈希（ShareAcrossKeys 时不包含）、指定请求头和规范化后的请求体（对象按键排序，忽略配置的字段）
+func (p *CachePlugin) key(req *http.Request, requestBody map[string]interface{}) string {
+	normalized := make(map[string]interface{}, len(requestBody))
+	for k, v := range requestBody {
+		normalized[k] = v
+	}
+	for _, field := range p.config.IgnoreFields {
+		delete(normalized, field)
+	}
+	canonical, _ := json.Marshal(normalized)
+
+	headers := make([]string, 0, len(p.config.KeyHeaders))
+	for _, h := range p.config.KeyHeaders {
+		headers = append(headers, strings.ToLower(h)+": "+req.Header.Get(h))
+	}
+	sort.Strings(headers)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL.Path)
+	if !p.config.ShareAcrossKeys {
+		sum := sha256.Sum256([]byte(clientKey(req.Header)))
+		fmt.Fprintf(h, "key: %x\n", sum)
+	}
+	for _, header := range headers {
+		fmt.Fprintln(h, header)
+	}
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// record 保存完整的成功响应
+func (p *CachePlugin) record(key, model string, resp *Response) {
+	if !completeResponse(resp) {
+		return
+	}
+
+	ttl := p.config.TTLSeconds
+	if modelTTL, ok := p.config.ModelTTLSeconds[model]; ok {
+		ttl = modelTTL
+	}
+	now := time.Now()
+	entry := &CacheEntry{
+		Model:      model,
+		StatusCode: resp.StatusCode,
+		Header:     http.Header{"Content-Type": {resp.Header.Get("Content-Type")}},
+		Body:       resp.Body,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Duration(ttl) * time.Second),
+	}
+	if err := p.store.Set(key, entry); err != nil {
+		p.logger.Error("Failed to store cache entry:", err)
+		return
+	}
+	p.stores.Add(1)
+	p.logger.Debug("Cache stored for model:", model)
+}
+
+// completeResponse 判断响应是否可以缓存：状态码为 200，流式响应以 [DONE] 结束，非流式响应是合法的 JSON
+func completeResponse(resp *Response) bool {
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return bytes.Contains(resp.Body, []byte("data: [DONE]"))
+	}
+	return json.Valid(resp.Body)
+}
+
+// writeCacheEntry 写出缓存的响应，SSE 响应逐个事件写出并刷新
+func writeCacheEntry(w http.ResponseWriter, entry *CacheEntry) {
+	for k, v := range entry.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", CacheHit)
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(entry.CreatedAt).Seconds())))
+
+	if !strings.Contains(entry.Header.Get("Content-Type"), "text/event-stream") {
+		w.Header().Set("Content-Length", strconv.Itoa(len(entry.Body)))
+		w.WriteHeader(entry.StatusCode)
+		w.Write(entry.Body)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(entry.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	rest := entry.Body
+	for len(rest) > 0 {
+		n := len(rest)
+		if i := bytes.Index(rest, []byte("\n\n")); i >= 0 {
+			n = i + 2
+		}
+		if _, err := w.Write(rest[:n]); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		rest = rest[n:]
+	}
+}
@@ -0,0 +1,453 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// 策略服务的决定
+const (
+	CalloutAllow = "allow" // 放行
+	CalloutDeny  = "deny"  // 拒绝，返回 message
+	CalloutPatch = "patch" // 按 JSON Patch 修改请求体后放行
+)
+
+// 发送给策略服务的内容
+const (
+	CalloutContentLastUser = "last_user" // 最后一条 user 消息（默认）
+	CalloutContentAll      = "all"       // 所有消息
+	CalloutContentNone     = "none"      // 不发送消息内容
+)
+
+// defaultCalloutMethod gRPC 策略服务默认的方法，请求和响应都是 google.protobuf.Struct
+const defaultCalloutMethod = "/openapiproxy.policy.v1.Policy/Check"
+
+// CalloutConfig 外部策略插件的配置
+type CalloutConfig struct {
+	URL             string            `json:"url"`               // 策略服务地址：http(s)://… 或 grpc://host:port、grpcs://host:port
+	Name            string            `json:"name"`              // 插件名称，默认 CalloutPlugin
+	GRPCMethod      string            `json:"grpc_method"`       // gRPC 方法，默认 /openapiproxy.policy.v1.Policy/Check
+	Headers         map[string]string `json:"headers"`           // 发给策略服务的请求头（gRPC 为 metadata），如鉴权
+	ForwardHeaders  []string          `json:"forward_headers"`   // 摘要中附带的客户端请求头
+	Content         string            `json:"content"`           // 发送的消息内容：last_user、all、none
+	MaxContentChars int               `json:"max_content_chars"` // 每条消息内容的字符上限，默认 2000
+	TimeoutMS       int               `json:"timeout_ms"`        // 调用超时，默认 300 毫秒
+	FailOpen        bool              `json:"fail_open"`         // 策略服务不可用时放行；默认拒绝请求
+	CacheTTLSeconds int               `json:"cache_ttl_seconds"` // 相同摘要的决定缓存时间，默认 60 秒，小于 0 不缓存
+	CacheMaxEntries int               `json:"cache_max_entries"` // 缓存条目上限，默认 1000
+}
+
+// CalloutRequest 发送给策略服务的请求摘要
+type CalloutRequest struct {
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Model         string            `json:"model"`
+	Stream        bool              `json:"stream"`
+	Key           string            `json:"key,omitempty"`      // 脱敏后的客户端密钥
+	KeyHash       string            `json:"key_hash,omitempty"` // 客户端密钥的 SHA-256，用于区分调用方
+	MessageCount  int               `json:"message_count"`
+	TokenEstimate int               `json:"token_estimate"` // 按字符数估算的输入 token 数
+	Messages      []CalloutMessage  `json:"messages,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Partial       bool              `json:"partial,omitempty"` // multipart、WebSocket 请求的摘要，不能 patch
+}
+
+// CalloutMessage 摘要中的一条消息，内容超过上限时截断
+type CalloutMessage struct {
+	Index   int    `json:"index"`
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// CalloutDecision 策略服务的决定，action 为空时有 patch 视为 patch，否则视为 allow
+type CalloutDecision struct {
+	Action  string          `json:"action"`
+	Message string          `json:"message,omitempty"` // 拒绝的原因，返回给客户端
+	Status  int             `json:"status,omitempty"`  // 拒绝时的状态码，默认 403
+	Patch   json.RawMessage `json:"patch,omitempty"`   // RFC 6902 JSON Patch
+}
+
+// CalloutPlugin 转发前把请求摘要发送给外部策略服务，按返回的决定放行、拒绝或修改请求
+type CalloutPlugin struct {
+	config CalloutConfig
+	logger Logger
+
+	client *http.Client
+	conn   *grpc.ClientConn
+	cache  *MemoryCacheStore
+}
+
+// NewCalloutPlugin 创建外部策略插件，gRPC 连接在第一次调用时建立
+func NewCalloutPlugin(logger Logger, config CalloutConfig) (*CalloutPlugin, error) {
+	if config.TimeoutMS <= 0 {
+		config.TimeoutMS = 300
+	}
+	if config.MaxContentChars <= 0 {
+		config.MaxContentChars = 2000
+	}
+	if config.CacheTTLSeconds == 0 {
+		config.CacheTTLSeconds = 60
+	}
+	if config.CacheMaxEntries <= 0 {
+		config.CacheMaxEntries = 1000
+	}
+	if config.GRPCMethod == "" {
+		config.GRPCMethod = defaultCalloutMethod
+	}
+	switch config.Content {
+	case "":
+		config.Content = CalloutContentLastUser
+	case CalloutContentLastUser, CalloutContentAll, CalloutContentNone:
+	default:
+		return nil, fmt.Errorf("unknown callout content: %s", config.Content)
+	}
+
+	p := &CalloutPlugin{config: config, logger: logger}
+	if config.CacheTTLSeconds > 0 {
+		p.cache = NewMemoryCacheStore(config.CacheMaxEntries)
+	}
+	target, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch target.Scheme {
+	case "http", "https":
+		p.client = &http.Client{Timeout: p.timeout()}
+	case "grpc", "grpcs":
+		creds := insecure.NewCredentials()
+		if target.Scheme == "grpcs" {
+			creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		}
+		if p.conn, err = grpc.NewClient(target.Host, grpc.WithTransportCredentials(creds)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("callout url must be http(s):// or grpc(s)://, got %q", config.URL)
+	}
+	return p, nil
+}
+
+// Name 插件名称
+func (p *CalloutPlugin) Name() string {
+	if p.config.Name != "" {
+		return p.config.Name
+	}
+	return "CalloutPlugin"
+}
+
+// Configure 插件的配置在创建时确定，需要修改时重新注册插件
+func (p *CalloutPlugin) Configure(config json.RawMessage) error {
+	return errors.New("callout plugin does not support reconfiguration, register a new plugin instead")
+}
+
+// Close 关闭 gRPC 连接
+func (p *CalloutPlugin) Close() error {
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
+
+func (p *CalloutPlugin) timeout() time.Duration {
+	return time.Duration(p.config.TimeoutMS) * time.Millisecond
+}
+
+func (p *CalloutPlugin) BeforeRequest(ctx *RequestContext) error {
+	return p.check(ctx, false)
+}
+
+// Admit 检查 multipart 和 WebSocket 请求，请求体无法修改，patch 决定按策略服务失败处理
+func (p *CalloutPlugin) Admit(ctx *RequestContext) error {
+	return p.check(ctx, true)
+}
+
+func (p *CalloutPlugin) check(ctx *RequestContext, partial bool) error {
+	req := p.summarize(ctx)
+	req.Partial = partial
+	summary, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	decision, err := p.decide(ctx.Request.Context(), summary)
+	if err == nil {
+		err = p.apply(ctx, decision, partial)
+	}
+	var reject *RejectError
+	if err == nil || errors.As(err, &reject) {
+		return err
+	}
+	if p.config.FailOpen {
+		p.logger.Error("Policy callout failed, allowing request:", err)
+		return nil
+	}
+	p.logger.Error("Policy callout failed:", err)
+	return &RejectError{Status: http.StatusServiceUnavailable, Message: "policy check unavailable", Code: "policy_unavailable"}
+}
+
+func (p *CalloutPlugin) AfterResponse(resp *http.Response) error {
+	return nil
+}
+
+// apply 应用策略服务的决定
+func (p *CalloutPlugin) apply(ctx *RequestContext, decision *CalloutDecision, partial bool) error {
+	action := decision.Action
+	if action == "" {
+		action = CalloutAllow
+		if len(decision.Patch) > 0 {
+			action = CalloutPatch
+		}
+	}
+	switch action {
+	case CalloutAllow:
+		return nil
+	case CalloutDeny:
+		status := decision.Status
+		if status < 400 || status > 599 {
+			status = http.StatusForbidden
+		}
+		message := decision.Message
+		if message == "" {
+			message = "request denied by policy"
+		}
+		return &RejectError{Status: status, Message: message, Code: "policy_denied"}
+	case CalloutPatch:
+		if partial {
+			return errors.New("patch cannot be applied to multipart or WebSocket requests")
+		}
+		patch, err := jsonpatch.DecodePatch(decision.Patch)
+		if err != nil {
+			return fmt.Errorf("invalid patch: %w", err)
+		}
+		body, err := patch.Apply(ctx.Body())
+		if err != nil {
+			return fmt.Errorf("apply patch: %w", err)
+		}
+		ctx.SetBody(body)
+		ctx.Request.ContentLength = int64(len(body))
+		return nil
+	}
+	return fmt.Errorf("unknown policy action: %s", decision.Action)
+}
+
+// decide 返回摘要对应的决定，优先使用缓存；调用失败的结果不缓存
+func (p *CalloutPlugin) decide(ctx context.Context, summary []byte) (*CalloutDecision, error) {
+	var key string
+	if p.cache != nil {
+		sum := sha256.Sum256(summary)
+		key = hex.EncodeToString(sum[:])
+		if entry, ok := p.cache.Get(key); ok && !entry.Expired() {
+			var decision CalloutDecision
+			if err := json.Unmarshal(entry.Body, &decision); err == nil {
+				return &decision, nil
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout())
+	defer cancel()
+	var raw []byte
+	var err error
+	if p.conn != nil {
+		raw, err = p.callGRPC(ctx, summary)
+	} else {
+		raw, err = p.callHTTP(ctx, summary)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var decision CalloutDecision
+	if err := json.Unmarshal(raw, &decision); err != nil {
+		return nil, fmt.Errorf("invalid policy response: %w", err)
+	}
+
+	if p.cache != nil {
+		now := time.Now()
+		_ = p.cache.Set(key, &CacheEntry{
+			Body:      raw,
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Duration(p.config.CacheTTLSeconds) * time.Second),
+		})
+	}
+	return &decision, nil
+}
+
+func (p *CalloutPlugin) callHTTP(ctx context.Context, summary []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(summary))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy service returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// callGRPC 以 google.protobuf.Struct 发送摘要，策略服务不需要依赖代理的 proto 定义
+func (p *CalloutPlugin) callGRPC(ctx context.Context, summary []byte) ([]byte, error) {
+	req := &structpb.Struct{}
+	if err := protojson.Unmarshal(summary, req); err != nil {
+		return nil, err
+	}
+	for k, v := range p.config.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(k), v)
+	}
+	resp := &structpb.Struct{}
+	if err := p.conn.Invoke(ctx, p.config.GRPCMethod, req, resp); err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(resp)
+}
+
+// summarize 生成请求摘要，只包含策略判断需要的字段
+func (p *CalloutPlugin) summarize(ctx *RequestContext) *CalloutRequest {
+	req := ctx.Request
+	summary := &CalloutRequest{
+		Method: req.Method,
+		Path:   ctx.Path(),
+		Model:  ctx.Model(),
+		Stream: ctx.Stream(),
+	}
+	if key := clientKey(req.Header); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		summary.KeyHash = hex.EncodeToString(sum[:])
+		summary.Key = "****"
+		if len(key) > 8 {
+			summary.Key = key[:3] + "..." + key[len(key)-4:]
+		}
+	}
+	for _, name := range p.config.ForwardHeaders {
+		if v := req.Header.Get(name); v != "" {
+			if summary.Headers == nil {
+				summary.Headers = make(map[string]string)
+			}
+			summary.Headers[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+
+	messages := requestMessages(ctx)
+	summary.MessageCount = len(messages)
+	chars := 0
+	for _, m := range messages {
+		chars += utf8.RuneCountInString(m.Content)
+	}
+	// 没有分词器时按平均 4 个字符一个 token 估算
+	summary.TokenEstimate = (chars + 3) / 4
+
+	switch p.config.Content {
+	case CalloutContentAll:
+		for _, m := range messages {
+			summary.Messages = append(summary.Messages, p.excerpt(m))
+		}
+	case CalloutContentLastUser:
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "user" {
+				summary.Messages = []CalloutMessage{p.excerpt(messages[i])}
+				break
+			}
+		}
+	}
+	return summary
+}
+
+// requestMessages 提取请求中的文本消息：chat 请求的 messages，其它请求的 input 或 prompt 视为一条 user 消息
+func requestMessages(ctx *RequestContext) []CalloutMessage {
+	var messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if raw := ctx.Field("messages"); raw != nil && json.Unmarshal(raw, &messages) == nil {
+		out := make([]CalloutMessage, len(messages))
+		for i, m := range messages {
+			out[i] = CalloutMessage{Index: i, Role: m.Role, Content: textOf(m.Content)}
+		}
+		return out
+	}
+	for _, field := range []string{"input", "prompt"} {
+		if raw := ctx.Field(field); raw != nil {
+			return []CalloutMessage{{Role: "user", Content: textOf(raw)}}
+		}
+	}
+	return nil
+}
+
+// textOf 返回消息内容中的文本：字符串、字符串数组或 {type: "text", text} 分段，其它内容忽略
+func textOf(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var parts []json.RawMessage
+	if json.Unmarshal(raw, &parts) != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		var text struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(part, &s) == nil {
+			texts = append(texts, s)
+		} else if json.Unmarshal(part, &text) == nil && text.Text != "" {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// clientKey 返回客户端的密钥
+func clientKey(h http.Header) string {
+	if key := strings.TrimPrefix(h.Get("Authorization"), "Bearer "); key != "" {
+		return key
+	}
+	if key := h.Get("api-key"); key != "" {
+		return key
+	}
+	return h.Get("x-api-key")
+}
+
+// excerpt 截断消息内容
+func (p *CalloutPlugin) excerpt(m CalloutMessage) CalloutMessage {
+	m.Content = truncateRunes(m.Content, p.config.MaxContentChars)
+	return m
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// RequestContext 一个请求在插件之间共享的上下文
+//
+// 请求体只读取一次，按需解析并缓存解析结果；插件通过 SetModel、SetField 修改请求体，
+// 修改在转发前由 Body 统一重新编码一次
+type RequestContext struct {
+	Request *http.Request
+
+	body []byte
+
+	meta   *requestMeta
+	chat   *ChatRequest
+	chatOK bool
+	parsed bool // 是否已经尝试解析 chat 请求
+
+	fields map[string]json.RawMessage // 按顶层字段解析的请求体，nil 表示尚未解析
+	dirty  bool                       // fields 有修改，尚未重新编码
+}
+
+// requestMeta 大部分插件只关心的字段
+type requestMeta struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+// NewRequestContext 创建请求的上下文，body 是完整的请求体
+func NewRequestContext(req *http.Request, body []byte) *RequestContext {
+	return &RequestContext{Request: req, body: body}
+}
+
+// Path 请求路径
+func (c *RequestContext) Path() string {
+	return c.Request.URL.Path
+}
+
+// Body 返回当前的请求体，有修改时重新编码
+func (c *RequestContext) Body() []byte {
+	if c.dirty {
+		if body, err := json.Marshal(c.fields); err == nil {
+			c.body = body
+		}
+		c.dirty = false
+	}
+	return c.body
+}
+
+// SetBody 替换整个请求体，之前的解析结果失效
+func (c *RequestContext) SetBody(body []byte) {
+	c.body = body
+	c.fields = nil
+	c.dirty = false
+	c.reset()
+}
+
+// Decode 把请求体解析到 v，适用于需要完整请求体的插件（如缓存）
+func (c *RequestContext) Decode(v interface{}) error {
+	return json.Unmarshal(c.Body(), v)
+}
+
+// Model 请求的模型，非 JSON 请求体返回空字符串
+func (c *RequestContext) Model() string {
+	return c.parseMeta().Model
+}
+
+// Stream 是否请求流式响应
+func (c *RequestContext) Stream() bool {
+	return c.parseMeta().Stream
+}
+
+func (c *RequestContext) parseMeta() *requestMeta {
+	if c.meta != nil {
+		return c.meta
+	}
+	c.meta = &requestMeta{}
+	if c.dirty {
+		// 修改过请求体时从顶层字段读取，避免重新编码
+		_ = json.Unmarshal(c.fields["model"], &c.meta.Model)
+		_ = json.Unmarshal(c.fields["stream"], &c.meta.Stream)
+		return c.meta
+	}
+	_ = json.Unmarshal(c.body, c.meta)
+	return c.meta
+}
+
+// Chat 返回解析后的 chat 请求，请求体不是合法的 chat 请求（如消息内容不是字符串）时返回 false。
+// 返回的请求只读，修改请求体使用 SetModel、SetField
+func (c *RequestContext) Chat() (*ChatRequest, bool) {
+	if !c.parsed {
+		c.parsed = true
+		c.chat = &ChatRequest{}
+		c.chatOK = json.Unmarshal(c.Body(), c.chat) == nil
+	}
+	return c.chat, c.chatOK
+}
+
+// Field 返回请求体顶层字段的原始 JSON，不存在时返回 nil
+func (c *RequestContext) Field(name string) json.RawMessage {
+	if err := c.decodeFields(); err != nil {
+		return nil
+	}
+	return c.fields[name]
+}
+
+// SetField 修改请求体的顶层字段，value 为 nil 时删除字段；请求体不是 JSON 对象时返回错误
+func (c *RequestContext) SetField(name string, value interface{}) error {
+	if err := c.decodeFields(); err != nil {
+		return err
+	}
+	if value == nil {
+		delete(c.fields, name)
+	} else {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		c.fields[name] = raw
+	}
+	c.dirty = true
+	c.reset()
+	return nil
+}
+
+// SetModel 修改请求的模型
+func (c *RequestContext) SetModel(model string) error {
+	return c.SetField("model", model)
+}
+
+// decodeFields 按顶层字段解析请求体，字段的值保持原始 JSON，不解析消息等大字段
+func (c *RequestContext) decodeFields() error {
+	if c.fields != nil {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(c.body, &fields); err != nil {
+		return err
+	}
+	if fields == nil {
+		return errors.New("request body is not a JSON object")
+	}
+	c.fields = fields
+	return nil
+}
+
+// reset 请求体修改后清除缓存的解析结果
+func (c *RequestContext) reset() {
+	c.meta = nil
+	c.chat = nil
+	c.chatOK = false
+	c.parsed = false
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// Embedder 把文本转换为向量
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// HTTPEmbedder 通过 OpenAI 兼容的 embeddings 接口计算向量，可以指向本代理自身
+type HTTPEmbedder struct {
+	BaseURL string // 如 http://localhost:8899/v1，请求发往 {BaseURL}/embeddings
+	Model   string
+	APIKey  string
+	Client  *http.Client
+}
+
+func (e *HTTPEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	input, _ := json.Marshal(text)
+	body, _ := json.Marshal(openai.EmbeddingRequest{Model: e.Model, Input: input})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.BaseURL, "/")+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embeddings request failed: %s: %s", resp.Status, respBody)
+	}
+	var eresp openai.EmbeddingResponse
+	if err := json.Unmarshal(respBody, &eresp); err != nil {
+		return nil, err
+	}
+	if len(eresp.Data) == 0 {
+		return nil, fmt.Errorf("embeddings response has no data")
+	}
+	return eresp.Data[0].Embedding, nil
+}
+
+// HashEmbedder 本地替代实现：把词和字符三元组哈希到固定维度并归一化，不需要模型，
+// 只能识别字面上相近的改写（大小写、标点、语序、少量增删词），识别不了同义改写
+type HashEmbedder struct {
+	Dimensions int
+}
+
+func (e *HashEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	dim := e.Dimensions
+	if dim <= 0 {
+		dim = 512
+	}
+	vec := make([]float32, dim)
+	add := func(feature string, weight float32) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// 用另一位决定符号，减少哈希冲突带来的偏差
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vec[sum%uint64(dim)] += weight
+	}
+
+	for _, word := range tokenize(text) {
+		add("w:"+word, 1)
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			add("c:"+string(runes[i:i+3]), 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vec {
+			vec[i] *= scale
+		}
+	}
+	return vec, nil
+}
+
+// tokenize 按非字母数字切分并转为小写，中日韩文字每个字单独作为一个词
+func tokenize(text string) []string {
+	var words []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, string(current))
+			current = current[:0]
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r):
+			flush()
+			words = append(words, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			current = append(current, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// cosineSimilarity 计算两个向量的余弦相似度，维度不同时返回 0
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
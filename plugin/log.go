@@ -12,12 +12,9 @@ type LogPlugin struct {
 	LogFile string
 }
 
-func (p *LogPlugin) BeforeRequest(req *http.Request) error {
+func (p *LogPlugin) BeforeRequest(ctx *RequestContext) error {
 	// 记录请求信息
-	body, _ := io.ReadAll(req.Body)
-	req.Body = io.NopCloser(bytes.NewBuffer(body))
-
-	fmt.Printf("[Request] %s %s: %s\n", req.Method, req.URL, string(body))
+	fmt.Printf("[Request] %s %s: %s\n", ctx.Request.Method, ctx.Request.URL, ctx.Body())
 	return nil
 }
 
@@ -20,11 +20,11 @@ package plugin
 // 	}
 // }
 
-// func (p *MetricsPlugin) BeforeRequest(req *http.Request) error {
+// func (p *MetricsPlugin) BeforeRequest(ctx *RequestContext) error {
 // 	p.mu.Lock()
 // 	defer p.mu.Unlock()
 
-// 	key := fmt.Sprintf("%s %s", req.Method, req.URL.Path)
+// 	key := fmt.Sprintf("%s %s", ctx.Request.Method, ctx.Path())
 // 	p.metrics[key]++
 
 // 	p.logger.Info("MetricsPlugin: Request count for", key, ":", p.metrics[key])
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -121,28 +120,15 @@ func (p *MockPlugin) AddRule(condition func(req *ChatRequest) bool, response fun
 }
 
 // 在 plugin/mock.go 中的 BeforeRequest
-func (p *MockPlugin) BeforeRequest(req *http.Request) error {
-	// 只处理 chat/completions 请求
-	if !strings.Contains(req.URL.Path, "/chat/completions") {
-		return nil
-	}
+func (p *MockPlugin) BeforeRequest(ctx *RequestContext) error {
+	req := ctx.Request
 
-	// 读取请求体
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		return err
-	}
-
-	// 解析请求
-	var chatReq ChatRequest
-	if err := json.Unmarshal(body, &chatReq); err != nil {
-		req.Body = io.NopCloser(bytes.NewBuffer(body))
+	// 使用上下文中解析好的请求，内置路由只在 chat/completions 上执行 Mock
+	chatReq, ok := ctx.Chat()
+	if !ok {
 		return nil
 	}
 
-	// 恢复请求体
-	req.Body = io.NopCloser(bytes.NewBuffer(body))
-
 	// 添加调试日志
 	p.logger.Debug("Mock plugin: Checking request",
 		"model", chatReq.Model,
@@ -150,11 +136,11 @@ func (p *MockPlugin) BeforeRequest(req *http.Request) error {
 
 	// 检查是否匹配任何规则
 	for _, rule := range p.rules {
-		if rule.Condition(&chatReq) {
+		if rule.Condition(chatReq) {
 			p.logger.Info("Mock: matched request, generating mock response")
 
 			// 生成响应
-			resp, err := rule.Response(&chatReq)
+			resp, err := rule.Response(chatReq)
 			if err != nil {
 				return err
 			}
@@ -1,12 +1,11 @@
 package plugin
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"strings"
+	"net/url"
+	"sync"
 )
 
 // ModelMapConfig 模型映射插件的配置
@@ -18,6 +17,7 @@ type ModelMapConfig struct {
 type ModelMapPlugin struct {
 	config ModelMapConfig
 	logger Logger
+	mu     sync.RWMutex // 映射可以在运行时通过管理 API 修改
 }
 
 // NewModelMapPlugin 创建新的模型映射插件
@@ -32,52 +32,72 @@ func NewModelMapPlugin(logger Logger) *ModelMapPlugin {
 
 // Configure 配置插件
 func (p *ModelMapPlugin) Configure(config json.RawMessage) error {
-	return json.Unmarshal(config, &p.config)
+	var cfg ModelMapConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return err
+	}
+	if cfg.Mappings == nil {
+		cfg.Mappings = make(map[string]string)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = cfg
+	return nil
 }
 
 // AddMapping 添加模型映射
 func (p *ModelMapPlugin) AddMapping(from, to string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.config.Mappings[from] = to
 }
 
-func (p *ModelMapPlugin) BeforeRequest(req *http.Request) error {
-	// 只处理 chat/completions 请求
-	if !strings.Contains(req.URL.Path, "/chat/completions") {
-		return nil
-	}
+// RemoveMapping 删除模型映射，返回映射是否存在
+func (p *ModelMapPlugin) RemoveMapping(from string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.config.Mappings[from]
+	delete(p.config.Mappings, from)
+	return ok
+}
 
-	// 读取请求体
-	body, err := io.ReadAll(req.Body)
-	if err != nil {
-		return err
+// Mappings 返回当前模型映射的副本
+func (p *ModelMapPlugin) Mappings() map[string]string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make(map[string]string, len(p.config.Mappings))
+	for k, v := range p.config.Mappings {
+		out[k] = v
 	}
-	defer req.Body.Close()
+	return out
+}
 
-	// 解析请求体
-	var requestBody map[string]interface{}
-	if err := json.Unmarshal(body, &requestBody); err != nil {
-		return err
-	}
+// mapped 返回模型映射后的名称
+func (p *ModelMapPlugin) mapped(model string) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	to, ok := p.config.Mappings[model]
+	return to, ok
+}
 
-	// 检查是否存在模型字段
-	if model, ok := requestBody["model"].(string); ok {
-		// 如果存在映射，则替换模型名称
-		if mappedModel, exists := p.config.Mappings[model]; exists {
-			p.logger.Info(fmt.Sprintf("Mapping model from %s to %s", model, mappedModel))
-			requestBody["model"] = mappedModel
+func (p *ModelMapPlugin) BeforeRequest(ctx *RequestContext) error {
+	// 如果存在映射，则替换模型名称，非 JSON 请求交给上游处理
+	model := ctx.Model()
+	if mappedModel, exists := p.mapped(model); exists && model != "" {
+		p.logger.Info(fmt.Sprintf("Mapping model from %s to %s", model, mappedModel))
+		return ctx.SetModel(mappedModel)
+	}
 
-			// 重新编码请求体
-			newBody, err := json.Marshal(requestBody)
-			if err != nil {
-				return err
-			}
+	return nil
+}
 
-			// 替换请求体
-			req.Body = io.NopCloser(bytes.NewBuffer(newBody))
-			req.ContentLength = int64(len(newBody))
-		}
+// BeforeForm 改写 multipart 表单中的 model 字段，内置路由只在音频和图片接口执行模型映射
+func (p *ModelMapPlugin) BeforeForm(req *http.Request, fields url.Values) error {
+	model := fields.Get("model")
+	if mappedModel, exists := p.mapped(model); exists && model != "" {
+		p.logger.Info(fmt.Sprintf("Mapping model from %s to %s", model, mappedModel))
+		fields.Set("model", mappedModel)
 	}
-
 	return nil
 }
 
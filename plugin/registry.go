@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory 根据配置创建插件，config 为空时使用默认配置
+type Factory func(deps Deps, config json.RawMessage) (Plugin, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register 按类型名注册插件的构造函数，供配置文件中的 plugins 使用，类型名重复时 panic
+func Register(typeName string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := factories[typeName]; ok {
+		panic(fmt.Sprintf("plugin: type %q registered twice", typeName))
+	}
+	factories[typeName] = factory
+}
+
+// New 按类型名创建插件
+func New(typeName string, deps Deps, config json.RawMessage) (Plugin, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[typeName]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin type %q", typeName)
+	}
+	return factory(deps, config)
+}
+
+// Types 返回已注册的插件类型名
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	types := make([]string, 0, len(factories))
+	for t := range factories {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// decodeConfig 解析插件配置，空配置保持 v 的零值
+func decodeConfig(config json.RawMessage, v interface{}) error {
+	if len(config) == 0 {
+		return nil
+	}
+	return json.Unmarshal(config, v)
+}
+
+// 内置插件
+func init() {
+	Register("mock", func(deps Deps, config json.RawMessage) (Plugin, error) {
+		return NewMockPlugin(deps.Logger), nil
+	})
+	Register("model_map", func(deps Deps, config json.RawMessage) (Plugin, error) {
+		p := NewModelMapPlugin(deps.Logger)
+		if len(config) > 0 {
+			if err := p.Configure(config); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	})
+	Register("cache", func(deps Deps, config json.RawMessage) (Plugin, error) {
+		var cfg CacheConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return NewCachePlugin(deps.Logger, cfg)
+	})
+	Register("semantic_cache", func(deps Deps, config json.RawMessage) (Plugin, error) {
+		var cfg SemanticCacheConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return NewSemanticCachePlugin(deps.Logger, cfg), nil
+	})
+	Register("script", func(deps Deps, config json.RawMessage) (Plugin, error) {
+		var cfg ScriptConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return NewScriptPlugin(deps.Logger, cfg)
+	})
+	Register("wasm", func(deps Deps, config json.RawMessage) (Plugin, error) {
+		var cfg WasmConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return NewWasmPlugin(deps.Logger, cfg)
+	})
+	Register("callout", func(deps Deps, config json.RawMessage) (Plugin, error) {
+		var cfg CalloutConfig
+		if err := decodeConfig(config, &cfg); err != nil {
+			return nil, err
+		}
+		return NewCalloutPlugin(deps.Logger, cfg)
+	})
+}
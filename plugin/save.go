@@ -7,7 +7,7 @@ type SavePlugin struct {
 	StoragePath string
 }
 
-func (p *SavePlugin) BeforeRequest(req *http.Request) error {
+func (p *SavePlugin) BeforeRequest(ctx *RequestContext) error {
 	// 可以在这里对请求做处理
 	return nil
 }
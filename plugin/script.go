@@ -0,0 +1,605 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/syntax"
+)
+
+// ScriptConfig 脚本插件的配置
+type ScriptConfig struct {
+	Path          string `json:"path"`           // Starlark 脚本文件
+	Name          string `json:"name"`           // 插件名称，默认 script:文件名，同时加载多个脚本时用于区分
+	TimeoutMS     int    `json:"timeout_ms"`     // 每次调用的超时，默认 100 毫秒
+	MaxSteps      uint64 `json:"max_steps"`      // 每次调用最多执行的步数，默认 1000000
+	ReloadSeconds int    `json:"reload_seconds"` // 检查脚本修改的间隔，默认 2 秒，小于 0 时不重新加载
+	FailOpen      bool   `json:"fail_open"`      // 脚本出错时只记录日志，请求继续；默认请求失败
+}
+
+// ScriptPlugin 执行 Starlark 脚本的插件，脚本可以定义以下函数：
+//
+//	before_request(req)   req 包含 method、path、model、headers 和解析后的 body，修改 headers、body 会应用到请求
+//	after_response(resp)  非流式响应，resp 包含 status、headers、body 和 request，修改会应用到响应
+//	on_chunk(chunk)       流式响应的每个 data 事件（解析后的 JSON），返回新的事件替换、False 丢弃、None 保持不变
+//
+// 脚本只能使用 Starlark 内置函数和 json 模块，不能访问文件和网络；修改脚本文件后自动重新加载，
+// 加载失败时继续使用之前的版本
+type ScriptPlugin struct {
+	config ScriptConfig
+	logger Logger
+
+	script atomic.Pointer[compiledScript]
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// compiledScript 加载后的脚本
+type compiledScript struct {
+	modTime       time.Time
+	size          int64
+	beforeRequest starlark.Callable
+	afterResponse starlark.Callable
+	onChunk       starlark.Callable
+}
+
+// NewScriptPlugin 加载脚本并创建插件，脚本有语法错误时返回带行号的错误
+func NewScriptPlugin(logger Logger, config ScriptConfig) (*ScriptPlugin, error) {
+	if config.Path == "" {
+		return nil, errors.New("script path is required")
+	}
+	if config.TimeoutMS <= 0 {
+		config.TimeoutMS = 100
+	}
+	if config.MaxSteps == 0 {
+		config.MaxSteps = 1000000
+	}
+	if config.ReloadSeconds == 0 {
+		config.ReloadSeconds = 2
+	}
+	p := &ScriptPlugin{config: config, logger: logger, stop: make(chan struct{})}
+	script, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	p.script.Store(script)
+	return p, nil
+}
+
+// Name 插件名称
+func (p *ScriptPlugin) Name() string {
+	if p.config.Name != "" {
+		return p.config.Name
+	}
+	return "script:" + filepath.Base(p.config.Path)
+}
+
+// Configure 脚本插件的配置在创建时确定
+func (p *ScriptPlugin) Configure(config json.RawMessage) error {
+	return errors.New("script plugin can not be reconfigured, register a new one instead")
+}
+
+// Init 开始监视脚本文件的修改
+func (p *ScriptPlugin) Init(ctx context.Context, deps Deps) error {
+	if p.config.ReloadSeconds < 0 {
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(p.config.ReloadSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.reload()
+			}
+		}
+	}()
+	return nil
+}
+
+// Close 停止监视脚本文件
+func (p *ScriptPlugin) Close() error {
+	p.once.Do(func() { close(p.stop) })
+	return nil
+}
+
+// reload 脚本文件有修改时重新加载
+func (p *ScriptPlugin) reload() {
+	info, err := os.Stat(p.config.Path)
+	if err != nil {
+		return
+	}
+	current := p.script.Load()
+	if info.ModTime().Equal(current.modTime) && info.Size() == current.size {
+		return
+	}
+	script, err := p.load()
+	if err != nil {
+		p.logger.Error("Failed to reload script, keep using the previous version:", err)
+		// 记录修改时间，修复之前不再重复报错
+		failed := *current
+		failed.modTime, failed.size = info.ModTime(), info.Size()
+		p.script.Store(&failed)
+		return
+	}
+	p.script.Store(script)
+	p.logger.Info("Reloaded script", p.config.Path)
+}
+
+// load 读取、编译并执行脚本的顶层代码
+func (p *ScriptPlugin) load() (*compiledScript, error) {
+	info, err := os.Stat(p.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	src, err := os.ReadFile(p.config.Path)
+	if err != nil {
+		return nil, err
+	}
+	thread := p.newThread()
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true}
+	predeclared := starlark.StringDict{"json": starlarkjson.Module}
+	globals, err := starlark.ExecFileOptions(opts, thread, p.config.Path, src, predeclared)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	globals.Freeze()
+
+	script := &compiledScript{modTime: info.ModTime(), size: info.Size()}
+	for name, fn := range map[string]*starlark.Callable{
+		"before_request": &script.beforeRequest,
+		"after_response": &script.afterResponse,
+		"on_chunk":       &script.onChunk,
+	} {
+		if v, ok := globals[name]; ok {
+			callable, ok := v.(starlark.Callable)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s is not a function", p.config.Path, name)
+			}
+			*fn = callable
+		}
+	}
+	return script, nil
+}
+
+// newThread 创建执行脚本的线程，限制执行步数和时间，print 输出到日志
+func (p *ScriptPlugin) newThread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: p.Name(),
+		Print: func(_ *starlark.Thread, msg string) {
+			p.logger.Info("["+p.Name()+"]", msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(p.config.MaxSteps)
+	return thread
+}
+
+// call 调用脚本函数，超时后取消
+func (p *ScriptPlugin) call(fn starlark.Callable, args ...starlark.Value) (starlark.Value, error) {
+	thread := p.newThread()
+	timer := time.AfterFunc(time.Duration(p.config.TimeoutMS)*time.Millisecond, func() {
+		thread.Cancel("timeout")
+	})
+	defer timer.Stop()
+	v, err := starlark.Call(thread, fn, args, nil)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	return v, nil
+}
+
+// fail 处理脚本错误：FailOpen 时只记录日志
+func (p *ScriptPlugin) fail(err error) error {
+	if p.config.FailOpen {
+		p.logger.Error("Script error:", err)
+		return nil
+	}
+	return err
+}
+
+// scriptError 带上脚本的调用栈（文件名和行号）
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+func (p *ScriptPlugin) BeforeRequest(ctx *RequestContext) error {
+	script := p.script.Load()
+	if script.beforeRequest == nil {
+		return nil
+	}
+
+	body := ctx.Body()
+	req := starlark.NewDict(5)
+	_ = req.SetKey(starlark.String("method"), starlark.String(ctx.Request.Method))
+	_ = req.SetKey(starlark.String("path"), starlark.String(ctx.Path()))
+	_ = req.SetKey(starlark.String("model"), starlark.String(ctx.Model()))
+	headers := headersValue(ctx.Request.Header)
+	_ = req.SetKey(starlark.String("headers"), headers)
+	reqBody, err := bodyValue(body)
+	if err != nil {
+		return err
+	}
+	_ = req.SetKey(starlark.String("body"), reqBody)
+
+	if _, err := p.call(script.beforeRequest, req); err != nil {
+		return p.fail(err)
+	}
+
+	// 应用脚本对请求头和请求体的修改
+	if err := applyHeaders(ctx.Request.Header, req); err != nil {
+		return p.fail(err)
+	}
+	original, _ := bodyValue(body)
+	newBody, changed, err := changedBody(req, original)
+	if err != nil {
+		return p.fail(err)
+	}
+	if changed {
+		ctx.SetBody(newBody)
+		ctx.Request.ContentLength = int64(len(newBody))
+	}
+	return nil
+}
+
+func (p *ScriptPlugin) AfterResponse(resp *http.Response) error {
+	script := p.script.Load()
+	// 压缩的响应体交给客户端解压，不经过脚本
+	if resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		if script.onChunk != nil {
+			resp.Body = newChunkReader(resp.Body, func(data []byte) ([]byte, bool, error) {
+				return p.chunk(script.onChunk, data)
+			})
+		}
+		return nil
+	}
+	if script.afterResponse == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	value := starlark.NewDict(4)
+	_ = value.SetKey(starlark.String("status"), starlark.MakeInt(resp.StatusCode))
+	_ = value.SetKey(starlark.String("headers"), headersValue(resp.Header))
+	respBody, err := bodyValue(body)
+	if err != nil {
+		return err
+	}
+	_ = value.SetKey(starlark.String("body"), respBody)
+	request := starlark.NewDict(2)
+	if resp.Request != nil {
+		_ = request.SetKey(starlark.String("method"), starlark.String(resp.Request.Method))
+		_ = request.SetKey(starlark.String("path"), starlark.String(resp.Request.URL.Path))
+	}
+	_ = value.SetKey(starlark.String("request"), request)
+
+	if _, err := p.call(script.afterResponse, value); err != nil {
+		return p.fail(err)
+	}
+
+	if v, found, _ := value.Get(starlark.String("status")); found {
+		if status, err := starlark.AsInt32(v); err == nil && status != resp.StatusCode {
+			resp.StatusCode = status
+			resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		}
+	}
+	if err := applyHeaders(resp.Header, value); err != nil {
+		return p.fail(err)
+	}
+	original, _ := bodyValue(body)
+	newBody, changed, err := changedBody(value, original)
+	if err != nil {
+		return p.fail(err)
+	}
+	if changed {
+		resp.Body = io.NopCloser(bytes.NewReader(newBody))
+		resp.ContentLength = int64(len(newBody))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(newBody)))
+	}
+	return nil
+}
+
+// chunk 对一个 data 事件调用 on_chunk，返回新的数据和是否保留事件
+func (p *ScriptPlugin) chunk(fn starlark.Callable, data []byte) ([]byte, bool, error) {
+	event, err := bodyValue(data)
+	if err != nil {
+		return data, true, nil
+	}
+	result, err := p.call(fn, event)
+	if err != nil {
+		return data, true, p.fail(err)
+	}
+	switch result {
+	case starlark.None:
+		return data, true, nil
+	case starlark.False:
+		return nil, false, nil
+	}
+	if s, ok := result.(starlark.String); ok {
+		return []byte(s.GoString()), true, nil
+	}
+	out, err := encodeValue(result)
+	if err != nil {
+		return data, true, p.fail(err)
+	}
+	return out, true, nil
+}
+
+// headersValue 把 HTTP 头转换为 Starlark 字典，多个值用逗号连接
+func headersValue(header http.Header) *starlark.Dict {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	d := starlark.NewDict(len(keys))
+	for _, k := range keys {
+		_ = d.SetKey(starlark.String(k), starlark.String(strings.Join(header[k], ", ")))
+	}
+	return d
+}
+
+// applyHeaders 把脚本修改后的 headers 写回，删除的头也会被删除
+func applyHeaders(header http.Header, value *starlark.Dict) error {
+	v, found, _ := value.Get(starlark.String("headers"))
+	if !found {
+		return nil
+	}
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return fmt.Errorf("headers must be a dict, got %s", v.Type())
+	}
+	seen := make(map[string]bool, d.Len())
+	for _, item := range d.Items() {
+		k, ok1 := starlark.AsString(item[0])
+		v, ok2 := starlark.AsString(item[1])
+		if !ok1 || !ok2 {
+			return fmt.Errorf("header %s must be a string", item[0])
+		}
+		k = http.CanonicalHeaderKey(k)
+		seen[k] = true
+		if strings.Join(header[k], ", ") != v {
+			header.Set(k, v)
+		}
+	}
+	for k := range header {
+		if !seen[k] {
+			header.Del(k)
+		}
+	}
+	return nil
+}
+
+// changedBody 脚本修改了 body 时返回重新编码的内容
+func changedBody(value *starlark.Dict, original starlark.Value) ([]byte, bool, error) {
+	v, found, _ := value.Get(starlark.String("body"))
+	if !found {
+		return nil, false, nil
+	}
+	if equal, err := starlark.Equal(v, original); err == nil && equal {
+		return nil, false, nil
+	}
+	if s, ok := v.(starlark.String); ok {
+		return []byte(s.GoString()), true, nil
+	}
+	out, err := encodeValue(v)
+	return out, true, err
+}
+
+// bodyValue 把 JSON 转换为 Starlark 的值，非 JSON 内容转换为字符串
+func bodyValue(body []byte) (starlark.Value, error) {
+	if len(body) == 0 {
+		return starlark.None, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return starlark.String(body), nil
+	}
+	return toStarlark(v)
+}
+
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return starlark.MakeInt64(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Float(f), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for i, e := range v {
+			sv, err := toStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = sv
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(v))
+		for _, k := range keys {
+			sv, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			_ = d.SetKey(starlark.String(k), sv)
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("unsupported JSON value %T", v)
+}
+
+// encodeValue 把 Starlark 的值编码为 JSON
+func encodeValue(v starlark.Value) ([]byte, error) {
+	goValue, err := fromStarlark(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(goValue)
+}
+
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return v.GoString(), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return json.Number(v.String()), nil
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return fromIterable(v)
+	case starlark.Tuple:
+		return fromIterable(v)
+	case *starlark.Dict:
+		out := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			gv, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			out[k] = gv
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("can not convert %s to JSON", v.Type())
+}
+
+func fromIterable(v starlark.Indexable) ([]interface{}, error) {
+	out := make([]interface{}, v.Len())
+	for i := range out {
+		gv, err := fromStarlark(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = gv
+	}
+	return out, nil
+}
+
+// chunkReader 逐个读取 SSE 事件，data 为 JSON 对象的事件交给 transform 处理
+type chunkReader struct {
+	src       *bufio.Reader
+	closer    io.Closer
+	transform func(data []byte) ([]byte, bool, error)
+	pending   bytes.Buffer
+	err       error
+}
+
+func newChunkReader(body io.ReadCloser, transform func(data []byte) ([]byte, bool, error)) *chunkReader {
+	return &chunkReader{src: bufio.NewReader(body), closer: body, transform: transform}
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	for r.pending.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+	return r.pending.Read(b)
+}
+
+func (r *chunkReader) Close() error {
+	return r.closer.Close()
+}
+
+// next 读取下一个事件（直到空行）并写入 pending
+func (r *chunkReader) next() {
+	var event []byte
+	for {
+		line, err := r.src.ReadBytes('\n')
+		event = append(event, line...)
+		if err != nil {
+			r.err = err
+			break
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+	}
+	if len(event) == 0 {
+		return
+	}
+
+	// 只处理单行 data 的 JSON 对象，其它内容（注释、[DONE]）原样输出
+	trimmed := bytes.TrimRight(event, "\r\n")
+	data, ok := bytes.CutPrefix(trimmed, []byte("data:"))
+	if !ok || bytes.ContainsAny(data, "\n") {
+		r.pending.Write(event)
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		r.pending.Write(event)
+		return
+	}
+	out, keep, err := r.transform(data)
+	if err != nil {
+		r.err = err
+		return
+	}
+	if !keep {
+		return
+	}
+	r.pending.WriteString("data: ")
+	r.pending.Write(out)
+	r.pending.WriteString("\n\n")
+}
@@ -0,0 +1,421 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecentSemanticHits 保留的最近命中记录数量
+	maxRecentSemanticHits = 50
+	// semanticNearMissMargin 最近邻相似度低于阈值但在该范围内时记为接近命中，用于调整阈值
+	semanticNearMissMargin = 0.05
+)
+
+// SemanticCacheConfig 语义缓存插件的配置
+type SemanticCacheConfig struct {
+	Threshold       float64             `json:"threshold"`       // 默认的相似度阈值，默认 0.95
+	Thresholds      []SemanticThreshold `json:"thresholds"`      // 按路径和模型覆盖阈值，第一个匹配的规则生效
+	MaxEntries      int                 `json:"max_entries"`     // 索引的条目上限，默认 1000，超出时淘汰最早的条目
+	TTLSeconds      int                 `json:"ttl_seconds"`     // 缓存有效期，默认 3600 秒
+	Models          []string            `json:"models"`          // 启用语义缓存的模型，为空表示所有模型
+	EmbeddingURL    string              `json:"embedding_url"`   // OpenAI 兼容的 embeddings 接口地址（如 http://localhost:8899/v1），为空时使用本地替代实现
+	EmbeddingModel  string              `json:"embedding_model"` // embeddings 模型
+	EmbeddingAPIKey string              `json:"embedding_api_key"`
+	LocalDimensions int                 `json:"local_dimensions"`  // 本地替代实现的向量维度，默认 512
+	ShareAcrossKeys bool                `json:"share_across_keys"` // 不同客户端 key 共享缓存，默认按 key 隔离
+}
+
+// SemanticThreshold 按路由设置的相似度阈值
+type SemanticThreshold struct {
+	Path      string  `json:"path"`  // 请求路径前缀，为空匹配所有路径
+	Model     string  `json:"model"` // 模型，为空匹配所有模型
+	Threshold float64 `json:"threshold"`
+}
+
+// SemanticHit 一次语义缓存命中，用于人工检查命中质量
+type SemanticHit struct {
+	Time       time.Time `json:"time"`
+	Model      string    `json:"model"`
+	Query      string    `json:"query"`
+	Matched    string    `json:"matched"`
+	Similarity float64   `json:"similarity"`
+}
+
+// SemanticCacheStats 语义缓存统计
+type SemanticCacheStats struct {
+	Hits          int64            `json:"hits"`
+	Misses        int64            `json:"misses"`
+	NearMisses    int64            `json:"near_misses"` // 最近邻相似度略低于阈值的未命中
+	Bypasses      int64            `json:"bypasses"`
+	Stores        int64            `json:"stores"`
+	Errors        int64            `json:"errors"` // 计算向量失败的次数
+	Entries       int              `json:"entries"`
+	AvgSimilarity float64          `json:"avg_similarity"` // 命中时的平均相似度
+	MinSimilarity float64          `json:"min_similarity"` // 命中时的最低相似度
+	Histogram     map[string]int64 `json:"histogram"`      // 命中相似度分布，按 0.01 分桶
+	RecentHits    []SemanticHit    `json:"recent_hits"`
+}
+
+// semanticEntry 索引中的一个条目
+type semanticEntry struct {
+	partition string
+	question  string
+	vector    []float32
+	entry     *CacheEntry
+}
+
+// SemanticCachePlugin 语义缓存插件：对最后一条用户消息计算向量，在相同客户端 key、相同模型、相同系统提示词和上下文的请求中
+// 查找相似度超过阈值的问题并返回缓存的回答
+//
+// 请求头 X-Semantic-Cache: off 或 Cache-Control: no-store 跳过语义缓存，Cache-Control: no-cache 跳过读取但仍然写入
+type SemanticCachePlugin struct {
+	config   SemanticCacheConfig
+	embedder Embedder
+	logger   Logger
+
+	mu      sync.Mutex
+	entries []*semanticEntry
+	stats   SemanticCacheStats
+	simSum  float64
+}
+
+// NewSemanticCachePlugin 创建新的语义缓存插件
+func NewSemanticCachePlugin(logger Logger, config SemanticCacheConfig) *SemanticCachePlugin {
+	p := &SemanticCachePlugin{logger: logger}
+	p.apply(config)
+	return p
+}
+
+// Configure 配置插件，已有的缓存条目会被清空
+func (p *SemanticCachePlugin) Configure(config json.RawMessage) error {
+	var cfg SemanticCacheConfig
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return err
+	}
+	p.apply(cfg)
+	return nil
+}
+
+func (p *SemanticCachePlugin) apply(config SemanticCacheConfig) {
+	if config.Threshold <= 0 {
+		config.Threshold = 0.95
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = 1000
+	}
+	if config.TTLSeconds <= 0 {
+		config.TTLSeconds = 3600
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+	if config.EmbeddingURL != "" {
+		p.embedder = &HTTPEmbedder{BaseURL: config.EmbeddingURL, Model: config.EmbeddingModel, APIKey: config.EmbeddingAPIKey}
+	} else {
+		p.embedder = &HashEmbedder{Dimensions: config.LocalDimensions}
+	}
+	p.entries = nil
+}
+
+// SetEmbedder 替换计算向量的实现
+func (p *SemanticCachePlugin) SetEmbedder(embedder Embedder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.embedder = embedder
+	p.entries = nil
+}
+
+// Stats 返回语义缓存统计
+func (p *SemanticCachePlugin) Stats() SemanticCacheStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Entries = len(p.entries)
+	if stats.Hits > 0 {
+		stats.AvgSimilarity = p.simSum / float64(stats.Hits)
+	}
+	stats.Histogram = make(map[string]int64, len(p.stats.Histogram))
+	for k, v := range p.stats.Histogram {
+		stats.Histogram[k] = v
+	}
+	stats.RecentHits = append([]SemanticHit(nil), p.stats.RecentHits...)
+	return stats
+}
+
+func (p *SemanticCachePlugin) BeforeRequest(ctx *RequestContext) error {
+	return nil
+}
+
+func (p *SemanticCachePlugin) AfterResponse(resp *http.Response) error {
+	return nil
+}
+
+// semanticRequest 语义缓存关心的 chat 请求字段
+type semanticRequest struct {
+	Model          string            `json:"model"`
+	Stream         bool              `json:"stream"`
+	Messages       []json.RawMessage `json:"messages"`
+	Tools          json.RawMessage   `json:"tools"`
+	ResponseFormat json.RawMessage   `json:"response_format"`
+}
+
+// Respond 查找相似的问题，命中时直接写出缓存的回答，未命中时返回记录回答的函数
+func (p *SemanticCachePlugin) Respond(w http.ResponseWriter, rctx *RequestContext) (bool, func(*Response), error) {
+	req := rctx.Request
+	if req.Method != http.MethodPost || !strings.Contains(req.URL.Path, "/chat/completions") {
+		return false, nil, nil
+	}
+
+	var sreq semanticRequest
+	if err := rctx.Decode(&sreq); err != nil || len(sreq.Messages) == 0 || !p.enabled(sreq.Model) {
+		return false, nil, nil
+	}
+	var last struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(sreq.Messages[len(sreq.Messages)-1], &last); err != nil || last.Role != "user" {
+		return false, nil, nil
+	}
+	question := messageText(last.Content)
+	if question == "" {
+		return false, nil, nil
+	}
+
+	cacheControl := strings.ToLower(req.Header.Get("Cache-Control"))
+	if strings.EqualFold(req.Header.Get("X-Semantic-Cache"), "off") || strings.Contains(cacheControl, "no-store") {
+		p.count(func(s *SemanticCacheStats) { s.Bypasses++ })
+		w.Header().Set("X-Semantic-Cache", CacheBypass)
+		return false, nil, nil
+	}
+
+	p.mu.Lock()
+	embedder := p.embedder
+	p.mu.Unlock()
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	vector, err := embedder.Embed(ctx, question)
+	cancel()
+	if err != nil {
+		// 计算向量失败时不影响请求，按未命中处理
+		p.logger.Error("Semantic cache embedding failed:", err)
+		p.count(func(s *SemanticCacheStats) { s.Errors++ })
+		return false, nil, nil
+	}
+
+	partition := semanticPartition(p.partitionKey(req.Header), sreq)
+	threshold := p.threshold(req, sreq.Model)
+	if strings.Contains(cacheControl, "no-cache") {
+		p.count(func(s *SemanticCacheStats) { s.Bypasses++ })
+		w.Header().Set("X-Semantic-Cache", CacheBypass)
+	} else if match, similarity := p.search(partition, vector); match != nil && similarity >= threshold {
+		p.recordHit(sreq.Model, question, match.question, similarity)
+		p.logger.Info(fmt.Sprintf("Semantic cache hit for model %s (similarity %.4f)", sreq.Model, similarity))
+		w.Header().Set("X-Semantic-Cache", CacheHit)
+		w.Header().Set("X-Semantic-Similarity", strconv.FormatFloat(similarity, 'f', 4, 64))
+		writeCacheEntry(w, match.entry)
+		return true, nil, nil
+	} else {
+		p.count(func(s *SemanticCacheStats) {
+			s.Misses++
+			if match != nil && similarity >= threshold-semanticNearMissMargin {
+				s.NearMisses++
+			}
+		})
+		w.Header().Set("X-Semantic-Cache", CacheMiss)
+	}
+
+	return false, func(resp *Response) {
+		p.store(partition, sreq.Model, question, vector, resp)
+	}, nil
+}
+
+// enabled 判断模型是否启用语义缓存
+func (p *SemanticCachePlugin) enabled(model string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.config.Models) == 0 {
+		return true
+	}
+	for _, m := range p.config.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionKey 返回参与分区的客户端 key，ShareAcrossKeys 时为空
+func (p *SemanticCachePlugin) partitionKey(h http.Header) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.config.ShareAcrossKeys {
+		return ""
+	}
+	return clientKey(h)
+}
+
+// threshold 返回请求适用的相似度阈值，请求头 X-Semantic-Cache-Threshold 可以提高阈值
+func (p *SemanticCachePlugin) threshold(req *http.Request, model string) float64 {
+	p.mu.Lock()
+	threshold := p.config.Threshold
+	for _, t := range p.config.Thresholds {
+		if (t.Path == "" || strings.HasPrefix(req.URL.Path, t.Path)) && (t.Model == "" || t.Model == model) {
+			threshold = t.Threshold
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if v, err := strconv.ParseFloat(req.Header.Get("X-Semantic-Cache-Threshold"), 64); err == nil && v > threshold {
+		threshold = v
+	}
+	return threshold
+}
+
+// search 在同一分区中查找最相似的未过期条目
+func (p *SemanticCachePlugin) search(partition string, vector []float32) (*semanticEntry, float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var best *semanticEntry
+	bestSimilarity := math.Inf(-1)
+	live := p.entries[:0]
+	for _, e := range p.entries {
+		if e.entry.Expired() {
+			continue
+		}
+		live = append(live, e)
+		if e.partition != partition {
+			continue
+		}
+		if similarity := cosineSimilarity(vector, e.vector); similarity > bestSimilarity {
+			best, bestSimilarity = e, similarity
+		}
+	}
+	// 顺便清理过期的条目
+	for i := len(live); i < len(p.entries); i++ {
+		p.entries[i] = nil
+	}
+	p.entries = live
+	return best, bestSimilarity
+}
+
+// store 保存完整的成功回答，相同分区中相同的问题只保留最新的回答
+func (p *SemanticCachePlugin) store(partition, model, question string, vector []float32, resp *Response) {
+	if !completeResponse(resp) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	entry := &semanticEntry{
+		partition: partition,
+		question:  question,
+		vector:    vector,
+		entry: &CacheEntry{
+			Model:      model,
+			StatusCode: resp.StatusCode,
+			Header:     http.Header{"Content-Type": {resp.Header.Get("Content-Type")}},
+			Body:       resp.Body,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(time.Duration(p.config.TTLSeconds) * time.Second),
+		},
+	}
+	for i, e := range p.entries {
+		if e.partition == partition && e.question == question {
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			break
+		}
+	}
+	p.entries = append(p.entries, entry)
+	if over := len(p.entries) - p.config.MaxEntries; over > 0 {
+		p.entries = append([]*semanticEntry(nil), p.entries[over:]...)
+	}
+	p.stats.Stores++
+}
+
+func (p *SemanticCachePlugin) recordHit(model, query, matched string, similarity float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Hits++
+	p.simSum += similarity
+	if p.stats.Hits == 1 || similarity < p.stats.MinSimilarity {
+		p.stats.MinSimilarity = similarity
+	}
+	if p.stats.Histogram == nil {
+		p.stats.Histogram = make(map[string]int64)
+	}
+	p.stats.Histogram[strconv.FormatFloat(math.Floor(similarity*100)/100, 'f', 2, 64)]++
+	p.stats.RecentHits = append(p.stats.RecentHits, SemanticHit{
+		Time:       time.Now(),
+		Model:      model,
+		Query:      query,
+		Matched:    matched,
+		Similarity: similarity,
+	})
+	if len(p.stats.RecentHits) > maxRecentSemanticHits {
+		p.stats.RecentHits = p.stats.RecentHits[len(p.stats.RecentHits)-maxRecentSemanticHits:]
+	}
+}
+
+func (p *SemanticCachePlugin) count(fn func(s *SemanticCacheStats)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(&p.stats)
+}
+
+// semanticPartition 计算分区：客户端 key 的哈希、模型、是否流式、工具和输出格式，以及最后一条用户消息之前的所有消息（系统提示词和上下文）
+func semanticPartition(key string, sreq semanticRequest) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "key: %x\n", sha256.Sum256([]byte(key)))
+	fmt.Fprintf(h, "%s\n%t\n", sreq.Model, sreq.Stream)
+	h.Write(sreq.Tools)
+	h.Write([]byte{0})
+	h.Write(sreq.ResponseFormat)
+	for _, msg := range sreq.Messages[:len(sreq.Messages)-1] {
+		h.Write([]byte{0})
+		// 消息重新编码，忽略字段顺序和空白的差异
+		var v interface{}
+		if err := json.Unmarshal(msg, &v); err == nil {
+			normalized, _ := json.Marshal(v)
+			h.Write(normalized)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// messageText 提取消息内容中的文本，content 可以是字符串或内容片段数组
+func messageText(content json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		} else {
+			// 包含图片等非文本内容时不使用语义缓存
+			return ""
+		}
+	}
+	return strings.Join(texts, "\n")
+}
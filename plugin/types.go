@@ -1,17 +1,103 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"net/url"
 )
 
 // Plugin 接口定义
+//
+// BeforeRequest 收到请求的上下文，请求体已经读取，通过上下文读取和修改，不要直接读取 Request.Body
 type Plugin interface {
-	BeforeRequest(*http.Request) error
+	BeforeRequest(*RequestContext) error
 	AfterResponse(*http.Response) error
 	Configure(json.RawMessage) error // 添加配置方法
 }
 
+// Named 有名称的插件，名称用于启停、排序和管理 API，同一个代理中不能重复；未实现时使用类型名，如 ModelMapPlugin
+type Named interface {
+	Name() string
+}
+
+// Deps 插件可以使用的依赖
+type Deps struct {
+	Logger Logger
+}
+
+// Initializer 注册时需要初始化的插件（如建立连接、加载文件），Init 返回错误时插件不会被注册
+type Initializer interface {
+	Init(ctx context.Context, deps Deps) error
+}
+
+// Closer 移除插件或关闭代理时需要释放资源的插件
+type Closer interface {
+	Close() error
+}
+
+// Prioritized 指定执行顺序的插件，Priority 小的先执行，未实现时为 0，相同优先级按注册顺序执行
+type Prioritized interface {
+	Priority() int
+}
+
+// Switchable 可以自行停用的插件（如缺少必要的配置），Enabled 返回 false 时不执行
+type Switchable interface {
+	Enabled() bool
+}
+
+// RejectError 插件拒绝请求时返回的错误，代理以 Status 返回 OpenAI 格式的错误；其它错误返回 500
+type RejectError struct {
+	Status  int
+	Message string
+	Code    string // 错误响应中的 code，如 policy_denied
+}
+
+func (e *RejectError) Error() string {
+	return e.Message
+}
+
+// FormPlugin 可以读取和改写 multipart/form-data 表单字段的插件
+//
+// multipart 请求体以流的方式转发，不会调用 BeforeRequest，而是对文本字段调用 BeforeForm；是否放行由 AdmitPlugin 决定
+type FormPlugin interface {
+	BeforeForm(req *http.Request, fields url.Values) error
+}
+
+// AdmitPlugin 可以决定是否放行 multipart 和 WebSocket 请求的插件（如外部策略）
+//
+// 这两类请求不会调用 BeforeRequest，Admit 在转发前调用，ctx 的请求体只是由 model 等字段组成的摘要，
+// 对请求体的修改不会生效；返回错误时拒绝请求，RejectError 的处理与 BeforeRequest 相同
+type AdmitPlugin interface {
+	Admit(ctx *RequestContext) error
+}
+
+// WebSocket 事件方向
+const (
+	EventFromClient   = "client"   // 客户端发往上游
+	EventFromUpstream = "upstream" // 上游发往客户端
+)
+
+// EventPlugin 可以观察 WebSocket 会话（如 Realtime API）中 JSON 事件的插件，事件只读
+type EventPlugin interface {
+	OnEvent(req *http.Request, direction string, event []byte)
+}
+
+// Response 插件直接给出或由代理记录下来的响应
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// ResponsePlugin 可以跳过上游直接响应的插件（如缓存）
+//
+// Respond 在所有插件的 BeforeRequest 之后调用，返回 true 表示插件已经写出响应，请求不再转发到上游；
+// 否则 record 不为 nil 时，代理会在响应完整写回客户端后把响应交给 record，中途失败的响应不会交给 record
+type ResponsePlugin interface {
+	Respond(w http.ResponseWriter, ctx *RequestContext) (handled bool, record func(*Response), err error)
+}
+
 // Logger 日志接口定义
 type Logger interface {
 	Debug(args ...interface{})
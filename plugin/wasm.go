@@ -0,0 +1,500 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// WasmConfig WASM 插件的配置
+type WasmConfig struct {
+	Path          string          `json:"path"`            // .wasm 文件
+	Name          string          `json:"name"`            // 插件名称，默认 wasm:文件名
+	Config        json.RawMessage `json:"config"`          // 传给模块 configure 函数的配置
+	TimeoutMS     int             `json:"timeout_ms"`      // 每次调用的超时，默认 100 毫秒，超时的实例会被销毁
+	MemoryLimitMB int             `json:"memory_limit_mb"` // 每个实例的内存上限，默认 64MB
+	MaxInstances  int             `json:"max_instances"`   // 并发执行的实例数量，默认 4
+	FailOpen      bool            `json:"fail_open"`       // 模块出错时只记录日志，请求继续；默认请求失败
+}
+
+// WASM 模块导出的函数
+//
+// 输入和输出都是 JSON：宿主调用 malloc(len) 在模块内存中分配输入，调用钩子 hook(ptr, len)，
+// 钩子返回 (ptr << 32 | len) 指向输出，返回 0 表示不做修改；模块导出 free(ptr, len) 时宿主会释放输入和输出
+const (
+	wasmMalloc        = "malloc"
+	wasmFree          = "free"
+	wasmConfigure     = "configure"      // 实例创建后调用一次，输入为配置中的 config
+	wasmBeforeRequest = "before_request" // 输入 wasmRequest，输出 wasmResult
+	wasmAfterResponse = "after_response" // 输入 wasmResponse，输出 wasmResult
+	wasmOnChunk       = "on_chunk"       // 输入流式响应的一个 data 事件，输出 wasmResult 的 chunk 或 drop
+)
+
+// wasmRequest before_request 的输入
+type wasmRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Model   string            `json:"model"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// wasmResponse after_response 的输入
+type wasmResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Request struct {
+		Method string `json:"method"`
+		Path   string `json:"path"`
+	} `json:"request"`
+}
+
+// wasmResult 钩子的输出，只应用出现的字段
+type wasmResult struct {
+	Status  int                `json:"status,omitempty"`  // 新的响应状态码
+	Headers map[string]*string `json:"headers,omitempty"` // 设置的头，值为 null 时删除
+	Body    json.RawMessage    `json:"body,omitempty"`    // 新的请求体或响应体
+	Chunk   json.RawMessage    `json:"chunk,omitempty"`   // 新的事件
+	Drop    bool               `json:"drop,omitempty"`    // 丢弃事件
+	Error   string             `json:"error,omitempty"`   // 拒绝请求
+}
+
+// WasmPlugin 在 WASM 沙箱中执行第三方插件，模块只能访问宿主传入的数据，不能访问文件和网络
+//
+// 模块可以使用 WASI 的标准输出（写入代理日志），也可以调用宿主导入的 env.log(ptr, len)
+type WasmPlugin struct {
+	config WasmConfig
+	logger Logger
+
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	hooks    map[string]bool
+
+	slots chan struct{}   // 限制并发执行的实例数量
+	idle  chan api.Module // 空闲的实例
+	mu    sync.Mutex      // 保护 guest 配置
+	guest json.RawMessage // 传给 configure 的配置
+	gen   int             // 配置的版本，旧版本的实例不再复用
+	gens  map[api.Module]int
+}
+
+// NewWasmPlugin 编译 WASM 模块并创建插件
+func NewWasmPlugin(logger Logger, config WasmConfig) (*WasmPlugin, error) {
+	if config.Path == "" {
+		return nil, errors.New("wasm path is required")
+	}
+	if config.TimeoutMS <= 0 {
+		config.TimeoutMS = 100
+	}
+	if config.MemoryLimitMB <= 0 {
+		config.MemoryLimitMB = 64
+	}
+	if config.MaxInstances <= 0 {
+		config.MaxInstances = 4
+	}
+	code, err := os.ReadFile(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	// 每页 64KB
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(config.MemoryLimitMB) * 16).
+		WithCloseOnContextDone(true)
+	p := &WasmPlugin{
+		config:  config,
+		logger:  logger,
+		runtime: wazero.NewRuntimeWithConfig(ctx, runtimeConfig),
+		hooks:   make(map[string]bool),
+		slots:   make(chan struct{}, config.MaxInstances),
+		idle:    make(chan api.Module, config.MaxInstances),
+		guest:   config.Config,
+		gens:    make(map[api.Module]int),
+	}
+	if err := p.compile(ctx, code); err != nil {
+		p.runtime.Close(ctx)
+		return nil, fmt.Errorf("%s: %w", config.Path, err)
+	}
+	return p, nil
+}
+
+func (p *WasmPlugin) compile(ctx context.Context, code []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+		return err
+	}
+	_, err := p.runtime.NewHostModuleBuilder("env").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, m api.Module, ptr, size uint32) {
+			if msg, ok := m.Memory().Read(ptr, size); ok {
+				p.logger.Info("["+p.Name()+"]", string(msg))
+			}
+		}).
+		Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return err
+	}
+
+	compiled, err := p.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return err
+	}
+	exports := compiled.ExportedFunctions()
+	if _, ok := exports[wasmMalloc]; !ok {
+		return fmt.Errorf("module does not export %s", wasmMalloc)
+	}
+	for _, hook := range []string{wasmBeforeRequest, wasmAfterResponse, wasmOnChunk} {
+		if _, ok := exports[hook]; ok {
+			p.hooks[hook] = true
+		}
+	}
+	if len(p.hooks) == 0 {
+		return errors.New("module exports none of before_request, after_response and on_chunk")
+	}
+	p.compiled = compiled
+	return nil
+}
+
+// Name 插件名称
+func (p *WasmPlugin) Name() string {
+	if p.config.Name != "" {
+		return p.config.Name
+	}
+	return "wasm:" + strings.TrimSuffix(filepath.Base(p.config.Path), ".wasm")
+}
+
+// Configure 更新传给模块的配置，之后创建的实例使用新的配置
+func (p *WasmPlugin) Configure(config json.RawMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.guest = config
+	p.gen++
+	return nil
+}
+
+// Close 销毁所有实例和运行时
+func (p *WasmPlugin) Close() error {
+	return p.runtime.Close(context.Background())
+}
+
+// instantiate 创建新的实例并调用 configure
+func (p *WasmPlugin) instantiate(ctx context.Context) (api.Module, error) {
+	out := &wasmLogWriter{logger: p.logger, prefix: "[" + p.Name() + "]"}
+	// reactor 模块（如 TinyGo、Go 的 c-shared）需要先调用 _initialize
+	conf := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize").
+		WithStdout(out).WithStderr(out)
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	guest, gen := p.guest, p.gen
+	p.gens[mod] = gen
+	p.mu.Unlock()
+	if mod.ExportedFunction(wasmConfigure) != nil && len(guest) > 0 {
+		out, err := p.invoke(ctx, mod, wasmConfigure, guest)
+		if err == nil {
+			err = resultError(out)
+		}
+		if err != nil {
+			p.discard(mod)
+			return nil, fmt.Errorf("configure: %w", err)
+		}
+	}
+	return mod, nil
+}
+
+// call 在一个实例中调用钩子，超时或出错的实例会被销毁
+func (p *WasmPlugin) call(hook string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.config.TimeoutMS)*time.Millisecond)
+	defer cancel()
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s: no free instance: %w", p.Name(), ctx.Err())
+	}
+	defer func() { <-p.slots }()
+
+	var mod api.Module
+	select {
+	case mod = <-p.idle:
+	default:
+		var err error
+		if mod, err = p.instantiate(ctx); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+	}
+
+	out, err := p.invoke(ctx, mod, hook, input)
+	if err != nil {
+		p.discard(mod)
+		return nil, fmt.Errorf("%s: %s: %w", p.Name(), hook, err)
+	}
+	p.release(mod)
+	return out, nil
+}
+
+// invoke 把输入写入实例的内存并调用函数，返回输出的副本
+func (p *WasmPlugin) invoke(ctx context.Context, mod api.Module, name string, input []byte) ([]byte, error) {
+	res, err := mod.ExportedFunction(wasmMalloc).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, input) {
+		return nil, errors.New("malloc returned memory out of range")
+	}
+
+	res, err = mod.ExportedFunction(name).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	free := mod.ExportedFunction(wasmFree)
+	if free != nil {
+		_, _ = free.Call(ctx, uint64(ptr), uint64(len(input)))
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+	out, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, errors.New("result out of memory range")
+	}
+	out = bytes.Clone(out)
+	if free != nil {
+		_, _ = free.Call(ctx, uint64(outPtr), uint64(outLen))
+	}
+	return out, nil
+}
+
+// release 把实例放回空闲队列，配置已经更新的实例直接销毁
+func (p *WasmPlugin) release(mod api.Module) {
+	p.mu.Lock()
+	stale := p.gens[mod] != p.gen
+	p.mu.Unlock()
+	if stale {
+		p.discard(mod)
+		return
+	}
+	select {
+	case p.idle <- mod:
+	default:
+		p.discard(mod)
+	}
+}
+
+func (p *WasmPlugin) discard(mod api.Module) {
+	p.mu.Lock()
+	delete(p.gens, mod)
+	p.mu.Unlock()
+	_ = mod.Close(context.Background())
+}
+
+// fail 处理模块错误：FailOpen 时只记录日志
+func (p *WasmPlugin) fail(err error) error {
+	if p.config.FailOpen {
+		p.logger.Error("WASM plugin error:", err)
+		return nil
+	}
+	return err
+}
+
+// result 解析钩子的输出
+func (p *WasmPlugin) result(out []byte) (*wasmResult, error) {
+	if len(out) == 0 {
+		return nil, nil
+	}
+	var result wasmResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("%s: invalid result: %w", p.Name(), err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("%s: %s", p.Name(), result.Error)
+	}
+	return &result, nil
+}
+
+func (p *WasmPlugin) BeforeRequest(ctx *RequestContext) error {
+	if !p.hooks[wasmBeforeRequest] {
+		return nil
+	}
+	input, _ := json.Marshal(wasmRequest{
+		Method:  ctx.Request.Method,
+		Path:    ctx.Path(),
+		Model:   ctx.Model(),
+		Headers: flattenHeaders(ctx.Request.Header),
+		Body:    rawJSON(ctx.Body()),
+	})
+	out, err := p.call(wasmBeforeRequest, input)
+	if err != nil {
+		return p.fail(err)
+	}
+	result, err := p.result(out)
+	if err != nil || result == nil {
+		return p.fail(err)
+	}
+	applyHeaderChanges(ctx.Request.Header, result.Headers)
+	if len(result.Body) > 0 {
+		ctx.SetBody(result.Body)
+		ctx.Request.ContentLength = int64(len(result.Body))
+	}
+	return nil
+}
+
+func (p *WasmPlugin) AfterResponse(resp *http.Response) error {
+	// 压缩的响应体交给客户端解压，不经过模块
+	if resp.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		if p.hooks[wasmOnChunk] {
+			resp.Body = newChunkReader(resp.Body, p.chunk)
+		}
+		return nil
+	}
+	if !p.hooks[wasmAfterResponse] {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	in := wasmResponse{Status: resp.StatusCode, Headers: flattenHeaders(resp.Header), Body: rawJSON(body)}
+	if resp.Request != nil {
+		in.Request.Method, in.Request.Path = resp.Request.Method, resp.Request.URL.Path
+	}
+	input, _ := json.Marshal(in)
+	out, err := p.call(wasmAfterResponse, input)
+	if err != nil {
+		return p.fail(err)
+	}
+	result, err := p.result(out)
+	if err != nil || result == nil {
+		return p.fail(err)
+	}
+	if result.Status != 0 {
+		resp.StatusCode = result.Status
+		resp.Status = fmt.Sprintf("%d %s", result.Status, http.StatusText(result.Status))
+	}
+	applyHeaderChanges(resp.Header, result.Headers)
+	if len(result.Body) > 0 {
+		resp.Body = io.NopCloser(bytes.NewReader(result.Body))
+		resp.ContentLength = int64(len(result.Body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(result.Body)))
+	}
+	return nil
+}
+
+// chunk 对一个 data 事件调用 on_chunk
+func (p *WasmPlugin) chunk(data []byte) ([]byte, bool, error) {
+	out, err := p.call(wasmOnChunk, data)
+	if err != nil {
+		return data, true, p.fail(err)
+	}
+	result, err := p.result(out)
+	if err != nil || result == nil {
+		return data, true, p.fail(err)
+	}
+	if result.Drop {
+		return nil, false, nil
+	}
+	if len(result.Chunk) > 0 {
+		return result.Chunk, true, nil
+	}
+	return data, true, nil
+}
+
+// flattenHeaders 把 HTTP 头转换为单值的 map，多个值用逗号连接
+func flattenHeaders(header http.Header) map[string]string {
+	out := make(map[string]string, len(header))
+	for k, v := range header {
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// applyHeaderChanges 应用模块返回的头：值为 nil 时删除
+func applyHeaderChanges(header http.Header, changes map[string]*string) {
+	for k, v := range changes {
+		if v == nil {
+			header.Del(k)
+		} else {
+			header.Set(k, *v)
+		}
+	}
+}
+
+// rawJSON 合法的 JSON 原样传递，其它内容编码为 JSON 字符串
+func rawJSON(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	s, _ := json.Marshal(string(body))
+	return s
+}
+
+// resultError 返回 configure 输出中的错误
+func resultError(out []byte) error {
+	if len(out) == 0 {
+		return nil
+	}
+	var result wasmResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("invalid result: %w", err)
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// wasmLogWriter 把模块的标准输出按行写入日志，不完整的行留到下次写入，每个实例一个
+type wasmLogWriter struct {
+	logger Logger
+	prefix string
+	buf    []byte
+}
+
+func (w *wasmLogWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logger.Info(w.prefix, string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	// 没有换行的超长输出直接写入
+	if len(w.buf) > 4096 {
+		w.logger.Info(w.prefix, string(w.buf))
+		w.buf = nil
+	}
+	return len(b), nil
+}
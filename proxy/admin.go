@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+var (
+	// errPluginNotFound 插件不存在
+	errPluginNotFound = errors.New("plugin not found")
+	// errPluginExists 同名插件已经注册
+	errPluginExists = errors.New("plugin already registered")
+)
+
+// PluginInfo 管理 API 返回的插件信息
+type PluginInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Enabled  bool   `json:"enabled"`
+	Order    int    `json:"order"`
+	Priority int    `json:"priority"`
+}
+
+// pluginName 返回插件名称：实现了 Name() 的插件使用其返回值，否则使用类型名，如 ModelMapPlugin
+func pluginName(plugin pluginPKG.Plugin) string {
+	if named, ok := plugin.(pluginPKG.Named); ok {
+		return named.Name()
+	}
+	t := reflect.TypeOf(plugin)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+// PluginInfos 按执行顺序返回已注册插件的信息
+func (p *Proxy) PluginInfos() []PluginInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]PluginInfo, 0, len(p.plugins))
+	for i, plugin := range p.plugins {
+		name := pluginName(plugin)
+		out = append(out, PluginInfo{
+			Name:     name,
+			Type:     fmt.Sprintf("%T", plugin),
+			Enabled:  !p.disabled[name] && pluginSelfEnabled(plugin),
+			Order:    i,
+			Priority: pluginPriority(plugin),
+		})
+	}
+	return out
+}
+
+// SetPluginEnabled 启用或停用插件
+func (p *Proxy) SetPluginEnabled(name string, enabled bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, plugin := range p.plugins {
+		if pluginName(plugin) == name {
+			if enabled {
+				delete(p.disabled, name)
+			} else {
+				p.disabled[name] = true
+			}
+			return nil
+		}
+	}
+	return errPluginNotFound
+}
+
+// ReorderPlugins 调整插件执行顺序，order 中的插件排在前面，未列出的插件保持原来的相对顺序
+func (p *Proxy) ReorderPlugins(order []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	byName := make(map[string]pluginPKG.Plugin, len(p.plugins))
+	for _, plugin := range p.plugins {
+		byName[pluginName(plugin)] = plugin
+	}
+	reordered := make([]pluginPKG.Plugin, 0, len(p.plugins))
+	seen := make(map[string]bool, len(order))
+	for _, name := range order {
+		plugin, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", errPluginNotFound, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			reordered = append(reordered, plugin)
+		}
+	}
+	for _, plugin := range p.plugins {
+		if !seen[pluginName(plugin)] {
+			reordered = append(reordered, plugin)
+		}
+	}
+	p.plugins = reordered
+	return nil
+}
+
+// adminRouter 创建管理 API 的路由
+func (p *Proxy) adminRouter() (*gin.Engine, error) {
+	if p.config.AdminKey == "" {
+		return nil, errors.New("admin key is required to start the admin API")
+	}
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(p.customRecovery())
+	p.registerDashboard(r)
+	p.registerAdminRoutes(r.Group("/admin", p.adminAuth()))
+	return r, nil
+}
+
+// adminAuth 校验管理 API 的访问密钥（Authorization: Bearer）
+func (p *Proxy) adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(p.config.AdminKey)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin key"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (p *Proxy) registerAdminRoutes(r *gin.RouterGroup) {
+	r.GET("/mappings", p.adminListMappings)
+	r.PUT("/mappings/*from", p.adminSetMapping)
+	r.DELETE("/mappings/*from", p.adminDeleteMapping)
+
+	r.GET("/plugins", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": p.PluginInfos(), "types": pluginPKG.Types()})
+	})
+	r.POST("/plugins/:name/enable", func(c *gin.Context) { p.adminSetPluginEnabled(c, true) })
+	r.POST("/plugins/:name/disable", func(c *gin.Context) { p.adminSetPluginEnabled(c, false) })
+	r.PUT("/plugins/order", p.adminReorderPlugins)
+
+	r.GET("/models", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": p.currentConfig().Models})
+	})
+	r.PUT("/models", p.adminReplaceModels)
+	r.POST("/models", p.adminAddModel)
+	r.DELETE("/models/*id", p.adminDeleteModel)
+
+	r.GET("/upstreams", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": p.UpstreamStatuses()})
+	})
+
+	p.registerDashboardRoutes(r)
+
+	r.POST("/config/save", func(c *gin.Context) {
+		if err := p.SaveConfig(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"saved": p.config.ConfigFile})
+	})
+}
+
+// adminChanged 配置被修改后记录日志，开启 AdminPersist 时写回配置文件
+func (p *Proxy) adminChanged(c *gin.Context, what string, result interface{}) {
+	p.logger.Info("Admin API changed", what)
+	if p.config.AdminPersist && p.config.ConfigFile != "" {
+		if err := p.SaveConfig(); err != nil {
+			p.logger.Error("Failed to save config:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "change applied but failed to save config: " + err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (p *Proxy) adminListMappings(c *gin.Context) {
+	mm := p.modelMapPlugin()
+	if mm == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model map plugin is not registered"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"mappings": mm.Mappings()})
+}
+
+func (p *Proxy) adminSetMapping(c *gin.Context) {
+	mm := p.modelMapPlugin()
+	if mm == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model map plugin is not registered"})
+		return
+	}
+	from := strings.TrimPrefix(c.Param("from"), "/")
+	var body struct {
+		To string `json:"to"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || from == "" || body.To == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `request must be PUT /admin/mappings/{from} with body {"to": "..."}`})
+		return
+	}
+	mm.AddMapping(from, body.To)
+	p.adminChanged(c, "mapping "+from+" -> "+body.To, gin.H{"mappings": mm.Mappings()})
+}
+
+func (p *Proxy) adminDeleteMapping(c *gin.Context) {
+	mm := p.modelMapPlugin()
+	if mm == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model map plugin is not registered"})
+		return
+	}
+	from := strings.TrimPrefix(c.Param("from"), "/")
+	if !mm.RemoveMapping(from) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mapping not found: " + from})
+		return
+	}
+	p.adminChanged(c, "mapping "+from+" removed", gin.H{"mappings": mm.Mappings()})
+}
+
+func (p *Proxy) adminSetPluginEnabled(c *gin.Context, enabled bool) {
+	name := c.Param("name")
+	if err := p.SetPluginEnabled(name, enabled); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error() + ": " + name})
+		return
+	}
+	p.adminChanged(c, fmt.Sprintf("plugin %s enabled=%t", name, enabled), gin.H{"data": p.PluginInfos()})
+}
+
+func (p *Proxy) adminReorderPlugins(c *gin.Context) {
+	var body struct {
+		Order []string `json:"order"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := p.ReorderPlugins(body.Order); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p.adminChanged(c, "plugin order", gin.H{"data": p.PluginInfos()})
+}
+
+// normalizeModel 补全模型信息中的默认字段
+func normalizeModel(m ModelInfo) ModelInfo {
+	if m.Object == "" {
+		m.Object = "model"
+	}
+	if m.Created == 0 {
+		m.Created = time.Now().Unix()
+	}
+	if m.OwnedBy == "" {
+		m.OwnedBy = "organization"
+	}
+	return m
+}
+
+func (p *Proxy) adminReplaceModels(c *gin.Context) {
+	var models []ModelInfo
+	if err := c.ShouldBindJSON(&models); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be an array of models: " + err.Error()})
+		return
+	}
+	for i := range models {
+		if models[i].ID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model id is required"})
+			return
+		}
+		models[i] = normalizeModel(models[i])
+	}
+	p.mu.Lock()
+	p.config.Models = models
+	p.mu.Unlock()
+	p.adminChanged(c, "models replaced", gin.H{"data": models})
+}
+
+func (p *Proxy) adminAddModel(c *gin.Context) {
+	var model ModelInfo
+	if err := c.ShouldBindJSON(&model); err != nil || model.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request body must be a model with an id"})
+		return
+	}
+	model = normalizeModel(model)
+
+	// 已存在的模型会被替换
+	p.mu.Lock()
+	models := make([]ModelInfo, 0, len(p.config.Models)+1)
+	for _, m := range p.config.Models {
+		if m.ID != model.ID {
+			models = append(models, m)
+		}
+	}
+	p.config.Models = append(models, model)
+	p.mu.Unlock()
+	p.adminChanged(c, "model "+model.ID+" added", gin.H{"data": p.currentConfig().Models})
+}
+
+func (p *Proxy) adminDeleteModel(c *gin.Context) {
+	id := strings.TrimPrefix(c.Param("id"), "/")
+	p.mu.Lock()
+	models := make([]ModelInfo, 0, len(p.config.Models))
+	for _, m := range p.config.Models {
+		if m.ID != id {
+			models = append(models, m)
+		}
+	}
+	found := len(models) < len(p.config.Models)
+	p.config.Models = models
+	p.mu.Unlock()
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model not found: " + id})
+		return
+	}
+	p.adminChanged(c, "model "+id+" removed", gin.H{"data": models})
+}
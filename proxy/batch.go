@@ -0,0 +1,886 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+const (
+	// defaultBatchConcurrency 每个 batch 默认同时执行的请求数
+	defaultBatchConcurrency = 4
+	// batchMaxAttempts 单个请求遇到 429 或 5xx 时的最大尝试次数
+	batchMaxAttempts = 5
+)
+
+// errBatchNotFound batch 不存在
+var errBatchNotFound = errors.New("batch not found")
+
+// batchEndpoints 支持的 batch endpoint
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+}
+
+// batch 状态
+const (
+	batchValidating = "validating"
+	batchFailed     = "failed"
+	batchInProgress = "in_progress"
+	batchFinalizing = "finalizing"
+	batchCompleted  = "completed"
+	batchExpired    = "expired"
+	batchCancelling = "cancelling"
+	batchCancelled  = "cancelled"
+)
+
+// batchObject /v1/batches 的 batch 对象
+type batchObject struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *batchErrors      `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     *string           `json:"output_file_id"`
+	ErrorFileID      *string           `json:"error_file_id"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     *int64            `json:"in_progress_at"`
+	ExpiresAt        *int64            `json:"expires_at"`
+	FinalizingAt     *int64            `json:"finalizing_at"`
+	CompletedAt      *int64            `json:"completed_at"`
+	FailedAt         *int64            `json:"failed_at"`
+	ExpiredAt        *int64            `json:"expired_at"`
+	CancellingAt     *int64            `json:"cancelling_at"`
+	CancelledAt      *int64            `json:"cancelled_at"`
+	RequestCounts    batchCounts       `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata"`
+	Owner            string            `json:"-"` // 创建者 key 的哈希，只有同一个 key 可以访问
+	HasAuth          bool              `json:"-"` // 创建时客户端带有凭证，凭证保存在单独的文件中
+}
+
+// storedBatch 磁盘上的 batch，比返回给客户端的多了 Owner 和 HasAuth
+type storedBatch struct {
+	batchObject
+	Owner   string `json:"owner,omitempty"`
+	HasAuth bool   `json:"has_auth,omitempty"`
+}
+
+// batchCounts 请求计数
+type batchCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// batchErrors 校验输入文件时发现的错误
+type batchErrors struct {
+	Object string       `json:"object"`
+	Data   []batchError `json:"data"`
+}
+
+type batchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// batchInputLine 输入文件中的一行
+type batchInputLine struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// batchOutputLine 输出文件和错误文件中的一行
+type batchOutputLine struct {
+	ID       string               `json:"id"`
+	CustomID string               `json:"custom_id"`
+	Response *batchOutputResponse `json:"response"`
+	Error    *batchError          `json:"error"`
+}
+
+type batchOutputResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// batchManager 本地 Batch API：文件和 batch 保存在磁盘上，后台按顺序执行 batch，重启后继续未完成的 batch
+type batchManager struct {
+	p           *Proxy
+	files       *fileStore
+	dir         string
+	concurrency int
+	interval    time.Duration // 请求之间的最小间隔，0 表示不限速
+
+	mu      sync.Mutex
+	batches map[string]*batchObject
+	queue   chan string
+
+	// 关闭服务时取消，正在执行的请求随之取消，batch 停在当前进度，重启后继续
+	ctx  context.Context
+	stop context.CancelFunc
+
+	engineOnce sync.Once
+	engine     http.Handler
+
+	rateMu     sync.Mutex
+	nextSlot   time.Time
+	pauseUntil time.Time
+}
+
+func newBatchManager(p *Proxy, cfg Config) (*batchManager, error) {
+	files, err := newFileStore(filepath.Join(cfg.BatchDir, "files"))
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cfg.BatchDir, "batches")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	m := &batchManager{
+		p:           p,
+		files:       files,
+		dir:         dir,
+		concurrency: cfg.BatchConcurrency,
+		batches:     make(map[string]*batchObject),
+		queue:       make(chan string, 1024),
+	}
+	m.ctx, m.stop = context.WithCancel(context.Background())
+	if m.concurrency <= 0 {
+		m.concurrency = defaultBatchConcurrency
+	}
+	if cfg.BatchRequestsPerMinute > 0 {
+		m.interval = time.Minute / time.Duration(cfg.BatchRequestsPerMinute)
+	}
+
+	// 加载已有的 batch，未结束的重新排队
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var pending []*batchObject
+	for _, path := range matches {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var stored storedBatch
+		if err := json.Unmarshal(data, &stored); err != nil {
+			p.logger.Error("Skip invalid batch file", path, ":", err)
+			continue
+		}
+		b := stored.batchObject
+		b.Owner, b.HasAuth = stored.Owner, stored.HasAuth
+		m.batches[b.ID] = &b
+		switch b.Status {
+		case batchValidating, batchInProgress, batchFinalizing, batchCancelling:
+			pending = append(pending, &b)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt < pending[j].CreatedAt })
+
+	go m.run()
+	for _, b := range pending {
+		p.logger.Info("Resuming batch:", b.ID)
+		m.queue <- b.ID
+	}
+	return m, nil
+}
+
+// isBatchAPIPath 判断是否是本地 Batch API 的路径
+func isBatchAPIPath(path string) bool {
+	return path == "/v1/files" || strings.HasPrefix(path, "/v1/files/") ||
+		path == "/v1/batches" || strings.HasPrefix(path, "/v1/batches/")
+}
+
+// handle 处理 /v1/files 和 /v1/batches 请求
+func (m *batchManager) handle(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/files") {
+		m.handleFiles(c)
+		return
+	}
+	m.handleBatches(c)
+}
+
+func (m *batchManager) handleBatches(c *gin.Context) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(c.Request.URL.Path, "/v1/batches"), "/"), "/")
+	owner := keyOwner(c.Request.Header)
+
+	switch {
+	case id == "" && c.Request.Method == http.MethodPost:
+		m.createBatch(c)
+	case id == "" && c.Request.Method == http.MethodGet:
+		c.JSON(http.StatusOK, gin.H{"object": "list", "data": m.list(owner), "has_more": false})
+	case sub == "" && c.Request.Method == http.MethodGet:
+		b, err := m.get(id)
+		if err == nil && b.Owner != owner {
+			err = errBatchNotFound
+		}
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, b)
+	case sub == "cancel" && c.Request.Method == http.MethodPost:
+		b, err := m.cancel(id, owner)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, b)
+	default:
+		c.JSON(http.StatusNotFound, openai.NewErrorResponse(http.StatusNotFound, "unknown batches endpoint", nil))
+	}
+}
+
+func (m *batchManager) createBatch(c *gin.Context) {
+	var req struct {
+		InputFileID      string            `json:"input_file_id"`
+		Endpoint         string            `json:"endpoint"`
+		CompletionWindow string            `json:"completion_window"`
+		Metadata         map[string]string `json:"metadata"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, "invalid batch request: "+err.Error(), nil))
+		return
+	}
+	if !batchEndpoints[req.Endpoint] {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("unsupported endpoint %q", req.Endpoint), nil))
+		return
+	}
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = "24h"
+	}
+	window, err := time.ParseDuration(req.CompletionWindow)
+	if err != nil || window <= 0 {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("invalid completion_window %q", req.CompletionWindow), nil))
+		return
+	}
+	owner := keyOwner(c.Request.Header)
+	if _, err := m.files.get(req.InputFileID, owner); err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	auth := credentialHeaders(c.Request.Header)
+
+	now := time.Now()
+	expiresAt := now.Add(window).Unix()
+	b := &batchObject{
+		ID:               newID("batch"),
+		Object:           "batch",
+		Endpoint:         req.Endpoint,
+		InputFileID:      req.InputFileID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           batchValidating,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        &expiresAt,
+		Metadata:         req.Metadata,
+		Owner:            owner,
+		HasAuth:          len(auth) > 0,
+	}
+	// 凭证先于 batch 落盘，重启后继续执行时仍以创建者的身份请求上游
+	if b.HasAuth {
+		if err := m.saveAuth(b.ID, auth); err != nil {
+			writeStoreError(c, err)
+			return
+		}
+	}
+
+	m.mu.Lock()
+	m.batches[b.ID] = b
+	err = m.saveLocked(b)
+	out := *b
+	m.mu.Unlock()
+	if err != nil {
+		writeStoreError(c, err)
+		return
+	}
+
+	m.p.logger.Info("Batch created:", b.ID)
+	m.queue <- b.ID
+	c.JSON(http.StatusOK, out)
+}
+
+func (m *batchManager) get(id string) (*batchObject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.batches[id]
+	if !ok {
+		return nil, errBatchNotFound
+	}
+	out := *b
+	return &out, nil
+}
+
+// list 按创建时间倒序列出 owner 的 batch
+func (m *batchManager) list(owner string) []batchObject {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]batchObject, 0, len(m.batches))
+	for _, b := range m.batches {
+		if b.Owner == owner {
+			out = append(out, *b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out
+}
+
+// cancel 标记 batch 为 cancelling，执行中的请求完成后变为 cancelled
+func (m *batchManager) cancel(id, owner string) (*batchObject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.batches[id]
+	if !ok || b.Owner != owner {
+		return nil, errBatchNotFound
+	}
+	switch b.Status {
+	case batchValidating, batchInProgress:
+		now := time.Now().Unix()
+		b.Status = batchCancelling
+		b.CancellingAt = &now
+		if err := m.saveLocked(b); err != nil {
+			return nil, err
+		}
+	}
+	out := *b
+	return &out, nil
+}
+
+// update 在锁内修改 batch 并保存
+func (m *batchManager) update(id string, fn func(b *batchObject)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.batches[id]
+	fn(b)
+	if err := m.saveLocked(b); err != nil {
+		m.p.logger.Error("Failed to save batch", id, ":", err)
+	}
+}
+
+func (m *batchManager) status(id string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.batches[id].Status
+}
+
+func (m *batchManager) saveLocked(b *batchObject) error {
+	return writeJSONFile(filepath.Join(m.dir, b.ID+".json"), storedBatch{batchObject: *b, Owner: b.Owner, HasAuth: b.HasAuth})
+}
+
+func (m *batchManager) outputPath(id string) string {
+	return filepath.Join(m.dir, id+".output.jsonl")
+}
+
+func (m *batchManager) errorPath(id string) string {
+	return filepath.Join(m.dir, id+".error.jsonl")
+}
+
+func (m *batchManager) authPath(id string) string {
+	return filepath.Join(m.dir, id+".credentials")
+}
+
+// saveAuth 保存创建者的凭证，文件只有当前用户可读，batch 结束时删除
+func (m *batchManager) saveAuth(id string, auth http.Header) error {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	path := m.authPath(id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (m *batchManager) loadAuth(id string) (http.Header, error) {
+	data, err := os.ReadFile(m.authPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var auth http.Header
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// removeAuth batch 结束后不再需要凭证
+func (m *batchManager) removeAuth(id string) {
+	if err := os.Remove(m.authPath(id)); err != nil && !os.IsNotExist(err) {
+		m.p.logger.Error("Failed to remove batch credentials", id, ":", err)
+	}
+}
+
+// run 依次执行排队的 batch
+func (m *batchManager) run() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case id := <-m.queue:
+			m.process(id)
+		}
+	}
+}
+
+// close 停止执行 batch，未完成的 batch 保持原来的状态，重启后继续
+func (m *batchManager) close() {
+	m.stop()
+}
+
+func (m *batchManager) stopped() bool {
+	return m.ctx.Err() != nil
+}
+
+// process 校验输入文件并执行其中的请求，已经写入输出文件的请求在重启后不会重复执行
+func (m *batchManager) process(id string) {
+	b, err := m.get(id)
+	if err != nil {
+		return
+	}
+	// 重启前已经执行完所有请求，只需要完成收尾
+	if b.Status == batchFinalizing {
+		m.finalize(id, batchCompleted)
+		return
+	}
+
+	// 以创建者的凭证执行；凭证文件丢失时不能改用上游配置的凭证，已完成的结果照常登记
+	var auth http.Header
+	if b.HasAuth {
+		if auth, err = m.loadAuth(id); err != nil {
+			m.p.logger.Error("Batch failed:", id, "client credentials are not available:", err)
+			m.update(id, func(b *batchObject) {
+				b.Errors = &batchErrors{Object: "list", Data: []batchError{{
+					Code:    "credentials_unavailable",
+					Message: "client credentials of the batch are missing, the batch cannot resume",
+				}}}
+			})
+			m.finalize(id, batchFailed)
+			return
+		}
+	}
+
+	lines, lineErrs, err := m.readInput(b)
+	if err != nil {
+		m.fail(id, []batchError{{Code: "invalid_input_file", Message: err.Error()}})
+		return
+	}
+	if len(lineErrs) > 0 {
+		m.fail(id, lineErrs)
+		return
+	}
+
+	// 从已有的输出中恢复进度
+	done := make(map[string]bool)
+	completed := readBatchOutputIDs(m.outputPath(id), done)
+	failed := readBatchOutputIDs(m.errorPath(id), done)
+	m.update(id, func(b *batchObject) {
+		if b.Status == batchValidating {
+			now := time.Now().Unix()
+			b.Status = batchInProgress
+			b.InProgressAt = &now
+		}
+		b.RequestCounts = batchCounts{Total: len(lines), Completed: completed, Failed: failed}
+	})
+	m.p.logger.Info(fmt.Sprintf("Batch %s: %d requests, %d already done", id, len(lines), len(done)))
+
+	outFile, err := os.OpenFile(m.outputPath(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		m.fail(id, []batchError{{Code: "internal_error", Message: err.Error()}})
+		return
+	}
+	defer outFile.Close()
+	errFile, err := os.OpenFile(m.errorPath(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		m.fail(id, []batchError{{Code: "internal_error", Message: err.Error()}})
+		return
+	}
+	defer errFile.Close()
+
+	// 按 concurrency 并发执行，每完成一个请求追加一行输出
+	var writeMu sync.Mutex
+	work := make(chan batchInputLine)
+	var wg sync.WaitGroup
+	for i := 0; i < m.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range work {
+				result := m.execute(line, auth)
+				ok := result.Response != nil && result.Response.StatusCode < http.StatusBadRequest
+				// 关闭服务时被中断的请求不写入结果，重启后重新执行
+				if !ok && m.stopped() {
+					continue
+				}
+				data, _ := json.Marshal(result)
+				data = append(data, '\n')
+
+				writeMu.Lock()
+				target := errFile
+				if ok {
+					target = outFile
+				}
+				if _, err := target.Write(data); err != nil {
+					m.p.logger.Error("Failed to write batch output:", err)
+				}
+				writeMu.Unlock()
+
+				m.update(id, func(b *batchObject) {
+					if ok {
+						b.RequestCounts.Completed++
+					} else {
+						b.RequestCounts.Failed++
+					}
+				})
+			}
+		}()
+	}
+
+	finalStatus := batchCompleted
+	interrupted := false
+	for _, line := range lines {
+		if done[line.CustomID] {
+			continue
+		}
+		if m.stopped() {
+			interrupted = true
+			break
+		}
+		if m.status(id) == batchCancelling {
+			finalStatus = batchCancelled
+			break
+		}
+		if b.ExpiresAt != nil && time.Now().Unix() > *b.ExpiresAt {
+			finalStatus = batchExpired
+			break
+		}
+		work <- line
+	}
+	close(work)
+	wg.Wait()
+	if interrupted || m.stopped() {
+		m.p.logger.Info("Batch", id, "interrupted by shutdown, will resume after restart")
+		return
+	}
+	if finalStatus == batchCompleted && m.status(id) == batchCancelling {
+		finalStatus = batchCancelled
+	}
+
+	m.finalize(id, finalStatus)
+}
+
+// readInput 读取并校验输入文件
+func (m *batchManager) readInput(b *batchObject) ([]batchInputLine, []batchError, error) {
+	if _, err := m.files.get(b.InputFileID, b.Owner); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(m.files.dataPath(b.InputFileID))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var lines []batchInputLine
+	var lineErrs []batchError
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var line batchInputLine
+		switch err := json.Unmarshal(text, &line); {
+		case err != nil:
+			lineErrs = append(lineErrs, batchError{Code: "invalid_json_line", Message: err.Error(), Line: n})
+		case line.CustomID == "":
+			lineErrs = append(lineErrs, batchError{Code: "missing_required_parameter", Message: "custom_id is required", Param: "custom_id", Line: n})
+		case seen[line.CustomID]:
+			lineErrs = append(lineErrs, batchError{Code: "duplicate_custom_id", Message: fmt.Sprintf("duplicate custom_id %q", line.CustomID), Param: "custom_id", Line: n})
+		case line.Method != http.MethodPost:
+			lineErrs = append(lineErrs, batchError{Code: "invalid_method", Message: "method must be POST", Param: "method", Line: n})
+		case line.URL != b.Endpoint:
+			lineErrs = append(lineErrs, batchError{Code: "mismatched_endpoint", Message: fmt.Sprintf("url %q does not match batch endpoint %q", line.URL, b.Endpoint), Param: "url", Line: n})
+		default:
+			seen[line.CustomID] = true
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(lines) == 0 && len(lineErrs) == 0 {
+		lineErrs = append(lineErrs, batchError{Code: "empty_file", Message: "input file contains no requests"})
+	}
+	return lines, lineErrs, nil
+}
+
+// readBatchOutputIDs 读取已写入的输出，把 custom_id 加入 done，返回行数
+func readBatchOutputIDs(path string, done map[string]bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	count := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var line batchOutputLine
+		// 进程退出时可能留下不完整的最后一行，跳过后该请求会重新执行
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || line.CustomID == "" {
+			continue
+		}
+		done[line.CustomID] = true
+		count++
+	}
+	return count
+}
+
+// execute 通过代理自身的处理流程执行一个请求，遇到 429 和 5xx 时退避重试
+func (m *batchManager) execute(line batchInputLine, auth http.Header) batchOutputLine {
+	m.engineOnce.Do(func() {
+		engine := gin.New()
+		engine.Use(m.p.customRecovery())
+		engine.Any("/*path", m.p.handleRequest)
+		m.engine = engine
+	})
+
+	out := batchOutputLine{ID: newID("batch_req"), CustomID: line.CustomID}
+	for attempt := 1; ; attempt++ {
+		m.wait()
+
+		req, err := http.NewRequestWithContext(m.ctx, line.Method, m.p.config.PathPrefix+line.URL, bytes.NewReader(line.Body))
+		if err != nil {
+			out.Error = &batchError{Code: "invalid_request", Message: err.Error()}
+			return out
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range auth {
+			req.Header[k] = v
+		}
+		rec := m.serve(req)
+
+		status := rec.status
+		retryable := status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+		if retryable && attempt < batchMaxAttempts && !m.stopped() {
+			delay := retryAfter(rec.header, attempt)
+			m.p.logger.Info(fmt.Sprintf("Batch request %s got %d, retrying in %v", line.CustomID, status, delay))
+			if status == http.StatusTooManyRequests {
+				m.pause(delay)
+			} else {
+				time.Sleep(delay)
+			}
+			continue
+		}
+
+		body := rec.body.Bytes()
+		if !json.Valid(body) {
+			body, _ = json.Marshal(string(body))
+		}
+		requestID := rec.header.Get("X-Request-Id")
+		if requestID == "" {
+			requestID = newID("req")
+		}
+		out.Response = &batchOutputResponse{StatusCode: status, RequestID: requestID, Body: body}
+		return out
+	}
+}
+
+// serve 执行请求并记录响应，响应中途中断或关闭服务时被取消按 502 处理
+func (m *batchManager) serve(req *http.Request) (rec *batchResponseWriter) {
+	interrupted := func() *batchResponseWriter {
+		rec := newBatchResponseWriter()
+		rec.status = http.StatusBadGateway
+		rec.body.WriteString(`{"error":{"message":"upstream response interrupted","type":"upstream_error"}}`)
+		return rec
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler {
+				panic(err)
+			}
+			rec = interrupted()
+		}
+	}()
+	rec = newBatchResponseWriter()
+	m.engine.ServeHTTP(rec, req)
+	// 被取消的请求不会写出响应，不能当作成功的空响应记录
+	if req.Context().Err() != nil {
+		return interrupted()
+	}
+	return rec
+}
+
+// credentialHeaders 返回请求中的客户端凭证，batch 执行时原样带上
+func credentialHeaders(h http.Header) http.Header {
+	out := make(http.Header)
+	for _, name := range []string{"Authorization", "api-key", "x-api-key"} {
+		if v := h.Values(name); len(v) > 0 {
+			out[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	return out
+}
+
+// batchResponseWriter 在内存中记录 batch 请求的响应，ReverseProxy 要求 ResponseWriter 实现 http.CloseNotifier
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{header: make(http.Header)}
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+// Flush 实现 http.Flusher，响应已经在内存中，无需操作
+func (w *batchResponseWriter) Flush() {}
+
+// CloseNotify 实现 http.CloseNotifier，batch 请求没有客户端连接，不会触发
+func (w *batchResponseWriter) CloseNotify() <-chan bool {
+	return nil
+}
+
+// wait 等待速率限制和 429 退避
+func (m *batchManager) wait() {
+	m.rateMu.Lock()
+	now := time.Now()
+	slot := now
+	if m.pauseUntil.After(slot) {
+		slot = m.pauseUntil
+	}
+	if m.interval > 0 {
+		if m.nextSlot.After(slot) {
+			slot = m.nextSlot
+		}
+		m.nextSlot = slot.Add(m.interval)
+	}
+	m.rateMu.Unlock()
+	time.Sleep(time.Until(slot))
+}
+
+// pause 上游限流时暂停所有请求
+func (m *batchManager) pause(d time.Duration) {
+	m.rateMu.Lock()
+	if until := time.Now().Add(d); until.After(m.pauseUntil) {
+		m.pauseUntil = until
+	}
+	m.rateMu.Unlock()
+}
+
+// retryAfter 优先使用 Retry-After header，否则指数退避
+func retryAfter(header http.Header, attempt int) time.Duration {
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t)
+		}
+	}
+	return time.Duration(1<<(attempt-1)) * time.Second
+}
+
+// fail 输入文件校验失败
+func (m *batchManager) fail(id string, errs []batchError) {
+	m.p.logger.Error("Batch failed:", id, errs[0].Message)
+	m.update(id, func(b *batchObject) {
+		now := time.Now().Unix()
+		b.Status = batchFailed
+		b.FailedAt = &now
+		b.Errors = &batchErrors{Object: "list", Data: errs}
+	})
+	m.removeAuth(id)
+}
+
+// finalize 把输出和错误文件登记为 batch_output 文件并设置最终状态
+func (m *batchManager) finalize(id, status string) {
+	m.update(id, func(b *batchObject) {
+		now := time.Now().Unix()
+		b.Status = batchFinalizing
+		b.FinalizingAt = &now
+	})
+
+	b, err := m.get(id)
+	if err != nil {
+		return
+	}
+	outputID := m.addOutputFile(b, m.outputPath(id), id+"_output.jsonl")
+	errorID := m.addOutputFile(b, m.errorPath(id), id+"_error.jsonl")
+
+	m.update(id, func(b *batchObject) {
+		now := time.Now().Unix()
+		b.OutputFileID = outputID
+		b.ErrorFileID = errorID
+		b.Status = status
+		switch status {
+		case batchCompleted:
+			b.CompletedAt = &now
+		case batchFailed:
+			b.FailedAt = &now
+		case batchCancelled:
+			b.CancelledAt = &now
+		case batchExpired:
+			b.ExpiredAt = &now
+		}
+	})
+	m.removeAuth(id)
+	m.p.logger.Info("Batch finished:", id, status)
+}
+
+// addOutputFile 把非空的结果文件移动到文件存储，文件属于 batch 的创建者，返回文件 ID
+func (m *batchManager) addOutputFile(b *batchObject, path, filename string) *string {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() == 0 {
+		os.Remove(path)
+		return nil
+	}
+	obj, err := m.files.add(path, filename, "batch_output", b.Owner)
+	if err != nil {
+		m.p.logger.Error("Failed to store output of batch", b.ID, ":", err)
+		return nil
+	}
+	return &obj.ID
+}
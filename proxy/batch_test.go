@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// doRequest 以 key 发送请求，返回状态码和响应体
+func doRequest(t *testing.T, method, url, key, contentType string, body io.Reader) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+// uploadBatchInput 以 key 上传 batch 输入文件，返回文件 ID
+func uploadBatchInput(t *testing.T, url, key, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("purpose", "batch")
+	fw, _ := mw.CreateFormFile("file", "input.jsonl")
+	io.WriteString(fw, content)
+	mw.Close()
+	status, body := doRequest(t, http.MethodPost, url+"/v1/files", key, mw.FormDataContentType(), &buf)
+	if status != http.StatusOK {
+		t.Fatalf("upload status %d: %s", status, body)
+	}
+	var obj fileObject
+	decodeJSON(t, body, &obj)
+	return obj.ID
+}
+
+// waitBatch 等待 batch 结束，返回最终的 batch
+func waitBatch(t *testing.T, url, key, id string) batchObject {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status, body := doRequest(t, http.MethodGet, url+"/v1/batches/"+id, key, "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("get batch status %d: %s", status, body)
+		}
+		var b batchObject
+		decodeJSON(t, body, &b)
+		switch b.Status {
+		case batchCompleted, batchFailed, batchCancelled, batchExpired:
+			return b
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("batch %s did not finish", id)
+	return batchObject{}
+}
+
+const batchInput = `{"custom_id":"a","method":"POST","url":"/v1/chat/completions","body":{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}}` + "\n"
+
+func TestBatchScopedToKey(t *testing.T) {
+	var mu sync.Mutex
+	var auths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[]}`)
+	}))
+	defer upstream.Close()
+	_, srv := newTestProxy(t, Config{TargetURL: upstream.URL, BatchDir: t.TempDir()})
+
+	fileID := uploadBatchInput(t, srv.URL, "key-a", batchInput)
+
+	// 其它 key 看不到、下载不了也删除不了这个文件
+	if status, body := doRequest(t, http.MethodGet, srv.URL+"/v1/files", "key-b", "", nil); status != http.StatusOK || strings.Contains(string(body), fileID) {
+		t.Fatalf("file listed for another key: %d %s", status, body)
+	}
+	for _, path := range []string{"/v1/files/" + fileID, "/v1/files/" + fileID + "/content"} {
+		if status, _ := doRequest(t, http.MethodGet, srv.URL+path, "key-b", "", nil); status != http.StatusNotFound {
+			t.Fatalf("GET %s by another key: status %d", path, status)
+		}
+	}
+	if status, _ := doRequest(t, http.MethodDelete, srv.URL+"/v1/files/"+fileID, "key-b", "", nil); status != http.StatusNotFound {
+		t.Fatalf("delete by another key: status %d", status)
+	}
+	create := `{"input_file_id":"` + fileID + `","endpoint":"/v1/chat/completions"}`
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/v1/batches", "key-b", "application/json", strings.NewReader(create)); status != http.StatusNotFound {
+		t.Fatalf("batch from another key's file: status %d", status)
+	}
+
+	status, body := doRequest(t, http.MethodPost, srv.URL+"/v1/batches", "key-a", "application/json", strings.NewReader(create))
+	if status != http.StatusOK {
+		t.Fatalf("create batch status %d: %s", status, body)
+	}
+	var created batchObject
+	decodeJSON(t, body, &created)
+	if strings.Contains(string(body), "owner") {
+		t.Fatalf("owner exposed to client: %s", body)
+	}
+
+	b := waitBatch(t, srv.URL, "key-a", created.ID)
+	if b.Status != batchCompleted || b.OutputFileID == nil || b.RequestCounts.Completed != 1 {
+		t.Fatalf("unexpected batch: %+v", b)
+	}
+	mu.Lock()
+	if len(auths) != 1 || auths[0] != "Bearer key-a" {
+		t.Errorf("upstream credentials %q, want the creating key", auths)
+	}
+	mu.Unlock()
+
+	for _, path := range []string{"/v1/batches/" + b.ID, "/v1/files/" + *b.OutputFileID + "/content"} {
+		if status, _ := doRequest(t, http.MethodGet, srv.URL+path, "key-b", "", nil); status != http.StatusNotFound {
+			t.Fatalf("GET %s by another key: status %d", path, status)
+		}
+	}
+	if status, _ := doRequest(t, http.MethodPost, srv.URL+"/v1/batches/"+b.ID+"/cancel", "key-b", "", nil); status != http.StatusNotFound {
+		t.Fatalf("cancel by another key: status %d", status)
+	}
+	if status, body := doRequest(t, http.MethodGet, srv.URL+"/v1/batches", "key-b", "", nil); status != http.StatusOK || strings.Contains(string(body), b.ID) {
+		t.Fatalf("batch listed for another key: %d %s", status, body)
+	}
+	status, body = doRequest(t, http.MethodGet, srv.URL+"/v1/files/"+*b.OutputFileID+"/content", "key-a", "", nil)
+	if status != http.StatusOK || !strings.Contains(string(body), `"custom_id":"a"`) {
+		t.Fatalf("output for the creating key: %d %s", status, body)
+	}
+}
+
+func TestBatchResumeWithCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var auths []string
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		first := len(auths) == 1
+		mu.Unlock()
+		if first {
+			// 第一次请求一直挂起，直到代理关闭时被取消
+			started <- struct{}{}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-release:
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[]}`)
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	dir := t.TempDir()
+	p, srv := newTestProxy(t, Config{TargetURL: upstream.URL, BatchDir: dir})
+	fileID := uploadBatchInput(t, srv.URL, "key-a", batchInput)
+	status, body := doRequest(t, http.MethodPost, srv.URL+"/v1/batches", "key-a", "application/json",
+		strings.NewReader(`{"input_file_id":"`+fileID+`","endpoint":"/v1/chat/completions"}`))
+	if status != http.StatusOK {
+		t.Fatalf("create batch status %d: %s", status, body)
+	}
+	var created batchObject
+	decodeJSON(t, body, &created)
+
+	// 凭证保存在只有当前用户可读的文件中，batch 文件本身不包含凭证
+	credPath := filepath.Join(dir, "batches", created.ID+".credentials")
+	info, err := os.Stat(credPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("credentials file mode %v, want 0600", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "batches", created.ID+".json")); strings.Contains(string(data), "key-a") {
+		t.Errorf("credentials written to the batch file: %s", data)
+	}
+
+	// 请求执行中关闭代理，batch 停在当前进度
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后以创建者的凭证继续执行
+	_, srv = newTestProxy(t, Config{TargetURL: upstream.URL, BatchDir: dir})
+	b := waitBatch(t, srv.URL, "key-a", created.ID)
+	if b.Status != batchCompleted || b.RequestCounts.Completed != 1 {
+		t.Fatalf("unexpected batch: %+v", b)
+	}
+	mu.Lock()
+	if len(auths) != 2 || auths[1] != "Bearer key-a" {
+		t.Errorf("upstream credentials %q, want the creating key after restart", auths)
+	}
+	mu.Unlock()
+	if _, err := os.Stat(credPath); !os.IsNotExist(err) {
+		t.Errorf("credentials file not removed after the batch finished: %v", err)
+	}
+}
+
+func TestBatchResumeWithoutCredentials(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("resumed batch reached upstream with %q", r.Header.Get("Authorization"))
+	}))
+	defer upstream.Close()
+
+	// 模拟重启前创建、尚未执行的 batch，凭证文件已经丢失
+	dir := t.TempDir()
+	owner := keyOwner(http.Header{"Authorization": {"Bearer key-a"}})
+	files, err := newFileStore(filepath.Join(dir, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := files.create("input.jsonl", "batch", owner, strings.NewReader(batchInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := storedBatch{
+		batchObject: batchObject{
+			ID:          "batch_resume",
+			Object:      "batch",
+			Endpoint:    "/v1/chat/completions",
+			InputFileID: input.ID,
+			Status:      batchInProgress,
+			CreatedAt:   time.Now().Unix(),
+		},
+		Owner:   owner,
+		HasAuth: true,
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "batches"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFile(filepath.Join(dir, "batches", "batch_resume.json"), stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不能改用上游配置的凭证执行
+	_, srv := newTestProxy(t, Config{TargetURL: upstream.URL, BatchDir: dir})
+	b := waitBatch(t, srv.URL, "key-a", "batch_resume")
+	if b.Status != batchFailed || b.FailedAt == nil || b.Errors == nil || b.Errors.Data[0].Code != "credentials_unavailable" {
+		t.Fatalf("unexpected batch: %+v", b)
+	}
+}
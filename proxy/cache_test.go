@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/plugin"
+)
+
+func TestCacheScopedToKey(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		var calls atomic.Int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`)
+		}))
+		defer upstream.Close()
+		_, srv := newTestProxy(t, Config{TargetURL: upstream.URL, Cache: &plugin.CacheConfig{ShareAcrossKeys: shared}})
+
+		body := `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hello"}]}`
+		for _, key := range []string{"key-a", "key-a", "key-b"} {
+			if status, data := postJSON(t, srv.URL+"/v1/chat/completions", body, http.Header{"Authorization": {"Bearer " + key}}); status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, data)
+			}
+		}
+		want := int32(2)
+		if shared {
+			want = 1
+		}
+		if got := calls.Load(); got != want {
+			t.Errorf("share_across_keys=%v: %d upstream calls, want %d", shared, got, want)
+		}
+	}
+}
+
+// semanticRequest 发送 chat 请求，返回 X-Semantic-Cache 响应头
+func semanticRequest(t *testing.T, url, system, question string, header http.Header) string {
+	t.Helper()
+	body := `{"model":"gpt-4o","messages":[`
+	if system != "" {
+		body += `{"role":"system","content":"` + system + `"},`
+	}
+	body += `{"role":"user","content":"` + question + `"}]}`
+	req, _ := http.NewRequest(http.MethodPost, url+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), "Paris") {
+		t.Fatalf("status %d: %s", resp.StatusCode, data)
+	}
+	return resp.Header.Get("X-Semantic-Cache")
+}
+
+func newSemanticTestProxy(t *testing.T, conf plugin.SemanticCacheConfig) (string, *atomic.Int32) {
+	calls := new(atomic.Int32)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"Paris"},"finish_reason":"stop"}]}`)
+	}))
+	t.Cleanup(upstream.Close)
+	_, srv := newTestProxy(t, Config{TargetURL: upstream.URL, SemanticCache: &conf})
+	return srv.URL, calls
+}
+
+func TestSemanticCache(t *testing.T) {
+	type request struct {
+		system   string
+		question string
+		header   http.Header
+		want     string // X-Semantic-Cache
+	}
+	const question = "What is the capital of France?"
+	tests := []struct {
+		name     string
+		requests []request
+		calls    int32
+	}{
+		{"case and punctuation", []request{
+			{"", question, nil, plugin.CacheMiss},
+			{"", "what is the capital of france", nil, plugin.CacheHit},
+		}, 1},
+		{"different question", []request{
+			{"", question, nil, plugin.CacheMiss},
+			{"", "How tall is the Eiffel Tower?", nil, plugin.CacheMiss},
+		}, 2},
+		{"different system prompt", []request{
+			{"be brief", question, nil, plugin.CacheMiss},
+			{"answer in French", question, nil, plugin.CacheMiss},
+			{"be brief", question, nil, plugin.CacheHit},
+		}, 2},
+		{"disabled by header", []request{
+			{"", question, nil, plugin.CacheMiss},
+			{"", question, http.Header{"X-Semantic-Cache": {"off"}}, plugin.CacheBypass},
+			{"", question, http.Header{"Cache-Control": {"no-store"}}, plugin.CacheBypass},
+		}, 3},
+		{"no-cache refreshes", []request{
+			{"", question, http.Header{"Cache-Control": {"no-cache"}}, plugin.CacheBypass},
+			{"", question, nil, plugin.CacheHit},
+		}, 1},
+		{"threshold raised by header", []request{
+			{"", question, nil, plugin.CacheMiss},
+			{"", question, http.Header{"X-Semantic-Cache-Threshold": {"1.5"}}, plugin.CacheMiss},
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, calls := newSemanticTestProxy(t, plugin.SemanticCacheConfig{})
+			for i, r := range tt.requests {
+				if got := semanticRequest(t, url, r.system, r.question, r.header); got != r.want {
+					t.Errorf("request %d: X-Semantic-Cache %q, want %q", i, got, r.want)
+				}
+			}
+			if got := calls.Load(); got != tt.calls {
+				t.Errorf("%d upstream calls, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestSemanticCacheScopedToKey(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		url, calls := newSemanticTestProxy(t, plugin.SemanticCacheConfig{ShareAcrossKeys: shared})
+
+		questions := []string{"What is the capital of France?", "what is the capital of france", "What is the capital of France?"}
+		for i, key := range []string{"key-a", "key-a", "key-b"} {
+			semanticRequest(t, url, "", questions[i], http.Header{"Authorization": {"Bearer " + key}})
+		}
+		want := int32(2)
+		if shared {
+			want = 1
+		}
+		if got := calls.Load(); got != want {
+			t.Errorf("share_across_keys=%v: %d upstream calls, want %d", shared, got, want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+const calloutChat = `{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`
+
+// policyServer 代替外部策略服务，返回固定的决定并记录收到的摘要；delay 大于 0 时延迟响应
+type policyServer struct {
+	*httptest.Server
+	decision string
+	delay    time.Duration
+
+	mu        sync.Mutex
+	summaries []pluginPKG.CalloutRequest
+}
+
+func newPolicyServer(t *testing.T, decision string) *policyServer {
+	s := &policyServer{decision: decision}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var summary pluginPKG.CalloutRequest
+		if err := json.NewDecoder(r.Body).Decode(&summary); err != nil {
+			t.Errorf("decode summary: %v", err)
+		}
+		s.mu.Lock()
+		s.summaries = append(s.summaries, summary)
+		s.mu.Unlock()
+		if s.delay > 0 {
+			select {
+			case <-time.After(s.delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, s.decision)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *policyServer) calls() []pluginPKG.CalloutRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pluginPKG.CalloutRequest(nil), s.summaries...)
+}
+
+// newCalloutProxy 创建只注册了 callout 插件的代理，config 是插件配置中 url 之外的字段
+func newCalloutProxy(t *testing.T, upstream, policy, config string) *httptest.Server {
+	t.Helper()
+	raw := `{"url":"` + policy + `"`
+	if config != "" {
+		raw += "," + config
+	}
+	raw += "}"
+	_, srv := newTestProxy(t, Config{
+		TargetURL: upstream,
+		Plugins:   []PluginSpec{{Type: "callout", Config: json.RawMessage(raw)}},
+	})
+	return srv
+}
+
+// errorCode 返回 OpenAI 格式错误响应中的 code
+func errorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var resp struct {
+		Error openai.Error `json:"error"`
+	}
+	decodeJSON(t, body, &resp)
+	code, _ := resp.Error.Code.(string)
+	return code
+}
+
+func TestCalloutDecisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision string
+		status   int
+		code     string
+		forward  string // 上游收到的请求体中应包含的内容，为空表示不转发
+	}{
+		{"allow", `{"action":"allow"}`, http.StatusOK, "", `"model":"gpt-4o"`},
+		{"deny", `{"action":"deny","message":"blocked","status":451}`, 451, "policy_denied", ""},
+		{"deny default status", `{"action":"deny"}`, http.StatusForbidden, "policy_denied", ""},
+		{"patch", `{"action":"patch","patch":[{"op":"replace","path":"/model","value":"gpt-4o-mini"}]}`, http.StatusOK, "", `"model":"gpt-4o-mini"`},
+		{"invalid patch", `{"action":"patch","patch":[{"op":"replace","path":"/missing/x","value":1}]}`, http.StatusServiceUnavailable, "policy_unavailable", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := newRecordingUpstream(t)
+			policy := newPolicyServer(t, tt.decision)
+			srv := newCalloutProxy(t, up.URL, policy.URL, "")
+
+			status, body := postJSON(t, srv.URL+"/v1/chat/completions", calloutChat, http.Header{"Authorization": {"Bearer sk-test-123456"}})
+			if status != tt.status {
+				t.Fatalf("status %d, want %d: %s", status, tt.status, body)
+			}
+			if tt.code != "" {
+				if code := errorCode(t, body); code != tt.code {
+					t.Errorf("code %q, want %q", code, tt.code)
+				}
+			}
+
+			bodies := up.requestBodies()
+			if tt.forward == "" && len(bodies) != 0 {
+				t.Errorf("rejected request reached upstream: %q", bodies)
+			}
+			if tt.forward != "" && (len(bodies) != 1 || !strings.Contains(bodies[0], tt.forward)) {
+				t.Errorf("upstream bodies %q, want %s", bodies, tt.forward)
+			}
+
+			summaries := policy.calls()
+			if len(summaries) != 1 {
+				t.Fatalf("%d policy calls, want 1", len(summaries))
+			}
+			s := summaries[0]
+			if s.Path != "/v1/chat/completions" || s.Model != "gpt-4o" || s.KeyHash == "" || s.Partial ||
+				len(s.Messages) != 1 || s.Messages[0].Content != "hello" {
+				t.Errorf("unexpected summary: %+v", s)
+			}
+		})
+	}
+}
+
+func TestCalloutTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		status int
+	}{
+		{"fail open", `"timeout_ms":20,"fail_open":true`, http.StatusOK},
+		{"fail closed", `"timeout_ms":20`, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := newRecordingUpstream(t)
+			policy := newPolicyServer(t, `{"action":"deny"}`)
+			policy.delay = time.Second
+			srv := newCalloutProxy(t, up.URL, policy.URL, tt.config)
+
+			start := time.Now()
+			status, body := postJSON(t, srv.URL+"/v1/chat/completions", calloutChat, nil)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d: %s", status, tt.status, body)
+			}
+			if elapsed := time.Since(start); elapsed >= policy.delay {
+				t.Errorf("request took %v, callout did not time out", elapsed)
+			}
+			if status != http.StatusOK {
+				if code := errorCode(t, body); code != "policy_unavailable" {
+					t.Errorf("code %q, want policy_unavailable", code)
+				}
+			}
+		})
+	}
+}
+
+func TestCalloutCache(t *testing.T) {
+	up := newRecordingUpstream(t)
+	policy := newPolicyServer(t, `{"action":"allow"}`)
+	srv := newCalloutProxy(t, up.URL, policy.URL, "")
+
+	for i := 0; i < 2; i++ {
+		if status, body := postJSON(t, srv.URL+"/v1/chat/completions", calloutChat, nil); status != http.StatusOK {
+			t.Fatalf("status %d: %s", status, body)
+		}
+	}
+	if n := len(policy.calls()); n != 1 {
+		t.Fatalf("%d policy calls for identical requests, want 1", n)
+	}
+
+	// 摘要不同的请求重新询问策略服务
+	other := `{"model":"gpt-4o","messages":[{"role":"user","content":"bye"}]}`
+	if status, body := postJSON(t, srv.URL+"/v1/chat/completions", other, nil); status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	if n := len(policy.calls()); n != 2 {
+		t.Fatalf("%d policy calls after a different request, want 2", n)
+	}
+}
+
+func TestCalloutMultipartAndWebSocket(t *testing.T) {
+	wsURL := func(srv *httptest.Server) string {
+		return "ws" + strings.TrimPrefix(srv.URL, "http") + "/v1/realtime?model=gpt-4o-realtime"
+	}
+	dial := func(t *testing.T, srv *httptest.Server) (int, []byte) {
+		t.Helper()
+		header := http.Header{"Sec-WebSocket-Protocol": {"realtime, " + wsKeyProtocolPrefix + "sk-test-123456"}}
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL(srv), header)
+		if err == nil {
+			conn.Close()
+			return http.StatusSwitchingProtocols, nil
+		}
+		if resp == nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	tests := []struct {
+		name      string
+		decision  string
+		config    string
+		form, ws  int
+		code      string
+		upstreams int
+	}{
+		{"allow", `{"action":"allow"}`, "", http.StatusOK, http.StatusSwitchingProtocols, "", 2},
+		{"deny", `{"action":"deny","message":"blocked"}`, "", http.StatusForbidden, http.StatusForbidden, "policy_denied", 0},
+		// 这两类请求的请求体无法修改，patch 决定按策略服务失败处理
+		{"patch fail closed", `{"action":"patch","patch":[{"op":"replace","path":"/model","value":"x"}]}`, "",
+			http.StatusServiceUnavailable, http.StatusServiceUnavailable, "policy_unavailable", 0},
+		{"patch fail open", `{"action":"patch","patch":[{"op":"replace","path":"/model","value":"x"}]}`, `"fail_open":true`,
+			http.StatusOK, http.StatusSwitchingProtocols, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := newRecordingUpstream(t)
+			policy := newPolicyServer(t, tt.decision)
+			srv := newCalloutProxy(t, up.URL, policy.URL, tt.config)
+
+			if status := postForm(t, srv.URL+"/v1/audio/transcriptions", "whisper-1"); status != tt.form {
+				t.Errorf("multipart status %d, want %d", status, tt.form)
+			}
+			status, body := dial(t, srv)
+			if status != tt.ws {
+				t.Errorf("websocket status %d, want %d: %s", status, tt.ws, body)
+			}
+			if tt.code != "" && status == tt.ws {
+				if code := errorCode(t, body); code != tt.code {
+					t.Errorf("websocket code %q, want %q", code, tt.code)
+				}
+			}
+			if paths, _ := up.seen(); len(paths) != tt.upstreams {
+				t.Errorf("upstream paths %q, want %d requests", paths, tt.upstreams)
+			}
+
+			summaries := policy.calls()
+			if len(summaries) != 2 {
+				t.Fatalf("%d policy calls, want 2", len(summaries))
+			}
+			form, ws := summaries[0], summaries[1]
+			if form.Path != "/v1/audio/transcriptions" || form.Model != "whisper-1" || !form.Partial {
+				t.Errorf("unexpected multipart summary: %+v", form)
+			}
+			if ws.Path != "/v1/realtime" || ws.Model != "gpt-4o-realtime" || !ws.Partial || ws.KeyHash == "" {
+				t.Errorf("unexpected websocket summary: %+v", ws)
+			}
+		})
+	}
+}
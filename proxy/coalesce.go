@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// flight 进行中的上游请求，相同的并发请求加入同一个 flight，共享领头请求的响应
+type flight struct {
+	mu        sync.Mutex
+	notify    chan struct{} // 有新数据时关闭并替换
+	started   bool
+	status    int
+	header    http.Header
+	buf       []byte // 完整的响应，迟到的跟随者先收到已经缓冲的部分
+	done      bool
+	failed    bool
+	followers int
+	orphaned  bool // 领头请求的客户端已经断开
+	cancel    context.CancelFunc
+}
+
+// update 在持有锁的情况下修改 flight 并通知等待的跟随者
+func (f *flight) update(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// leave 一个请求不再需要响应，领头请求的客户端和所有跟随者都离开后取消上游请求
+func (f *flight) leave(leader bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if leader {
+		f.orphaned = true
+	} else {
+		f.followers--
+	}
+	if f.orphaned && f.followers == 0 {
+		f.cancel()
+	}
+}
+
+func (f *flight) hasFollowers() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.followers > 0
+}
+
+// coalescer 合并相同的并发请求
+type coalescer struct {
+	mu       sync.Mutex
+	flights  map[string]*flight
+	led      atomic.Int64
+	followed atomic.Int64
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{flights: make(map[string]*flight)}
+}
+
+// join 加入相同请求的 flight，没有进行中的 flight 时创建一个并成为领头请求
+func (g *coalescer) join(key string) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		f.mu.Lock()
+		f.followers++
+		f.mu.Unlock()
+		g.followed.Add(1)
+		return f, false
+	}
+	f := &flight{notify: make(chan struct{})}
+	g.flights[key] = f
+	g.led.Add(1)
+	return f, true
+}
+
+// finish 领头请求结束，之后相同的请求会重新请求上游
+func (g *coalescer) finish(key string, f *flight, failed bool) {
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+	f.update(func() {
+		f.done = true
+		f.failed = failed
+	})
+}
+
+// coalesceKey 计算请求的合并键：方法、路径、凭证和请求体完全相同的请求才会合并
+func coalesceKey(req *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s?%s\n", req.Method, req.URL.Path, req.URL.RawQuery)
+	fmt.Fprintf(h, "%s\n%s\n", req.Header.Get("Authorization"), req.Header.Get("api-key"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// leadFlight 作为领头请求转发：上游请求不随客户端断开而取消（仍有跟随者时），响应同时写入 flight。
+// 返回的函数在转发结束后调用，completed 表示响应是否完整写出
+func (p *Proxy) leadFlight(c *gin.Context, key string, f *flight) func(completed bool) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+	f.cancel = cancel
+	stop := context.AfterFunc(c.Request.Context(), func() { f.leave(true) })
+	c.Request = c.Request.WithContext(ctx)
+	c.Writer = &flightWriter{ResponseWriter: c.Writer, flight: f}
+
+	return func(completed bool) {
+		stop()
+		p.coalescer.finish(key, f, !completed)
+		cancel()
+	}
+}
+
+// followFlight 作为跟随者等待领头请求的响应：先写出已经缓冲的部分，再随领头请求继续写出
+func (p *Proxy) followFlight(c *gin.Context, f *flight) {
+	p.logger.Info("Coalesced with in-flight request:", c.Request.URL.Path)
+	offset := 0
+	started := false
+	for {
+		f.mu.Lock()
+		notify, done, failed := f.notify, f.done, f.failed
+		chunk := f.buf[offset:]
+		if f.started && !started {
+			started = true
+			for k, v := range f.header {
+				c.Writer.Header()[k] = v
+			}
+			c.Writer.Header().Set("X-Coalesced", "true")
+			c.Writer.WriteHeader(f.status)
+		}
+		f.mu.Unlock()
+
+		if len(chunk) > 0 {
+			offset += len(chunk)
+			if _, err := c.Writer.Write(chunk); err != nil {
+				f.leave(false)
+				return
+			}
+			c.Writer.Flush()
+		}
+		if done {
+			f.leave(false)
+			if !failed {
+				return
+			}
+			if !started {
+				c.JSON(http.StatusBadGateway, gin.H{"error": "coalesced upstream request failed"})
+				return
+			}
+			// 领头请求中途失败，与领头请求一样中断连接
+			panic(http.ErrAbortHandler)
+		}
+
+		select {
+		case <-notify:
+		case <-c.Request.Context().Done():
+			f.leave(false)
+			return
+		}
+	}
+}
+
+// flightWriter 包装领头请求的 ResponseWriter，把写出的响应同时写入 flight；
+// 领头请求的客户端断开后，只要还有跟随者就继续读取上游响应
+type flightWriter struct {
+	gin.ResponseWriter
+	flight *flight
+	gone   bool
+}
+
+// WriteHeader 实现 http.ResponseWriter
+func (w *flightWriter) WriteHeader(code int) {
+	w.start(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *flightWriter) start(code int) {
+	header := w.Header().Clone()
+	w.flight.update(func() {
+		if !w.flight.started {
+			w.flight.started = true
+			w.flight.status = code
+			w.flight.header = header
+		}
+	})
+}
+
+// Write 实现 io.Writer
+func (w *flightWriter) Write(data []byte) (int, error) {
+	w.flight.mu.Lock()
+	started := w.flight.started
+	w.flight.mu.Unlock()
+	if !started {
+		w.start(http.StatusOK)
+	}
+	w.flight.update(func() {
+		w.flight.buf = append(w.flight.buf, data...)
+	})
+
+	if w.gone {
+		return len(data), nil
+	}
+	n, err := w.ResponseWriter.Write(data)
+	if err != nil && w.flight.hasFollowers() {
+		w.gone = true
+		return len(data), nil
+	}
+	return n, err
+}
+
+// WriteString 实现 io.StringWriter
+func (w *flightWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// CoalesceStats 返回作为领头请求转发到上游的请求数和合并到进行中请求的请求数
+func (p *Proxy) CoalesceStats() (led, followed int64) {
+	if p.coalescer == nil {
+		return 0, 0
+	}
+	return p.coalescer.led.Load(), p.coalescer.followed.Load()
+}
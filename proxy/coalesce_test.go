@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	coalesceBody   = `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hello"}]}`
+	coalesceChunk1 = `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"hel"}}]}` + "\n\n"
+	coalesceChunk2 = `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"lo"}}]}` + "\n\n" +
+		"data: [DONE]\n\n"
+)
+
+// fakeStreamUpstream 流式上游：收到请求后等待 begin 再写出第一个事件，等待 release 再写出其余事件；
+// abort 时在第一个事件之后中断连接
+type fakeStreamUpstream struct {
+	*httptest.Server
+	calls    atomic.Int32
+	begin    chan struct{}
+	release  chan struct{}
+	wrote    chan struct{} // 写出第一个事件后关闭
+	canceled chan struct{} // 上游请求被取消时关闭
+	abort    bool
+
+	wroteOnce, canceledOnce sync.Once
+}
+
+func newFakeStreamUpstream(t *testing.T, waitBegin bool) *fakeStreamUpstream {
+	f := &fakeStreamUpstream{
+		begin:    make(chan struct{}),
+		release:  make(chan struct{}),
+		wrote:    make(chan struct{}),
+		canceled: make(chan struct{}),
+	}
+	if !waitBegin {
+		close(f.begin)
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+		<-f.begin
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, coalesceChunk1)
+		w.(http.Flusher).Flush()
+		f.wroteOnce.Do(func() { close(f.wrote) })
+		select {
+		case <-f.release:
+		case <-r.Context().Done():
+			f.canceledOnce.Do(func() { close(f.canceled) })
+			return
+		}
+		if f.abort {
+			panic(http.ErrAbortHandler)
+		}
+		io.WriteString(w, coalesceChunk2)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// streamResult 客户端收到的响应
+type streamResult struct {
+	resp *http.Response
+	body string
+	err  error
+}
+
+// startStream 在后台发送流式请求，读取完整的响应
+func startStream(ctx context.Context, url string) <-chan streamResult {
+	out := make(chan streamResult, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url+"/v1/chat/completions", strings.NewReader(coalesceBody))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			out <- streamResult{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		out <- streamResult{resp: resp, body: string(data), err: err}
+	}()
+	return out
+}
+
+// waitFollowers 等待指定数量的请求加入进行中的 flight
+func waitFollowers(t *testing.T, p *Proxy, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, followed := p.CoalesceStats(); followed >= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d followers did not join", n)
+}
+
+func receive(t *testing.T, ch <-chan streamResult) streamResult {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for response")
+		return streamResult{}
+	}
+}
+
+func TestCoalesceFanOut(t *testing.T) {
+	up := newFakeStreamUpstream(t, true)
+	p, srv := newTestProxy(t, Config{TargetURL: up.URL, CoalesceRequests: true})
+
+	leader := startStream(context.Background(), srv.URL)
+	waitLeader(t, up)
+	var followers []<-chan streamResult
+	for i := 0; i < 3; i++ {
+		followers = append(followers, startStream(context.Background(), srv.URL))
+	}
+	waitFollowers(t, p, 3)
+	close(up.begin)
+	close(up.release)
+
+	want := coalesceChunk1 + coalesceChunk2
+	if r := receive(t, leader); r.err != nil || r.body != want {
+		t.Fatalf("leader: %v %q", r.err, r.body)
+	}
+	for i, ch := range followers {
+		r := receive(t, ch)
+		if r.err != nil || r.body != want {
+			t.Fatalf("follower %d: %v %q", i, r.err, r.body)
+		}
+		if r.resp.Header.Get("X-Coalesced") != "true" || r.resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Errorf("follower %d headers: %v", i, r.resp.Header)
+		}
+	}
+	if n := up.calls.Load(); n != 1 {
+		t.Errorf("%d upstream calls, want 1", n)
+	}
+}
+
+func TestCoalesceLateJoin(t *testing.T) {
+	up := newFakeStreamUpstream(t, false)
+	p, srv := newTestProxy(t, Config{TargetURL: up.URL, CoalesceRequests: true})
+
+	leader := startStream(context.Background(), srv.URL)
+	<-up.wrote
+	// 第一个事件已经写出后加入，先收到缓冲的部分
+	follower := startStream(context.Background(), srv.URL)
+	waitFollowers(t, p, 1)
+	close(up.release)
+
+	want := coalesceChunk1 + coalesceChunk2
+	if r := receive(t, leader); r.err != nil || r.body != want {
+		t.Fatalf("leader: %v %q", r.err, r.body)
+	}
+	if r := receive(t, follower); r.err != nil || r.body != want {
+		t.Fatalf("follower: %v %q", r.err, r.body)
+	}
+	if n := up.calls.Load(); n != 1 {
+		t.Errorf("%d upstream calls, want 1", n)
+	}
+
+	// flight 结束后相同的请求重新请求上游
+	up2 := startStream(context.Background(), srv.URL)
+	if r := receive(t, up2); r.err != nil || r.body != want {
+		t.Fatalf("after finish: %v %q", r.err, r.body)
+	}
+	if n := up.calls.Load(); n != 2 {
+		t.Errorf("%d upstream calls after finish, want 2", n)
+	}
+}
+
+func TestCoalesceLeaderDisconnect(t *testing.T) {
+	up := newFakeStreamUpstream(t, false)
+	p, srv := newTestProxy(t, Config{TargetURL: up.URL, CoalesceRequests: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := startLeaderStream(t, ctx, srv.URL)
+	<-up.wrote
+	follower := startStream(context.Background(), srv.URL)
+	waitFollowers(t, p, 1)
+
+	// 领头请求的客户端断开，仍有跟随者时上游请求继续
+	cancel()
+	leader.Close()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-up.canceled:
+		t.Fatal("upstream request canceled while a follower remains")
+	default:
+	}
+	close(up.release)
+
+	if r := receive(t, follower); r.err != nil || r.body != coalesceChunk1+coalesceChunk2 {
+		t.Fatalf("follower: %v %q", r.err, r.body)
+	}
+}
+
+func TestCoalesceLeaderDisconnectAlone(t *testing.T) {
+	up := newFakeStreamUpstream(t, false)
+	_, srv := newTestProxy(t, Config{TargetURL: up.URL, CoalesceRequests: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := startLeaderStream(t, ctx, srv.URL)
+	<-up.wrote
+	cancel()
+	leader.Close()
+
+	// 没有跟随者时取消上游请求
+	select {
+	case <-up.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request not canceled")
+	}
+}
+
+func TestCoalesceLeaderFailsMidStream(t *testing.T) {
+	up := newFakeStreamUpstream(t, false)
+	up.abort = true
+	p, srv := newTestProxy(t, Config{TargetURL: up.URL, CoalesceRequests: true})
+
+	leader := startStream(context.Background(), srv.URL)
+	<-up.wrote
+	follower := startStream(context.Background(), srv.URL)
+	waitFollowers(t, p, 1)
+	close(up.release)
+
+	// 领头请求中途失败时，跟随者与领头请求一样被中断，不会收到看似完整的响应
+	for name, ch := range map[string]<-chan streamResult{"leader": leader, "follower": follower} {
+		r := receive(t, ch)
+		if r.err == nil {
+			t.Errorf("%s: response not interrupted: %q", name, r.body)
+		}
+		if strings.Contains(r.body, "[DONE]") {
+			t.Errorf("%s: unexpected [DONE]: %q", name, r.body)
+		}
+		if r.resp != nil && r.resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d", name, r.resp.StatusCode)
+		}
+	}
+
+	// 失败的 flight 已经移除，相同的请求重新请求上游
+	receive(t, startStream(context.Background(), srv.URL))
+	if n := up.calls.Load(); n != 2 {
+		t.Errorf("%d upstream calls after failure, want 2", n)
+	}
+}
+
+// waitLeader 等待领头请求到达上游
+func waitLeader(t *testing.T, up *fakeStreamUpstream) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for up.calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if up.calls.Load() == 0 {
+		t.Fatal("leader did not reach upstream")
+	}
+}
+
+// startLeaderStream 发送流式请求并读到第一个事件，返回响应体
+func startLeaderStream(t *testing.T, ctx context.Context, url string) io.ReadCloser {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url+"/v1/chat/completions", strings.NewReader(coalesceBody))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data:") {
+		t.Fatalf("first event: %q %v", line, err)
+	}
+	return resp.Body
+}
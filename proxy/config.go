@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+// LoadConfig 从 JSON 文件加载配置，通过管理 API 修改的配置可以写回该文件
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	cfg.ConfigFile = path
+	return cfg, nil
+}
+
+// currentConfig 返回包含运行时修改（模型列表、模型映射、插件启停和顺序）的配置
+func (p *Proxy) currentConfig() Config {
+	p.mu.RLock()
+	cfg := p.config
+	cfg.Models = append([]ModelInfo(nil), p.config.Models...)
+	cfg.DisabledPlugins = make([]string, 0, len(p.disabled))
+	for name, disabled := range p.disabled {
+		if disabled {
+			cfg.DisabledPlugins = append(cfg.DisabledPlugins, name)
+		}
+	}
+	cfg.PluginOrder = make([]string, 0, len(p.plugins))
+	for _, plugin := range p.plugins {
+		cfg.PluginOrder = append(cfg.PluginOrder, pluginName(plugin))
+	}
+	p.mu.RUnlock()
+
+	sort.Strings(cfg.DisabledPlugins)
+	if mm := p.modelMapPlugin(); mm != nil {
+		cfg.ModelMappings = mm.Mappings()
+	}
+	return cfg
+}
+
+// SaveConfig 把管理 API 可以修改的配置（模型列表、模型映射、插件启停和顺序）写回配置文件，
+// 文件中的其它字段保持原样，不会写入通过代码或命令行设置的凭证（如上游的 Headers、AdminKey）
+func (p *Proxy) SaveConfig() error {
+	if p.config.ConfigFile == "" {
+		return errors.New("proxy was not started from a config file")
+	}
+	data, err := os.ReadFile(p.config.ConfigFile)
+	if err != nil {
+		return err
+	}
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse config file %s: %w", p.config.ConfigFile, err)
+	}
+	if file == nil {
+		file = make(map[string]json.RawMessage)
+	}
+
+	cfg := p.currentConfig()
+	for key, value := range map[string]interface{}{
+		"models":           cfg.Models,
+		"model_mappings":   cfg.ModelMappings,
+		"disabled_plugins": cfg.DisabledPlugins,
+		"plugin_order":     cfg.PluginOrder,
+	} {
+		if file[key], err = json.Marshal(value); err != nil {
+			return err
+		}
+	}
+	return writeJSONFile(p.config.ConfigFile, file)
+}
+
+// modelMapPlugin 返回已注册的模型映射插件
+func (p *Proxy) modelMapPlugin() *pluginPKG.ModelMapPlugin {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, plugin := range p.plugins {
+		if mm, ok := plugin.(*pluginPKG.ModelMapPlugin); ok {
+			return mm
+		}
+	}
+	return nil
+}
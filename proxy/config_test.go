@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveConfigKeepsSecretsOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"target_url":"http://127.0.0.1:1","drain_timeout_seconds":5}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 通过代码设置的凭证不能写入文件
+	conf.Headers = map[string]string{"Authorization": "Bearer sk-secret"}
+	conf.AdminKey = "admin-secret"
+	conf.ModelMappings = map[string]string{"gpt-4o": "ep-1"}
+	conf.Models = []ModelInfo{{ID: "gpt-4o", Object: "model"}}
+	p, _ := newTestProxy(t, conf)
+
+	if err := p.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := string(data)
+	for _, secret := range []string{"sk-secret", "admin-secret", "admin_key", "headers"} {
+		if strings.Contains(saved, secret) {
+			t.Errorf("saved config contains %q:\n%s", secret, saved)
+		}
+	}
+	var cfg Config
+	decodeJSON(t, data, &cfg)
+	if cfg.TargetURL != "http://127.0.0.1:1" || cfg.DrainTimeoutSeconds != 5 {
+		t.Errorf("fields from the file not kept: %+v", cfg)
+	}
+	if cfg.ModelMappings["gpt-4o"] != "ep-1" || len(cfg.Models) != 1 || len(cfg.PluginOrder) == 0 {
+		t.Errorf("admin fields not saved:\n%s", saved)
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -10,10 +11,37 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// StartCursorProxy 创建代理并在后台启动配置的服务（ListenAddr、AdminAddr），返回可以挂载到其它 gin server 的处理函数
 func StartCursorProxy(conf Config, mappings map[string]string) (gin.HandlerFunc, error) {
+	proxy, err := NewCursorProxy(conf, mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	// 如果配置了 ListenAddr 或 AdminAddr，则启动独立服务器
+	if conf.ListenAddr != "" || conf.AdminAddr != "" {
+		go func() {
+			if err := proxy.Start(context.Background()); err != nil {
+				proxy.logger.Error("Failed to start proxy:", err)
+			}
+		}()
+	}
+
+	// 返回处理函数
+	return proxy.handleRequest, nil
+}
+
+// NewCursorProxy 创建代理并注册模型映射、Mock 和缓存等插件，不启动服务，通过 Start 和 Shutdown 控制服务的生命周期
+func NewCursorProxy(conf Config, mappings map[string]string) (_ *Proxy, err error) {
 
 	// 创建代理实例
 	proxy := NewProxy(conf)
+	// 创建失败时停止 NewProxy 启动的健康检查和 batch，关闭已经注册的插件
+	defer func() {
+		if err != nil {
+			_ = proxy.Shutdown(context.Background())
+		}
+	}()
 
 	// 创建并配置模型映射插件
 	modelMapPlugin := plugin.NewModelMapPlugin(proxy.logger)
@@ -23,9 +51,16 @@ func StartCursorProxy(conf Config, mappings map[string]string) (gin.HandlerFunc,
 	// 	modelMapPlugin.AddMapping(key, val)
 	// }
 
-	// 或者通过配置文件加载
+	// 或者通过配置文件加载，配置文件中的映射与参数合并，参数优先
+	merged := make(map[string]string, len(conf.ModelMappings)+len(mappings))
+	for key, val := range conf.ModelMappings {
+		merged[key] = val
+	}
+	for key, val := range mappings {
+		merged[key] = val
+	}
 	pluginConfig := plugin.ModelMapConfig{
-		Mappings: mappings,
+		Mappings: merged,
 	}
 
 	configBytes, _ := json.Marshal(pluginConfig)
@@ -80,19 +115,37 @@ func StartCursorProxy(conf Config, mappings map[string]string) (gin.HandlerFunc,
 		},
 	)
 
-	// 注册插件
-	proxy.RegisterPlugin(mockPlugin)
+	// 注册插件，模型映射在 Mock 之后执行，Mock 规则匹配客户端请求的模型名
+	plugins := []plugin.Plugin{mockPlugin, modelMapPlugin}
 
-	// 如果配置了 ListenAddr，则启动独立服务器
-	if conf.ListenAddr != "" {
-		go func() {
-			proxy.logger.Info("Starting proxy server on ", conf.ListenAddr)
-			if err := proxy.Start(); err != nil {
-				proxy.logger.Error("Failed to start proxy:", err)
-			}
-		}()
+	// 配置了缓存时注册缓存插件
+	if conf.Cache != nil {
+		cachePlugin, err := plugin.NewCachePlugin(proxy.logger, *conf.Cache)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, cachePlugin)
+	}
+	if conf.SemanticCache != nil {
+		plugins = append(plugins, plugin.NewSemanticCachePlugin(proxy.logger, *conf.SemanticCache))
+	}
+	for _, pl := range plugins {
+		if err = proxy.RegisterPlugin(pl); err != nil {
+			return nil, err
+		}
 	}
 
-	// 返回处理函数
-	return proxy.handleRequest, nil
+	// 配置文件中按类型创建的插件
+	if err = proxy.registerConfiguredPlugins(conf.Plugins); err != nil {
+		return nil, err
+	}
+
+	// 按配置调整插件顺序
+	if len(conf.PluginOrder) > 0 {
+		if err = proxy.ReorderPlugins(conf.PluginOrder); err != nil {
+			return nil, err
+		}
+	}
+
+	return proxy, nil
 }
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/plugin"
+)
+
+// closerPlugin 记录 Close 调用次数的插件
+type closerPlugin struct {
+	name   string
+	closed *int
+}
+
+var closerMu sync.Mutex
+
+func (p *closerPlugin) Name() string                               { return p.name }
+func (p *closerPlugin) BeforeRequest(*plugin.RequestContext) error { return nil }
+func (p *closerPlugin) AfterResponse(*http.Response) error         { return nil }
+func (p *closerPlugin) Configure(json.RawMessage) error            { return nil }
+
+func (p *closerPlugin) Close() error {
+	closerMu.Lock()
+	defer closerMu.Unlock()
+	*p.closed++
+	return nil
+}
+
+func TestNewCursorProxyClosesPluginsOnError(t *testing.T) {
+	var closes []*int
+	plugin.Register("test_closer", func(deps plugin.Deps, config json.RawMessage) (plugin.Plugin, error) {
+		var cfg struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(config, &cfg); err != nil {
+			return nil, err
+		}
+		closerMu.Lock()
+		defer closerMu.Unlock()
+		n := new(int)
+		closes = append(closes, n)
+		return &closerPlugin{name: cfg.Name, closed: n}, nil
+	})
+	spec := func(name string) PluginSpec {
+		return PluginSpec{Type: "test_closer", Config: json.RawMessage(`{"name":"` + name + `"}`)}
+	}
+
+	tests := []struct {
+		name  string
+		conf  Config
+		count int
+	}{
+		// 插件名称重复：已注册的插件在 Shutdown 中关闭，未注册的插件直接关闭
+		{"duplicate plugin", Config{Plugins: []PluginSpec{spec("a"), spec("a")}}, 2},
+		// 插件顺序中有不存在的插件
+		{"unknown plugin in order", Config{Plugins: []PluginSpec{spec("b")}, PluginOrder: []string{"missing"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			closerMu.Lock()
+			closes = nil
+			closerMu.Unlock()
+			tt.conf.HealthCheckSeconds = -1
+			tt.conf.BatchDir = t.TempDir()
+			if _, err := NewCursorProxy(tt.conf, nil); err == nil {
+				t.Fatal("expected an error")
+			}
+			closerMu.Lock()
+			defer closerMu.Unlock()
+			if len(closes) != tt.count {
+				t.Fatalf("%d plugins created, want %d", len(closes), tt.count)
+			}
+			for i, n := range closes {
+				if *n != 1 {
+					t.Errorf("plugin %d closed %d times, want 1", i, *n)
+				}
+			}
+		})
+	}
+}
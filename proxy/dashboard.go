@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dashboardStatsInterval 仪表盘推送汇总数据（用量、上游状态、模型映射）的间隔
+const dashboardStatsInterval = 5 * time.Second
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardState 仪表盘展示的数据
+type dashboardState struct {
+	TrafficSnapshot
+	Upstreams []UpstreamStatus  `json:"upstreams"`
+	Mappings  map[string]string `json:"mappings"`
+}
+
+func (p *Proxy) dashboardState(withRequests bool) dashboardState {
+	state := dashboardState{
+		TrafficSnapshot: p.traffic.snapshot(withRequests),
+		Upstreams:       p.UpstreamStatuses(),
+		Mappings:        map[string]string{},
+	}
+	if mm := p.modelMapPlugin(); mm != nil {
+		state.Mappings = mm.Mappings()
+	}
+	return state
+}
+
+// registerDashboard 注册仪表盘页面（不需要鉴权，页面中填写管理密钥后读取数据）
+func (p *Proxy) registerDashboard(r *gin.Engine) {
+	static, _ := fs.Sub(dashboardFiles, "dashboard")
+	r.StaticFS("/dashboard", http.FS(static))
+	r.GET("/", func(c *gin.Context) {
+		c.Redirect(http.StatusFound, "/dashboard/")
+	})
+}
+
+// registerDashboardRoutes 注册仪表盘的数据接口
+func (p *Proxy) registerDashboardRoutes(r *gin.RouterGroup) {
+	r.GET("/dashboard", func(c *gin.Context) {
+		c.JSON(http.StatusOK, p.dashboardState(true))
+	})
+	r.GET("/dashboard/events", p.dashboardEvents)
+}
+
+// dashboardEvents 以 SSE 推送新的请求记录（request 事件），并定时推送汇总数据（stats 事件）
+func (p *Proxy) dashboardEvents(c *gin.Context) {
+	records, cancel := p.traffic.subscribe()
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if err := writeSSE(c.Writer, newJSONEvent("stats", p.dashboardState(false))); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(dashboardStatsInterval)
+	defer ticker.Stop()
+	for {
+		var ev sseEvent
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case rec := <-records:
+			ev = newJSONEvent("request", rec)
+		case <-ticker.C:
+			ev = newJSONEvent("stats", p.dashboardState(false))
+		}
+		if err := writeSSE(c.Writer, ev); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultEmbeddingBatchSize 单次发往上游的 input 数量上限，与 OpenAI 的限制一致
+	defaultEmbeddingBatchSize = 2048
+	// embeddingConcurrency 同一个请求同时发往上游的批次数
+	embeddingConcurrency = 4
+)
+
+// embeddingBatchResult 一个批次的上游响应
+type embeddingBatchResult struct {
+	status int
+	header http.Header
+	body   []byte
+	resp   openai.EmbeddingResponse
+}
+
+// handleEmbeddings 处理 embeddings 请求：按上游的批次大小拆分 input，合并结果，按 dimensions 截断并归一化
+func (p *Proxy) handleEmbeddings(c *gin.Context, upstream *Upstream, body []byte) {
+	var ereq openai.EmbeddingRequest
+	if err := json.Unmarshal(body, &ereq); err != nil {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, "invalid embeddings request: "+err.Error(), nil))
+		return
+	}
+	inputs, err := splitEmbeddingInput(ereq.Input)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, err.Error(), nil))
+		return
+	}
+	if ereq.Dimensions != nil && *ereq.Dimensions <= 0 {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, "dimensions must be a positive integer", nil))
+		return
+	}
+
+	batchSize := upstream.Config.EmbeddingBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultEmbeddingBatchSize
+	}
+	var batches [][]json.RawMessage
+	for start := 0; start < len(inputs); start += batchSize {
+		end := start + batchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		batches = append(batches, inputs[start:end])
+	}
+	p.logger.Info(fmt.Sprintf("Embeddings request: %d inputs in %d batches", len(inputs), len(batches)))
+
+	// 上游总是返回浮点数组，截断和 base64 编码在本地完成
+	reqs := make([]*http.Request, len(batches))
+	for i, batch := range batches {
+		input, _ := json.Marshal(batch)
+		batchBody, _ := json.Marshal(openai.EmbeddingRequest{Model: ereq.Model, Input: input, User: ereq.User})
+		if reqs[i], err = newEmbeddingRequest(c.Request, upstream, batchBody); err != nil {
+			p.logger.Error("Failed to rewrite request for upstream:", err)
+			c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, err.Error(), nil))
+			return
+		}
+	}
+
+	results := make([]*embeddingBatchResult, len(batches))
+	errs := make([]error, len(batches))
+	client := &http.Client{Transport: &LoggingTransport{Transport: upstream.transport, Logger: p.logger}}
+	sem := make(chan struct{}, embeddingConcurrency)
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = sendEmbeddingBatch(client, upstream, reqs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	out := openai.EmbeddingResponse{Object: "list", Data: make([]openai.Embedding, 0, len(inputs)), Model: ereq.Model}
+	offset := 0
+	for i, result := range results {
+		if errs[i] != nil {
+			p.logger.Error("Embeddings batch failed:", errs[i])
+			c.JSON(http.StatusBadGateway, openai.NewErrorResponse(http.StatusBadGateway, errs[i].Error(), nil))
+			return
+		}
+		// 任一批次失败时原样返回该批次的错误
+		if result.status >= http.StatusBadRequest {
+			contentType := result.header.Get("Content-Type")
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			c.Data(result.status, contentType, result.body)
+			return
+		}
+		if len(result.resp.Data) != len(batches[i]) {
+			msg := fmt.Sprintf("upstream returned %d embeddings for %d inputs", len(result.resp.Data), len(batches[i]))
+			c.JSON(http.StatusBadGateway, openai.NewErrorResponse(http.StatusBadGateway, msg, nil))
+			return
+		}
+		for _, emb := range result.resp.Data {
+			emb.Object = "embedding"
+			emb.Index += offset
+			if ereq.Dimensions != nil {
+				emb.Embedding = emb.Embedding.Truncate(*ereq.Dimensions)
+			}
+			out.Data = append(out.Data, emb)
+		}
+		offset += len(batches[i])
+		if result.resp.Model != "" {
+			out.Model = result.resp.Model
+		}
+		out.Usage.PromptTokens += result.resp.Usage.PromptTokens
+		out.Usage.TotalTokens += result.resp.Usage.TotalTokens
+	}
+
+	if ereq.EncodingFormat != "base64" {
+		c.JSON(http.StatusOK, out)
+		return
+	}
+	data := make([]gin.H, 0, len(out.Data))
+	for _, emb := range out.Data {
+		data = append(data, gin.H{"object": emb.Object, "index": emb.Index, "embedding": emb.Embedding.Base64()})
+	}
+	c.JSON(http.StatusOK, gin.H{"object": out.Object, "data": data, "model": out.Model, "usage": out.Usage})
+}
+
+// splitEmbeddingInput 把 input 拆分为单个输入：字符串、字符串数组、token 数组或 token 数组的数组
+func splitEmbeddingInput(raw json.RawMessage) ([]json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, errors.New("input is required")
+	}
+	if raw[0] != '[' {
+		return []json.RawMessage{raw}, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, errors.New("input must not be empty")
+	}
+	// 整数数组是单个 token 数组输入
+	if first := bytes.TrimSpace(items[0]); len(first) > 0 && first[0] != '"' && first[0] != '[' {
+		return []json.RawMessage{raw}, nil
+	}
+	return items, nil
+}
+
+// newEmbeddingRequest 创建发往上游的批次请求，经过适配器改写路径、认证头和请求体
+func newEmbeddingRequest(src *http.Request, upstream *Upstream, body []byte) (*http.Request, error) {
+	u := *src.URL
+	req, err := http.NewRequestWithContext(src.Context(), http.MethodPost, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = src.Header.Clone()
+	req.Header.Del("Origin")
+	req.Header.Del("Referer")
+	req.Header.Del("Content-Length")
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("Content-Type", "application/json")
+
+	if body, err = upstream.adapter.RewriteRequest(req, body); err != nil {
+		return nil, err
+	}
+	req.URL.Scheme = upstream.target.Scheme
+	req.URL.Host = upstream.target.Host
+	req.Host = upstream.target.Host
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return req, nil
+}
+
+// sendEmbeddingBatch 发送一个批次，响应经过适配器转换
+func sendEmbeddingBatch(client *http.Client, upstream *Upstream, req *http.Request) (*embeddingBatchResult, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		upstream.observe(0, err, time.Since(start))
+		return nil, err
+	}
+	upstream.observe(resp.StatusCode, nil, time.Since(start))
+	defer resp.Body.Close()
+	if err := upstream.adapter.ModifyResponse(resp); err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &embeddingBatchResult{status: resp.StatusCode, header: resp.Header, body: respBody}
+	if resp.StatusCode < http.StatusBadRequest {
+		if err := json.Unmarshal(respBody, &result.resp); err != nil {
+			return nil, fmt.Errorf("invalid embeddings response from upstream: %w", err)
+		}
+	}
+	return result, nil
+}
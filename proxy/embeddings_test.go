@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// fakeEmbeddings 本地的 embeddings 上游，输入 "n" 返回向量 [n, 3, 4]，收到的每个批次的 input 数量通过 batches 读取
+type fakeEmbeddings struct {
+	*httptest.Server
+	mu    sync.Mutex
+	sizes []int
+}
+
+func newFakeEmbeddings(t *testing.T) *fakeEmbeddings {
+	f := &fakeEmbeddings{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.EmbeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid embeddings request: %v", err)
+		}
+		var inputs []json.RawMessage
+		if err := json.Unmarshal(req.Input, &inputs); err != nil {
+			t.Errorf("batch input is not an array: %s", req.Input)
+		}
+		f.mu.Lock()
+		f.sizes = append(f.sizes, len(inputs))
+		f.mu.Unlock()
+
+		resp := openai.EmbeddingResponse{Object: "list", Model: "text-embedding-3-small"}
+		for i, input := range inputs {
+			var text string
+			_ = json.Unmarshal(input, &text)
+			switch text {
+			case "fail":
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				io.WriteString(w, `{"error":{"message":"slow down","type":"requests"}}`)
+				return
+			case "drop":
+				continue
+			}
+			n, _ := strconv.Atoi(text)
+			resp.Data = append(resp.Data, openai.Embedding{Object: "embedding", Index: i, Embedding: openai.EmbeddingVector{float32(n), 3, 4}})
+		}
+		resp.Usage.PromptTokens = len(inputs)
+		resp.Usage.TotalTokens = len(inputs)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// batches 返回收到的每个批次的 input 数量，按从大到小排序
+func (f *fakeEmbeddings) batches() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sizes := append([]int(nil), f.sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	f.sizes = nil
+	return sizes
+}
+
+func TestEmbeddingsBatching(t *testing.T) {
+	f := newFakeEmbeddings(t)
+	_, srv := newTestProxy(t, Config{Upstreams: []UpstreamConfig{{Name: "openai", TargetURL: f.URL, EmbeddingBatchSize: 2}}})
+
+	tests := []struct {
+		name    string
+		input   string
+		batches []int
+		first   []float32 // 每个结果向量的第一维，对应输入的编号
+	}{
+		{"single string", `"7"`, []int{1}, []float32{7}},
+		{"token array", `[1,2,3]`, []int{1}, []float32{0}},
+		{"split into batches", `["0","1","2","3","4"]`, []int{2, 2, 1}, []float32{0, 1, 2, 3, 4}},
+		{"token arrays", `[[1],[2],[3]]`, []int{2, 1}, []float32{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := postJSON(t, srv.URL+"/v1/embeddings", `{"model":"text-embedding-3-small","input":`+tt.input+`}`, nil)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if got := f.batches(); fmt.Sprint(got) != fmt.Sprint(tt.batches) {
+				t.Errorf("batches %v, want %v", got, tt.batches)
+			}
+			var resp openai.EmbeddingResponse
+			decodeJSON(t, body, &resp)
+			if len(resp.Data) != len(tt.first) || resp.Usage.PromptTokens != len(tt.first) || resp.Model != "text-embedding-3-small" {
+				t.Fatalf("unexpected response: %s", body)
+			}
+			// 合并后的结果按输入顺序排列，index 连续
+			for i, emb := range resp.Data {
+				if emb.Index != i || emb.Embedding[0] != tt.first[i] {
+					t.Errorf("data[%d] = index %d, embedding %v", i, emb.Index, emb.Embedding)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddingsDimensions(t *testing.T) {
+	f := newFakeEmbeddings(t)
+	_, srv := newTestProxy(t, Config{TargetURL: f.URL})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		check  func(t *testing.T, body []byte)
+	}{
+		{"truncate and normalize", `{"model":"m","input":["0"],"dimensions":2}`, http.StatusOK, func(t *testing.T, body []byte) {
+			var resp openai.EmbeddingResponse
+			decodeJSON(t, body, &resp)
+			if v := resp.Data[0].Embedding; len(v) != 2 || v[0] != 0 || v[1] != 1 {
+				t.Errorf("unexpected embedding: %v", v)
+			}
+		}},
+		{"dimensions larger than vector", `{"model":"m","input":"0","dimensions":8}`, http.StatusOK, func(t *testing.T, body []byte) {
+			var resp openai.EmbeddingResponse
+			decodeJSON(t, body, &resp)
+			if v := resp.Data[0].Embedding; len(v) != 3 || v[1] != 3 {
+				t.Errorf("unexpected embedding: %v", v)
+			}
+		}},
+		{"base64", `{"model":"m","input":"0","dimensions":2,"encoding_format":"base64"}`, http.StatusOK, func(t *testing.T, body []byte) {
+			var resp struct {
+				Data []struct {
+					Embedding string `json:"embedding"`
+				} `json:"data"`
+			}
+			decodeJSON(t, body, &resp)
+			if want := (openai.EmbeddingVector{0, 1}).Base64(); len(resp.Data) != 1 || resp.Data[0].Embedding != want {
+				t.Errorf("unexpected base64 embedding: %s, want %s", body, want)
+			}
+		}},
+		{"invalid dimensions", `{"model":"m","input":"0","dimensions":0}`, http.StatusBadRequest, nil},
+		{"empty input", `{"model":"m","input":[]}`, http.StatusBadRequest, nil},
+		{"upstream error", `{"model":"m","input":["0","fail"]}`, http.StatusTooManyRequests, func(t *testing.T, body []byte) {
+			var resp openai.ErrorResponse
+			decodeJSON(t, body, &resp)
+			if resp.Error.Message != "slow down" {
+				t.Errorf("upstream error not returned: %s", body)
+			}
+		}},
+		{"missing embeddings", `{"model":"m","input":["0","drop"]}`, http.StatusBadGateway, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := postJSON(t, srv.URL+"/v1/embeddings", tt.body, nil)
+			if status != tt.status {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if tt.check != nil {
+				tt.check(t, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// errFileNotFound 文件不存在
+var errFileNotFound = errors.New("file not found")
+
+// fileObject /v1/files 的文件对象
+type fileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+	Owner     string `json:"-"` // 上传者 key 的哈希，只有同一个 key 可以访问
+}
+
+// storedFile 磁盘上的文件元数据，比返回给客户端的多了 Owner
+type storedFile struct {
+	fileObject
+	Owner string `json:"owner,omitempty"`
+}
+
+// keyOwner 返回客户端 key 的哈希，用于隔离不同 key 的文件和 batch；没有 key 时为空
+func keyOwner(h http.Header) string {
+	key := clientKey(h)
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// fileStore 保存在本地磁盘的文件，每个文件是 {id}.data 和 {id}.json 两个文件
+type fileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+// validStoreID 检查 ID 只包含字母、数字、下划线和连字符，防止路径穿越
+func validStoreID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *fileStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".data")
+}
+
+func (s *fileStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// create 把 r 的内容保存为新文件
+func (s *fileStore) create(filename, purpose, owner string, r io.Reader) (*fileObject, error) {
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	obj, err := s.add(tmp.Name(), filename, purpose, owner)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	obj.Bytes = n
+	return obj, nil
+}
+
+// add 把已经写好的本地文件移动到存储中
+func (s *fileStore) add(src, filename, purpose, owner string) (*fileObject, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	obj := &fileObject{
+		ID:        newID("file"),
+		Object:    "file",
+		Bytes:     info.Size(),
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+		Status:    "processed",
+		Owner:     owner,
+	}
+	if err := os.Rename(src, s.dataPath(obj.ID)); err != nil {
+		return nil, err
+	}
+	if err := s.save(obj); err != nil {
+		os.Remove(s.dataPath(obj.ID))
+		return nil, err
+	}
+	return obj, nil
+}
+
+// save 写入文件元数据
+func (s *fileStore) save(obj *fileObject) error {
+	return writeJSONFile(s.metaPath(obj.ID), storedFile{fileObject: *obj, Owner: obj.Owner})
+}
+
+// get 读取文件，owner 与上传者不同时按不存在处理
+func (s *fileStore) get(id, owner string) (*fileObject, error) {
+	if !validStoreID(id) {
+		return nil, errFileNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored storedFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Owner != owner {
+		return nil, errFileNotFound
+	}
+	obj := stored.fileObject
+	obj.Owner = stored.Owner
+	return &obj, nil
+}
+
+// list 按创建时间倒序列出 owner 的文件，purpose 为空时列出全部
+func (s *fileStore) list(purpose, owner string) ([]fileObject, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]fileObject, 0, len(matches))
+	for _, m := range matches {
+		obj, err := s.get(strings.TrimSuffix(filepath.Base(m), ".json"), owner)
+		if err != nil {
+			continue
+		}
+		if purpose == "" || obj.Purpose == purpose {
+			out = append(out, *obj)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt > out[j].CreatedAt })
+	return out, nil
+}
+
+func (s *fileStore) delete(id, owner string) error {
+	if _, err := s.get(id, owner); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.metaPath(id)); err != nil {
+		return err
+	}
+	return os.Remove(s.dataPath(id))
+}
+
+// writeJSONFile 先写临时文件再重命名，避免进程退出时留下不完整的文件
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// handleFiles 处理 /v1/files 请求
+func (m *batchManager) handleFiles(c *gin.Context) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(c.Request.URL.Path, "/v1/files"), "/"), "/")
+	owner := keyOwner(c.Request.Header)
+
+	switch {
+	case id == "" && c.Request.Method == http.MethodPost:
+		m.uploadFile(c)
+	case id == "" && c.Request.Method == http.MethodGet:
+		files, err := m.files.list(c.Query("purpose"), owner)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"object": "list", "data": files, "has_more": false})
+	case sub == "" && c.Request.Method == http.MethodGet:
+		obj, err := m.files.get(id, owner)
+		if err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, obj)
+	case sub == "content" && c.Request.Method == http.MethodGet:
+		if _, err := m.files.get(id, owner); err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		c.Header("Content-Type", "application/jsonl")
+		c.File(m.files.dataPath(id))
+	case sub == "" && c.Request.Method == http.MethodDelete:
+		if err := m.files.delete(id, owner); err != nil {
+			writeStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "object": "file", "deleted": true})
+	default:
+		c.JSON(http.StatusNotFound, openai.NewErrorResponse(http.StatusNotFound, "unknown files endpoint", nil))
+	}
+}
+
+// uploadFile 以流的方式把 multipart 中的 file 字段写入磁盘
+func (m *batchManager) uploadFile(c *gin.Context) {
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, "file upload must be multipart/form-data", nil))
+		return
+	}
+
+	purpose := ""
+	var obj *fileObject
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, "invalid multipart body: "+err.Error(), nil))
+			return
+		}
+		switch part.FormName() {
+		case "purpose":
+			value, _ := io.ReadAll(io.LimitReader(part, 256))
+			purpose = string(value)
+		case "file":
+			if obj != nil {
+				c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, "only one file can be uploaded", nil))
+				return
+			}
+			if obj, err = m.files.create(part.FileName(), "", keyOwner(c.Request.Header), part); err != nil {
+				writeStoreError(c, err)
+				return
+			}
+		}
+		part.Close()
+	}
+	if obj == nil {
+		c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, "missing file field", nil))
+		return
+	}
+
+	// purpose 可能出现在文件之后，最后再写入
+	obj.Purpose = purpose
+	if err := m.files.save(obj); err != nil {
+		writeStoreError(c, err)
+		return
+	}
+	m.p.logger.Info(fmt.Sprintf("File uploaded: %s %s (%d bytes)", obj.ID, obj.Filename, obj.Bytes))
+	c.JSON(http.StatusOK, obj)
+}
+
+// writeStoreError 把本地存储的错误转换为 OpenAI 错误响应
+func writeStoreError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errFileNotFound) || errors.Is(err, errBatchNotFound) {
+		status = http.StatusNotFound
+	}
+	c.JSON(status, openai.NewErrorResponse(status, err.Error(), nil))
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	"github.com/gin-gonic/gin"
+)
+
+// frontend 对外协议前端，把其它协议的请求转换为 chat/completions 请求，并把响应转换回去
+type frontend interface {
+	// prepare 把前端协议的请求转换为 chat/completions 请求体，并创建对应的响应转换器
+	prepare(req *http.Request, body []byte) ([]byte, responseTranslator, error)
+	// writeError 以前端协议的格式返回错误
+	writeError(c *gin.Context, status int, err error)
+}
+
+// responseTranslator 把 chat/completions 响应转换为前端协议
+type responseTranslator interface {
+	// translateResponse 转换非流式响应，包括错误响应
+	translateResponse(status int, body []byte) []byte
+	// translateChunk 转换流式响应的一个分片
+	translateChunk(chunk *openai.ChatCompletionChunk) []sseEvent
+	// finishStream 流式响应结束时输出收尾事件
+	finishStream() []sseEvent
+}
+
+// frontendFor 返回请求路径对应的前端，chat/completions 等原生路径返回 nil
+func (p *Proxy) frontendFor(req *http.Request) frontend {
+	if req.Method != http.MethodPost {
+		return nil
+	}
+	switch req.URL.Path {
+	case "/v1/messages":
+		return anthropicFrontend{}
+	case "/v1/responses":
+		return responsesFrontend{store: p.responses}
+	case "/v1/completions":
+		return completionsFrontend{}
+	}
+	return nil
+}
+
+// translateWriter 包装 ResponseWriter，把写出的 chat/completions 响应转换为前端协议
+type translateWriter struct {
+	gin.ResponseWriter
+	translator responseTranslator
+	status     int
+	started    bool
+	stream     bool
+	finished   bool
+	buf        bytes.Buffer
+	parser     sseParser
+	size       int
+}
+
+func newTranslateWriter(w gin.ResponseWriter, translator responseTranslator) *translateWriter {
+	return &translateWriter{
+		ResponseWriter: w,
+		translator:     translator,
+		status:         http.StatusOK,
+	}
+}
+
+// WriteHeader 只记录状态码，实际写出推迟到确定响应类型之后
+func (w *translateWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+// WriteHeaderNow 实现 gin.ResponseWriter
+func (w *translateWriter) WriteHeaderNow() {}
+
+func (w *translateWriter) Write(data []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.stream = w.status < http.StatusBadRequest &&
+			strings.Contains(w.Header().Get("Content-Type"), "text/event-stream")
+		if w.stream {
+			w.Header().Del("Content-Length")
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+
+	if !w.stream {
+		return w.buf.Write(data)
+	}
+	for _, ev := range w.parser.Feed(data) {
+		if err := w.writeChunk(ev); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *translateWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// writeChunk 转换并写出一个 chat.completion.chunk 事件
+func (w *translateWriter) writeChunk(ev sseEvent) error {
+	if string(ev.Data) == sseDone {
+		return w.writeEvents(w.translator.finishStream())
+	}
+	var chunk openai.ChatCompletionChunk
+	if err := json.Unmarshal(ev.Data, &chunk); err != nil {
+		return nil
+	}
+	return w.writeEvents(w.translator.translateChunk(&chunk))
+}
+
+func (w *translateWriter) writeEvents(events []sseEvent) error {
+	for _, ev := range events {
+		var buf bytes.Buffer
+		_ = writeSSE(&buf, ev)
+		n, err := w.ResponseWriter.Write(buf.Bytes())
+		w.size += n
+		if err != nil {
+			return err
+		}
+	}
+	if len(events) > 0 {
+		w.ResponseWriter.Flush()
+	}
+	return nil
+}
+
+// finish 在代理正常处理完成后调用，输出缓冲的非流式响应或流式响应的收尾事件；上游中途失败时不调用
+func (w *translateWriter) finish() {
+	if w.finished {
+		return
+	}
+	w.finished = true
+
+	if w.stream {
+		for _, ev := range w.parser.Flush() {
+			_ = w.writeChunk(ev)
+		}
+		_ = w.writeEvents(w.translator.finishStream())
+		return
+	}
+
+	body := w.translator.translateResponse(w.status, w.buf.Bytes())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	n, _ := w.ResponseWriter.Write(body)
+	w.size += n
+}
+
+// Flush 实现 http.Flusher，非流式响应在 finish 之前不能提前写出 header
+func (w *translateWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// Written 实现 gin.ResponseWriter
+func (w *translateWriter) Written() bool {
+	return w.started || w.finished
+}
+
+// Status 实现 gin.ResponseWriter
+func (w *translateWriter) Status() int {
+	return w.status
+}
+
+// Size 实现 gin.ResponseWriter
+func (w *translateWriter) Size() int {
+	return w.size
+}
+
+// errorMessage 从 chat/completions 的错误响应中提取错误类型和信息
+func errorMessage(status int, body []byte) (string, string) {
+	var errResp openai.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
+		errType := errResp.Error.Type
+		if errType == "" {
+			errType = openai.ErrorTypeForStatus(status)
+		}
+		return errType, errResp.Error.Message
+	}
+	// 兼容 gin.H{"error": "..."} 形式的错误
+	var simple struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &simple); err == nil && simple.Error != "" {
+		return openai.ErrorTypeForStatus(status), simple.Error
+	}
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return openai.ErrorTypeForStatus(status), message
+}
+
+// newID 生成带前缀的随机 ID，如 resp_xxx
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + "_" + hex.EncodeToString(b)
+}
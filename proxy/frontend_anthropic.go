@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	"github.com/gin-gonic/gin"
+)
+
+// anthropicFrontend 接收 Anthropic Messages API 请求，转换为 chat/completions 转发到上游
+type anthropicFrontend struct{}
+
+func (anthropicFrontend) prepare(req *http.Request, body []byte) ([]byte, responseTranslator, error) {
+	var areq anthropicRequest
+	if err := json.Unmarshal(body, &areq); err != nil {
+		return nil, nil, fmt.Errorf("invalid messages request: %w", err)
+	}
+
+	creq := openai.ChatCompletionRequest{
+		Model:       areq.Model,
+		Stream:      areq.Stream,
+		Temperature: areq.Temperature,
+		TopP:        areq.TopP,
+		Stop:        areq.StopSequences,
+	}
+	if areq.MaxTokens > 0 {
+		creq.MaxTokens = &areq.MaxTokens
+	}
+	if areq.Stream {
+		creq.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	if areq.Metadata != nil {
+		creq.User = areq.Metadata.UserID
+	}
+
+	if system := areq.System.Text(); system != "" {
+		creq.Messages = append(creq.Messages, openai.Message{Role: "system", Content: system})
+	}
+	for _, msg := range areq.Messages {
+		creq.Messages = append(creq.Messages, anthropicToOpenAIMessages(msg)...)
+	}
+
+	for _, tool := range areq.Tools {
+		creq.Tools = append(creq.Tools, openai.Tool{
+			Type: "function",
+			Function: openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if tc := areq.ToolChoice; tc != nil {
+		switch tc.Type {
+		case "any":
+			creq.ToolChoice = openai.MarshalToolChoice(openai.ToolChoice{Mode: "required"})
+		case "tool":
+			creq.ToolChoice = openai.MarshalToolChoice(openai.ToolChoice{Mode: "function", Function: tc.Name})
+		default:
+			creq.ToolChoice = openai.MarshalToolChoice(openai.ToolChoice{Mode: tc.Type})
+		}
+		if tc.DisableParallelToolUse != nil && *tc.DisableParallelToolUse {
+			parallel := false
+			creq.ParallelToolCalls = &parallel
+		}
+	}
+
+	// Anthropic 客户端通过 x-api-key 认证，转换为上游使用的 Bearer token
+	if key := req.Header.Get("x-api-key"); key != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	req.Header.Del("x-api-key")
+	req.Header.Del("anthropic-version")
+	req.Header.Del("anthropic-beta")
+
+	chatBody, err := json.Marshal(creq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chatBody, &anthropicTranslator{model: areq.Model}, nil
+}
+
+func (anthropicFrontend) writeError(c *gin.Context, status int, err error) {
+	c.JSON(status, newAnthropicError(openai.ErrorTypeForStatus(status), err.Error()))
+}
+
+// anthropicToOpenAIMessages 转换一条 Anthropic 消息，tool_result 块会拆分为独立的 tool 消息
+func anthropicToOpenAIMessages(msg anthropicMessage) []openai.Message {
+	var out []openai.Message
+	if msg.Role == "assistant" {
+		assistant := openai.Message{Role: "assistant"}
+		for _, block := range msg.Content {
+			switch block.Type {
+			case "text":
+				assistant.Content += block.Text
+			case "tool_use":
+				input := string(block.Input)
+				if input == "" {
+					input = "{}"
+				}
+				assistant.ToolCalls = append(assistant.ToolCalls, openai.ToolCall{
+					ID:       block.ID,
+					Type:     "function",
+					Function: openai.FunctionCall{Name: block.Name, Arguments: input},
+				})
+			}
+		}
+		return append(out, assistant)
+	}
+
+	user := openai.Message{Role: msg.Role}
+	hasImage := false
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "tool_result":
+			content := block.Content.Text()
+			if block.IsError && content != "" {
+				content = "Error: " + content
+			}
+			out = append(out, openai.Message{Role: "tool", ToolCallID: block.ToolUseID, Content: content})
+		case "text":
+			user.Content += block.Text
+			user.Parts = append(user.Parts, openai.ContentPart{Type: "text", Text: block.Text})
+		case "image":
+			if block.Source == nil {
+				continue
+			}
+			imageURL := block.Source.URL
+			if block.Source.Type == "base64" {
+				imageURL = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+			}
+			hasImage = true
+			user.Parts = append(user.Parts, openai.ContentPart{Type: "image_url", ImageURL: &openai.ImageURL{URL: imageURL}})
+		}
+	}
+	if !hasImage {
+		user.Parts = nil
+	}
+	if user.Content != "" || hasImage {
+		out = append(out, user)
+	}
+	return out
+}
+
+// anthropicTranslator 把 chat/completions 响应转换为 Anthropic Messages 响应
+type anthropicTranslator struct {
+	model string
+
+	// 流式转换状态
+	started    bool
+	done       bool
+	nextIndex  int
+	blockType  string // 当前打开的内容块类型，为空表示没有打开的块
+	toolIndex  int    // 当前 tool_use 块对应的 OpenAI tool_calls 下标
+	stopReason string
+	usage      anthropicUsage
+}
+
+func (t *anthropicTranslator) translateResponse(status int, body []byte) []byte {
+	if status >= http.StatusBadRequest {
+		errType, message := errorMessage(status, body)
+		out, _ := json.Marshal(newAnthropicError(errType, message))
+		return out
+	}
+
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+
+	out := anthropicResponse{
+		ID:      "msg_" + resp.ID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   t.model,
+		Content: []anthropicBlock{},
+	}
+	stopReason := "end_turn"
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		if choice.Message.Content != "" {
+			out.Content = append(out.Content, anthropicBlock{Type: "text", Text: choice.Message.Content})
+		}
+		for _, tc := range choice.Message.ToolCalls {
+			out.Content = append(out.Content, anthropicBlock{
+				Type:  "tool_use",
+				ID:    tc.ID,
+				Name:  tc.Function.Name,
+				Input: toolInput(tc.Function.Arguments),
+			})
+		}
+		stopReason = anthropicStopReason(choice.FinishReason)
+	}
+	out.StopReason = &stopReason
+	if resp.Usage != nil {
+		out.Usage = anthropicUsage{InputTokens: resp.Usage.PromptTokens, OutputTokens: resp.Usage.CompletionTokens}
+	}
+
+	data, _ := json.Marshal(out)
+	return data
+}
+
+// toolInput 把函数调用参数转换为 tool_use 的 input，参数不是合法 JSON 对象时返回空对象
+func toolInput(arguments string) json.RawMessage {
+	if strings.TrimSpace(arguments) == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+func (t *anthropicTranslator) translateChunk(chunk *openai.ChatCompletionChunk) []sseEvent {
+	var events []sseEvent
+	if !t.started {
+		events = append(events, t.messageStart(chunk.ID))
+	}
+	if chunk.Usage != nil {
+		t.usage = anthropicUsage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			if t.blockType != "text" {
+				events = append(events, t.openBlock(map[string]interface{}{"type": "text", "text": ""})...)
+			}
+			events = append(events, t.blockDelta(anthropicDelta{Type: "text_delta", Text: choice.Delta.Content}))
+		}
+		for _, tc := range choice.Delta.ToolCalls {
+			index := 0
+			if tc.Index != nil {
+				index = *tc.Index
+			}
+			if t.blockType != "tool_use" || index != t.toolIndex {
+				t.toolIndex = index
+				events = append(events, t.openBlock(map[string]interface{}{
+					"type":  "tool_use",
+					"id":    tc.ID,
+					"name":  tc.Function.Name,
+					"input": map[string]interface{}{},
+				})...)
+			}
+			if tc.Function.Arguments != "" {
+				events = append(events, t.blockDelta(anthropicDelta{Type: "input_json_delta", PartialJSON: tc.Function.Arguments}))
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			t.stopReason = anthropicStopReason(*choice.FinishReason)
+		}
+	}
+	return events
+}
+
+func (t *anthropicTranslator) finishStream() []sseEvent {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	var events []sseEvent
+	if !t.started {
+		events = append(events, t.messageStart(""))
+	}
+	events = append(events, t.closeBlock()...)
+
+	stopReason := t.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	events = append(events,
+		newJSONEvent("message_delta", anthropicStreamEvent{
+			Type:  "message_delta",
+			Delta: &anthropicDelta{StopReason: &stopReason},
+			Usage: &t.usage,
+		}),
+		newJSONEvent("message_stop", anthropicStreamEvent{Type: "message_stop"}),
+	)
+	return events
+}
+
+func (t *anthropicTranslator) messageStart(id string) sseEvent {
+	t.started = true
+	return newJSONEvent("message_start", anthropicStreamEvent{
+		Type: "message_start",
+		Message: &anthropicResponse{
+			ID:      "msg_" + id,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   t.model,
+			Content: []anthropicBlock{},
+		},
+	})
+}
+
+// openBlock 关闭当前内容块并打开新的内容块
+func (t *anthropicTranslator) openBlock(block map[string]interface{}) []sseEvent {
+	events := t.closeBlock()
+	t.blockType = block["type"].(string)
+	index := t.nextIndex
+	return append(events, newJSONEvent("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         index,
+		"content_block": block,
+	}))
+}
+
+func (t *anthropicTranslator) closeBlock() []sseEvent {
+	if t.blockType == "" {
+		return nil
+	}
+	index := t.nextIndex
+	t.nextIndex++
+	t.blockType = ""
+	return []sseEvent{newJSONEvent("content_block_stop", anthropicStreamEvent{Type: "content_block_stop", Index: &index})}
+}
+
+func (t *anthropicTranslator) blockDelta(delta anthropicDelta) sseEvent {
+	index := t.nextIndex
+	return newJSONEvent("content_block_delta", anthropicStreamEvent{Type: "content_block_delta", Index: &index, Delta: &delta})
+}
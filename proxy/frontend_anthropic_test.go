@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+func TestAnthropicFrontendRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		header http.Header
+		check  func(t *testing.T, req openai.ChatCompletionRequest, header http.Header)
+	}{
+		{"system and text", `{"model":"claude","max_tokens":64,"system":[{"type":"text","text":"be brief"}],
+			"stop_sequences":["END"],"metadata":{"user_id":"u1"},"messages":[{"role":"user","content":"hi"}]}`, nil,
+			func(t *testing.T, req openai.ChatCompletionRequest, _ http.Header) {
+				if req.Model != "claude" || req.MaxTokens == nil || *req.MaxTokens != 64 || req.User != "u1" || len(req.Stop) != 1 {
+					t.Errorf("unexpected request: %+v", req)
+				}
+				if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[0].Content != "be brief" ||
+					req.Messages[1].Content != "hi" {
+					t.Errorf("unexpected messages: %+v", req.Messages)
+				}
+			}},
+		{"tool use and results", `{"model":"claude","max_tokens":64,"messages":[
+			{"role":"user","content":"weather?"},
+			{"role":"assistant","content":[{"type":"text","text":"checking"},{"type":"tool_use","id":"tu_1","name":"get_weather","input":{"city":"Paris"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"tu_1","content":"timeout","is_error":true},{"type":"text","text":"retry"}]}]}`, nil,
+			func(t *testing.T, req openai.ChatCompletionRequest, _ http.Header) {
+				if len(req.Messages) != 4 {
+					t.Fatalf("unexpected messages: %+v", req.Messages)
+				}
+				assistant := req.Messages[1]
+				if assistant.Content != "checking" || len(assistant.ToolCalls) != 1 ||
+					assistant.ToolCalls[0].ID != "tu_1" || assistant.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+					t.Errorf("unexpected assistant message: %+v", assistant)
+				}
+				if tool := req.Messages[2]; tool.Role != "tool" || tool.ToolCallID != "tu_1" || tool.Content != "Error: timeout" {
+					t.Errorf("unexpected tool message: %+v", tool)
+				}
+				if user := req.Messages[3]; user.Role != "user" || user.Content != "retry" {
+					t.Errorf("unexpected user message: %+v", user)
+				}
+			}},
+		{"image", `{"model":"claude","max_tokens":64,"messages":[{"role":"user","content":[
+			{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}},{"type":"text","text":"what is this"}]}]}`, nil,
+			func(t *testing.T, req openai.ChatCompletionRequest, _ http.Header) {
+				parts := req.Messages[0].Parts
+				if len(parts) != 2 || parts[0].ImageURL == nil || parts[0].ImageURL.URL != "data:image/png;base64,AAAA" || parts[1].Text != "what is this" {
+					t.Errorf("unexpected parts: %+v", parts)
+				}
+			}},
+		{"tools and tool choice", `{"model":"claude","max_tokens":64,
+			"tools":[{"name":"get_weather","description":"weather","input_schema":{"type":"object"}}],
+			"tool_choice":{"type":"tool","name":"get_weather","disable_parallel_tool_use":true},
+			"messages":[{"role":"user","content":"hi"}]}`, nil,
+			func(t *testing.T, req openai.ChatCompletionRequest, _ http.Header) {
+				if len(req.Tools) != 1 || req.Tools[0].Function.Name != "get_weather" || string(req.Tools[0].Function.Parameters) != `{"type":"object"}` {
+					t.Errorf("unexpected tools: %+v", req.Tools)
+				}
+				if choice := openai.ParseToolChoice(req.ToolChoice); choice.Mode != "function" || choice.Function != "get_weather" {
+					t.Errorf("unexpected tool choice: %s", req.ToolChoice)
+				}
+				if req.ParallelToolCalls == nil || *req.ParallelToolCalls {
+					t.Errorf("parallel tool calls not disabled")
+				}
+			}},
+		{"x-api-key", `{"model":"claude","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`,
+			http.Header{"X-Api-Key": {"sk-ant"}, "Anthropic-Version": {"2023-06-01"}},
+			func(t *testing.T, _ openai.ChatCompletionRequest, header http.Header) {
+				if header.Get("Authorization") != "Bearer sk-ant" || header.Get("X-Api-Key") != "" || header.Get("Anthropic-Version") != "" {
+					t.Errorf("unexpected upstream headers: %v", header)
+				}
+			}},
+	}
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	url := newFrontendTestProxy(t, f)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := postJSON(t, url+"/v1/messages", tt.body, tt.header); status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			req, header := f.last()
+			tt.check(t, req, header)
+		})
+	}
+}
+
+func TestAnthropicFrontendResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  string
+		check  func(t *testing.T, resp anthropicResponse, body []byte)
+	}{
+		{"text", http.StatusOK, chatHello, func(t *testing.T, resp anthropicResponse, _ []byte) {
+			if resp.Type != "message" || resp.ID != "msg_chatcmpl-1" || resp.Model != "claude" ||
+				len(resp.Content) != 1 || resp.Content[0].Text != "hello" || *resp.StopReason != "end_turn" {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+			if resp.Usage.InputTokens != 3 || resp.Usage.OutputTokens != 1 {
+				t.Errorf("unexpected usage: %+v", resp.Usage)
+			}
+		}},
+		{"tool calls", http.StatusOK, `{"id":"c2","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":null,
+			"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},
+			{"id":"call_2","type":"function","function":{"name":"noop","arguments":"not json"}}]},"finish_reason":"tool_calls"}]}`,
+			func(t *testing.T, resp anthropicResponse, _ []byte) {
+				if len(resp.Content) != 2 || *resp.StopReason != "tool_use" {
+					t.Fatalf("unexpected response: %+v", resp)
+				}
+				if b := resp.Content[0]; b.Type != "tool_use" || b.ID != "call_1" || b.Name != "get_weather" || string(b.Input) != `{"city":"Paris"}` {
+					t.Errorf("unexpected tool_use: %+v", b)
+				}
+				// 无法解析的参数转换为空对象
+				if b := resp.Content[1]; string(b.Input) != "{}" {
+					t.Errorf("invalid arguments not replaced: %s", b.Input)
+				}
+			}},
+		{"max tokens", http.StatusOK, `{"id":"c3","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"he"},"finish_reason":"length"}]}`,
+			func(t *testing.T, resp anthropicResponse, _ []byte) {
+				if *resp.StopReason != "max_tokens" {
+					t.Errorf("stop reason %q", *resp.StopReason)
+				}
+			}},
+		{"upstream error", http.StatusTooManyRequests, `{"error":{"message":"slow down","type":"rate_limit_error"}}`,
+			func(t *testing.T, _ anthropicResponse, body []byte) {
+				var e anthropicError
+				decodeJSON(t, body, &e)
+				if e.Type != "error" || e.Error.Type != "rate_limit_error" || e.Error.Message != "slow down" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+		{"server error", http.StatusBadGateway, `bad gateway`,
+			func(t *testing.T, _ anthropicResponse, body []byte) {
+				var e anthropicError
+				decodeJSON(t, body, &e)
+				if e.Error.Type != "api_error" || e.Error.Message != "bad gateway" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := newFrontendTestProxy(t, newFakeChat(t, replyJSON(tt.status, tt.reply)))
+			status, body := postJSON(t, url+"/v1/messages", `{"model":"claude","max_tokens":64,"messages":[{"role":"user","content":"hi"}]}`, nil)
+			if status != tt.status {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			var resp anthropicResponse
+			if status == http.StatusOK {
+				decodeJSON(t, body, &resp)
+			}
+			tt.check(t, resp, body)
+		})
+	}
+}
+
+func TestAnthropicFrontendStream(t *testing.T) {
+	toolChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\""}}]},"finish_reason":null}]}`
+	argsChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`
+	usageChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8}}`
+
+	tests := []struct {
+		name   string
+		chunks []string
+		events []string // 依次出现的事件类型
+		stop   string
+	}{
+		{"text", []string{chunkHel, chunkLo, usageChunk},
+			[]string{"message_start", "content_block_start", "content_block_delta", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			"end_turn"},
+		{"text then tool", []string{chunkHel, toolChunk, argsChunk, usageChunk},
+			[]string{"message_start", "content_block_start", "content_block_delta", "content_block_stop",
+				"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop", "message_delta", "message_stop"},
+			"tool_use"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeChat(t, replyStream(tt.chunks...))
+			url := newFrontendTestProxy(t, f)
+			status, body := postJSON(t, url+"/v1/messages",
+				`{"model":"claude","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if req, _ := f.last(); req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+				t.Errorf("usage not requested: %+v", req.StreamOptions)
+			}
+
+			events := readEvents(body)
+			var types []string
+			var text, args strings.Builder
+			var last anthropicStreamEvent
+			for _, ev := range events {
+				types = append(types, ev.Event)
+				var se anthropicStreamEvent
+				decodeJSON(t, ev.Data, &se)
+				if se.Type != ev.Event {
+					t.Errorf("event %s carries type %s", ev.Event, se.Type)
+				}
+				if se.Delta != nil {
+					text.WriteString(se.Delta.Text)
+					args.WriteString(se.Delta.PartialJSON)
+				}
+				if ev.Event == "message_delta" {
+					last = se
+				}
+			}
+			if strings.Join(types, ",") != strings.Join(tt.events, ",") {
+				t.Errorf("events %v, want %v", types, tt.events)
+			}
+			if !strings.HasPrefix(text.String(), "hel") {
+				t.Errorf("text %q", text.String())
+			}
+			if tt.stop == "tool_use" && !json.Valid([]byte(args.String())) {
+				t.Errorf("tool input %q", args.String())
+			}
+			if last.Delta == nil || *last.Delta.StopReason != tt.stop || last.Usage == nil || last.Usage.OutputTokens != 5 {
+				t.Errorf("unexpected message_delta: %+v", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	"github.com/gin-gonic/gin"
+)
+
+// completionRequest 旧版 /v1/completions 请求
+type completionRequest struct {
+	Model            string                `json:"model"`
+	Prompt           completionPrompt      `json:"prompt"`
+	Suffix           string                `json:"suffix,omitempty"`
+	MaxTokens        *int                  `json:"max_tokens,omitempty"`
+	Temperature      *float64              `json:"temperature,omitempty"`
+	TopP             *float64              `json:"top_p,omitempty"`
+	N                *int                  `json:"n,omitempty"`
+	Stream           bool                  `json:"stream,omitempty"`
+	StreamOptions    *openai.StreamOptions `json:"stream_options,omitempty"`
+	Logprobs         *int                  `json:"logprobs,omitempty"`
+	Echo             bool                  `json:"echo,omitempty"`
+	Stop             openai.StopSequences  `json:"stop,omitempty"`
+	PresencePenalty  *float64              `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64              `json:"frequency_penalty,omitempty"`
+	Seed             *int64                `json:"seed,omitempty"`
+	User             string                `json:"user,omitempty"`
+}
+
+// completionPrompt prompt 字段，兼容字符串和字符串数组，不支持 token 数组
+type completionPrompt []string
+
+func (p *completionPrompt) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = completionPrompt{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return errors.New("prompt must be a string or an array of strings, token arrays are not supported")
+	}
+	*p = multi
+	return nil
+}
+
+// completionResponse text_completion 响应，流式响应的分片也是这个结构
+type completionResponse struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+	Usage   *openai.Usage      `json:"usage,omitempty"`
+}
+
+// completionChoice 补全结果
+type completionChoice struct {
+	Text         string              `json:"text"`
+	Index        int                 `json:"index"`
+	Logprobs     *completionLogprobs `json:"logprobs"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+// completionLogprobs 旧版格式的 token 概率信息
+type completionLogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+	TextOffset    []int                `json:"text_offset"`
+}
+
+const (
+	// completionInstruction 没有 suffix 时让 chat 模型续写 prompt
+	completionInstruction = "You are a text completion engine. Continue the text provided by the user. " +
+		"Output only the continuation, without repeating the given text or adding any explanation."
+	// fillInMiddleInstruction 有 suffix 时让 chat 模型补全 prefix 和 suffix 之间的内容
+	fillInMiddleInstruction = "You are a fill-in-the-middle completion engine. The user provides a prefix inside <prefix></prefix> " +
+		"and a suffix inside <suffix></suffix>. Output only the text that belongs between the prefix and the suffix, " +
+		"without repeating either of them, without tags and without any explanation."
+)
+
+// completionsFrontend 接收旧版 /v1/completions 请求，转换为 chat/completions 转发到上游
+type completionsFrontend struct{}
+
+func (completionsFrontend) prepare(req *http.Request, body []byte) ([]byte, responseTranslator, error) {
+	var creq completionRequest
+	if err := json.Unmarshal(body, &creq); err != nil {
+		return nil, nil, fmt.Errorf("invalid completions request: %w", err)
+	}
+	if len(creq.Prompt) > 1 {
+		return nil, nil, errors.New("multiple prompts in one request are not supported")
+	}
+	prompt := ""
+	if len(creq.Prompt) == 1 {
+		prompt = creq.Prompt[0]
+	}
+
+	chatReq := openai.ChatCompletionRequest{
+		Model:            creq.Model,
+		Stream:           creq.Stream,
+		StreamOptions:    creq.StreamOptions,
+		Temperature:      creq.Temperature,
+		TopP:             creq.TopP,
+		MaxTokens:        creq.MaxTokens,
+		Stop:             creq.Stop,
+		N:                creq.N,
+		PresencePenalty:  creq.PresencePenalty,
+		FrequencyPenalty: creq.FrequencyPenalty,
+		Seed:             creq.Seed,
+		User:             creq.User,
+	}
+	if creq.Logprobs != nil {
+		chatReq.Logprobs = true
+		chatReq.TopLogprobs = creq.Logprobs
+	}
+
+	if creq.Suffix != "" {
+		chatReq.Messages = []openai.Message{
+			{Role: "system", Content: fillInMiddleInstruction},
+			{Role: "user", Content: "<prefix>" + prompt + "</prefix><suffix>" + creq.Suffix + "</suffix>"},
+		}
+	} else {
+		chatReq.Messages = []openai.Message{
+			{Role: "system", Content: completionInstruction},
+			{Role: "user", Content: prompt},
+		}
+	}
+
+	chatBody, err := json.Marshal(chatReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := &completionTranslator{
+		model:    creq.Model,
+		logprobs: creq.Logprobs != nil,
+		offsets:  make(map[int]int),
+	}
+	if creq.Echo {
+		t.echo = prompt
+	}
+	return chatBody, t, nil
+}
+
+func (completionsFrontend) writeError(c *gin.Context, status int, err error) {
+	c.JSON(status, openai.NewErrorResponse(status, err.Error(), nil))
+}
+
+// completionTranslator 把 chat/completions 响应转换为 text_completion 响应
+type completionTranslator struct {
+	model    string
+	echo     string // echo 时需要放在补全结果前面的 prompt
+	logprobs bool
+
+	// 流式转换状态
+	id      string
+	created int64
+	offsets map[int]int // 每个 choice 已输出文本的长度，用于计算 text_offset
+	done    bool
+}
+
+func (t *completionTranslator) translateResponse(status int, body []byte) []byte {
+	if status >= http.StatusBadRequest {
+		errType, message := errorMessage(status, body)
+		errResp := openai.NewErrorResponse(status, message, nil)
+		errResp.Error.Type = errType
+		out, _ := json.Marshal(errResp)
+		return out
+	}
+
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+
+	out := completionResponse{
+		ID:      completionID(resp.ID),
+		Object:  "text_completion",
+		Created: resp.Created,
+		Model:   resp.Model,
+		Choices: make([]completionChoice, 0, len(resp.Choices)),
+		Usage:   resp.Usage,
+	}
+	if out.Model == "" {
+		out.Model = t.model
+	}
+	for _, choice := range resp.Choices {
+		finishReason := completionFinishReason(choice.FinishReason)
+		out.Choices = append(out.Choices, completionChoice{
+			Text:         t.echo + choice.Message.Content,
+			Index:        choice.Index,
+			Logprobs:     t.convertLogprobs(choice.Logprobs, len(t.echo)),
+			FinishReason: &finishReason,
+		})
+	}
+
+	data, _ := json.Marshal(out)
+	return data
+}
+
+func (t *completionTranslator) translateChunk(chunk *openai.ChatCompletionChunk) []sseEvent {
+	if t.id == "" {
+		t.id = completionID(chunk.ID)
+		t.created = chunk.Created
+		if t.created == 0 {
+			t.created = time.Now().Unix()
+		}
+	}
+	model := chunk.Model
+	if model == "" {
+		model = t.model
+	}
+
+	choices := make([]completionChoice, 0, len(chunk.Choices))
+	for _, choice := range chunk.Choices {
+		text := choice.Delta.Content
+		offset, ok := t.offsets[choice.Index]
+		if !ok && t.echo != "" {
+			// echo 的 prompt 作为每个 choice 的第一段文本输出
+			text = t.echo + text
+			offset = len(t.echo)
+		}
+		var finishReason *string
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			finishReason = openai.StringPtr(completionFinishReason(*choice.FinishReason))
+		}
+		t.offsets[choice.Index] = offset + len(choice.Delta.Content)
+		// 工具调用等没有文本的增量不输出
+		if text == "" && finishReason == nil {
+			continue
+		}
+		choices = append(choices, completionChoice{
+			Text:         text,
+			Index:        choice.Index,
+			Logprobs:     t.convertLogprobs(choice.Logprobs, offset),
+			FinishReason: finishReason,
+		})
+	}
+	if len(choices) == 0 && chunk.Usage == nil {
+		return nil
+	}
+	sort.Slice(choices, func(i, j int) bool { return choices[i].Index < choices[j].Index })
+	return []sseEvent{t.chunkEvent(model, choices, chunk.Usage)}
+}
+
+func (t *completionTranslator) finishStream() []sseEvent {
+	if t.done {
+		return nil
+	}
+	t.done = true
+	return []sseEvent{{Data: []byte(sseDone)}}
+}
+
+func (t *completionTranslator) chunkEvent(model string, choices []completionChoice, usage *openai.Usage) sseEvent {
+	return newJSONEvent("", completionResponse{
+		ID:      t.id,
+		Object:  "text_completion",
+		Created: t.created,
+		Model:   model,
+		Choices: choices,
+		Usage:   usage,
+	})
+}
+
+// convertLogprobs 把 chat 格式的 logprobs 转换为旧版格式，offset 是第一个 token 在补全文本中的位置
+func (t *completionTranslator) convertLogprobs(lp *openai.Logprobs, offset int) *completionLogprobs {
+	if !t.logprobs || lp == nil {
+		return nil
+	}
+	out := &completionLogprobs{
+		Tokens:        make([]string, 0, len(lp.Content)),
+		TokenLogprobs: make([]float64, 0, len(lp.Content)),
+		TopLogprobs:   make([]map[string]float64, 0, len(lp.Content)),
+		TextOffset:    make([]int, 0, len(lp.Content)),
+	}
+	for _, token := range lp.Content {
+		top := make(map[string]float64, len(token.TopLogprobs))
+		for _, candidate := range token.TopLogprobs {
+			top[candidate.Token] = candidate.Logprob
+		}
+		out.Tokens = append(out.Tokens, token.Token)
+		out.TokenLogprobs = append(out.TokenLogprobs, token.Logprob)
+		out.TopLogprobs = append(out.TopLogprobs, top)
+		out.TextOffset = append(out.TextOffset, offset)
+		offset += len(token.Token)
+	}
+	return out
+}
+
+// completionID 把 chatcmpl- 前缀的 ID 转换为 cmpl- 前缀
+func completionID(chatID string) string {
+	if chatID == "" {
+		return newID("cmpl")
+	}
+	return "cmpl-" + strings.TrimPrefix(chatID, "chatcmpl-")
+}
+
+// completionFinishReason 旧版接口没有 tool_calls，其它原因保持不变
+func completionFinishReason(reason string) string {
+	switch reason {
+	case "", openai.FinishReasonToolCalls:
+		return openai.FinishReasonStop
+	}
+	return reason
+}
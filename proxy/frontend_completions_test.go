@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+func TestCompletionsFrontendRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, req openai.ChatCompletionRequest)
+	}{
+		{"prompt", `{"model":"gpt-4o","prompt":"Once upon","max_tokens":16,"temperature":0.5,"stop":["\n"],"n":2,"user":"u1"}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				if req.MaxTokens == nil || *req.MaxTokens != 16 || req.Temperature == nil || *req.Temperature != 0.5 ||
+					len(req.Stop) != 1 || req.N == nil || *req.N != 2 || req.User != "u1" {
+					t.Errorf("unexpected request: %+v", req)
+				}
+				if len(req.Messages) != 2 || req.Messages[0].Content != completionInstruction || req.Messages[1].Content != "Once upon" {
+					t.Errorf("unexpected messages: %+v", req.Messages)
+				}
+			}},
+		{"prompt array", `{"model":"gpt-4o","prompt":["Once upon"]}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				if req.Messages[1].Content != "Once upon" {
+					t.Errorf("unexpected messages: %+v", req.Messages)
+				}
+			}},
+		{"suffix", `{"model":"gpt-4o","prompt":"func add(a, b int) int {","suffix":"}"}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				if req.Messages[0].Content != fillInMiddleInstruction ||
+					req.Messages[1].Content != "<prefix>func add(a, b int) int {</prefix><suffix>}</suffix>" {
+					t.Errorf("unexpected messages: %+v", req.Messages)
+				}
+			}},
+		{"logprobs", `{"model":"gpt-4o","prompt":"hi","logprobs":3}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				if !req.Logprobs || req.TopLogprobs == nil || *req.TopLogprobs != 3 {
+					t.Errorf("logprobs not requested: %+v", req)
+				}
+			}},
+	}
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	url := newFrontendTestProxy(t, f)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := postJSON(t, url+"/v1/completions", tt.body, nil); status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			req, _ := f.last()
+			tt.check(t, req)
+		})
+	}
+
+	// 多个 prompt 和 token 数组无法转换
+	for _, body := range []string{`{"model":"gpt-4o","prompt":["a","b"]}`, `{"model":"gpt-4o","prompt":[1,2,3]}`} {
+		status, resp := postJSON(t, url+"/v1/completions", body, nil)
+		var errResp openai.ErrorResponse
+		decodeJSON(t, resp, &errResp)
+		if status != http.StatusBadRequest || errResp.Error.Type != "invalid_request_error" {
+			t.Errorf("%s: unexpected response %d: %s", body, status, resp)
+		}
+	}
+}
+
+func TestCompletionsFrontendResponse(t *testing.T) {
+	logprobsReply := `{"id":"chatcmpl-2","object":"chat.completion","created":1,"model":"gpt-4o","choices":[{"index":0,
+		"message":{"role":"assistant","content":"a time"},"finish_reason":"stop",
+		"logprobs":{"content":[{"token":"a","logprob":-0.1,"top_logprobs":[{"token":"a","logprob":-0.1},{"token":"the","logprob":-2}]},
+		{"token":" time","logprob":-0.2,"top_logprobs":[{"token":" time","logprob":-0.2}]}]}}]}`
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		reply  string
+		check  func(t *testing.T, resp completionResponse, body []byte)
+	}{
+		{"text", `{"model":"gpt-4o","prompt":"hi"}`, http.StatusOK, chatHello, func(t *testing.T, resp completionResponse, _ []byte) {
+			if resp.ID != "cmpl-1" || resp.Object != "text_completion" || resp.Model != "gpt-4o" || len(resp.Choices) != 1 {
+				t.Fatalf("unexpected response: %+v", resp)
+			}
+			if c := resp.Choices[0]; c.Text != "hello" || c.Logprobs != nil || *c.FinishReason != "stop" {
+				t.Errorf("unexpected choice: %+v", c)
+			}
+			if resp.Usage == nil || resp.Usage.TotalTokens != 4 {
+				t.Errorf("unexpected usage: %+v", resp.Usage)
+			}
+		}},
+		{"echo and logprobs", `{"model":"gpt-4o","prompt":"Once upon ","echo":true,"logprobs":2}`, http.StatusOK, logprobsReply,
+			func(t *testing.T, resp completionResponse, _ []byte) {
+				c := resp.Choices[0]
+				if c.Text != "Once upon a time" || c.Logprobs == nil {
+					t.Fatalf("unexpected choice: %+v", c)
+				}
+				// text_offset 从 echo 的 prompt 之后开始
+				lp := c.Logprobs
+				if strings.Join(lp.Tokens, "|") != "a| time" || lp.TokenLogprobs[1] != -0.2 || lp.TextOffset[0] != 10 || lp.TextOffset[1] != 11 ||
+					lp.TopLogprobs[0]["the"] != -2 {
+					t.Errorf("unexpected logprobs: %+v", lp)
+				}
+			}},
+		{"tool calls finish as stop", `{"model":"gpt-4o","prompt":"hi"}`, http.StatusOK,
+			`{"id":"chatcmpl-3","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":""},"finish_reason":"tool_calls"}]}`,
+			func(t *testing.T, resp completionResponse, _ []byte) {
+				if *resp.Choices[0].FinishReason != "stop" {
+					t.Errorf("unexpected finish reason: %s", *resp.Choices[0].FinishReason)
+				}
+			}},
+		{"upstream error", `{"model":"gpt-4o","prompt":"hi"}`, http.StatusNotFound, `{"error":{"message":"no such model"}}`,
+			func(t *testing.T, _ completionResponse, body []byte) {
+				var e openai.ErrorResponse
+				decodeJSON(t, body, &e)
+				if e.Error.Type != "not_found_error" || e.Error.Message != "no such model" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := newFrontendTestProxy(t, newFakeChat(t, replyJSON(tt.status, tt.reply)))
+			status, body := postJSON(t, url+"/v1/completions", tt.body, nil)
+			if status != tt.status {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			var resp completionResponse
+			if status == http.StatusOK {
+				decodeJSON(t, body, &resp)
+			}
+			tt.check(t, resp, body)
+		})
+	}
+}
+
+func TestCompletionsFrontendStream(t *testing.T) {
+	toolChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},"finish_reason":null}]}`
+	usageChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`
+
+	tests := []struct {
+		name   string
+		body   string
+		chunks []string
+		texts  []string // 每个分片的文本
+		usage  bool
+	}{
+		{"text", `{"model":"gpt-4o","stream":true,"prompt":"hi"}`, []string{chunkHel, toolChunk, chunkLo}, []string{"hel", "lo"}, false},
+		{"echo and usage", `{"model":"gpt-4o","stream":true,"prompt":"say ","echo":true,"stream_options":{"include_usage":true}}`,
+			[]string{chunkHel, chunkLo, usageChunk}, []string{"say hel", "lo", ""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeChat(t, replyStream(tt.chunks...))
+			url := newFrontendTestProxy(t, f)
+			status, body := postJSON(t, url+"/v1/completions", tt.body, nil)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+
+			events := sseData(body)
+			if len(events) == 0 || events[len(events)-1] != sseDone {
+				t.Fatalf("unexpected events: %q", events)
+			}
+			var texts []string
+			var last completionResponse
+			for _, data := range events[:len(events)-1] {
+				var chunk completionResponse
+				decodeJSON(t, []byte(data), &chunk)
+				if chunk.ID != "cmpl-1" || chunk.Object != "text_completion" {
+					t.Errorf("unexpected chunk: %s", data)
+				}
+				text := ""
+				for _, c := range chunk.Choices {
+					text += c.Text
+				}
+				texts = append(texts, text)
+				last = chunk
+			}
+			if strings.Join(texts, "|") != strings.Join(tt.texts, "|") {
+				t.Errorf("texts %q, want %q", texts, tt.texts)
+			}
+			if tt.usage != (last.Usage != nil) {
+				t.Errorf("unexpected usage: %+v", last.Usage)
+			}
+		})
+	}
+}
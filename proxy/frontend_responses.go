@@ -0,0 +1,726 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultResponseStoreSize 本地保存的 Responses API 响应数量上限
+const defaultResponseStoreSize = 1000
+
+// responsesRequest Responses API 请求
+type responsesRequest struct {
+	Model              string            `json:"model"`
+	Input              json.RawMessage   `json:"input"`
+	Instructions       string            `json:"instructions,omitempty"`
+	Tools              []responsesTool   `json:"tools,omitempty"`
+	ToolChoice         json.RawMessage   `json:"tool_choice,omitempty"`
+	PreviousResponseID string            `json:"previous_response_id,omitempty"`
+	Stream             bool              `json:"stream,omitempty"`
+	Store              *bool             `json:"store,omitempty"`
+	Temperature        *float64          `json:"temperature,omitempty"`
+	TopP               *float64          `json:"top_p,omitempty"`
+	MaxOutputTokens    *int              `json:"max_output_tokens,omitempty"`
+	ParallelToolCalls  *bool             `json:"parallel_tool_calls,omitempty"`
+	User               string            `json:"user,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Text               *responsesText    `json:"text,omitempty"`
+}
+
+// responsesTool 工具定义，仅支持 function 类型
+type responsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+// responsesText 文本输出格式
+type responsesText struct {
+	Format *struct {
+		Type   string          `json:"type"`
+		Name   string          `json:"name,omitempty"`
+		Schema json.RawMessage `json:"schema,omitempty"`
+		Strict *bool           `json:"strict,omitempty"`
+	} `json:"format,omitempty"`
+}
+
+// responsesInputItem 输入项，包括消息、函数调用和函数调用结果
+type responsesInputItem struct {
+	Type      string          `json:"type,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	CallID    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    string          `json:"output,omitempty"`
+}
+
+// responsesContentPart 输入消息的内容片段
+type responsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// responsesResponse Responses API 响应对象
+type responsesResponse struct {
+	ID                 string            `json:"id"`
+	Object             string            `json:"object"`
+	CreatedAt          int64             `json:"created_at"`
+	Status             string            `json:"status"`
+	Model              string            `json:"model"`
+	Output             []responsesItem   `json:"output"`
+	Usage              *responsesUsage   `json:"usage"`
+	Error              *openai.Error     `json:"error"`
+	IncompleteDetails  *incompleteDetail `json:"incomplete_details"`
+	Instructions       *string           `json:"instructions"`
+	PreviousResponseID *string           `json:"previous_response_id"`
+	Tools              []responsesTool   `json:"tools"`
+	ToolChoice         json.RawMessage   `json:"tool_choice"`
+	Temperature        *float64          `json:"temperature"`
+	TopP               *float64          `json:"top_p"`
+	MaxOutputTokens    *int              `json:"max_output_tokens"`
+	ParallelToolCalls  bool              `json:"parallel_tool_calls"`
+	Store              bool              `json:"store"`
+	Metadata           map[string]string `json:"metadata"`
+}
+
+// incompleteDetail 响应未完成的原因
+type incompleteDetail struct {
+	Reason string `json:"reason"`
+}
+
+// responsesItem 输出项，message 或 function_call
+type responsesItem struct {
+	Type      string                `json:"type"`
+	ID        string                `json:"id"`
+	Status    string                `json:"status"`
+	Role      string                `json:"role,omitempty"`
+	Content   []responsesOutputPart `json:"content,omitempty"`
+	CallID    string                `json:"call_id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Arguments *string               `json:"arguments,omitempty"`
+}
+
+// responsesOutputPart 输出消息的内容片段
+type responsesOutputPart struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+}
+
+// responsesUsage token 用量
+type responsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	TotalTokens        int `json:"total_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+// storedResponse 本地保存的响应，messages 为截止到该响应的完整对话（不含 instructions）
+type storedResponse struct {
+	response *responsesResponse
+	messages []openai.Message
+	owner    string // 创建者 key 的哈希，只有同一个 key 可以读取、删除和串联
+}
+
+// responseStore 本地保存的 Responses API 响应，用于 previous_response_id 串联对话
+type responseStore struct {
+	mu    sync.RWMutex
+	items map[string]*storedResponse
+	order []string
+	limit int
+}
+
+func newResponseStore(limit int) *responseStore {
+	if limit <= 0 {
+		limit = defaultResponseStoreSize
+	}
+	return &responseStore{items: make(map[string]*storedResponse), limit: limit}
+}
+
+// get 读取响应，owner 与创建者不同时按不存在处理
+func (s *responseStore) get(id, owner string) (*storedResponse, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, ok := s.items[id]
+	if !ok || item.owner != owner {
+		return nil, false
+	}
+	return item, true
+}
+
+// put 保存响应，超过上限时淘汰最早的响应
+func (s *responseStore) put(item *storedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := item.response.ID
+	if _, ok := s.items[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.items[id] = item
+	for len(s.order) > s.limit {
+		delete(s.items, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *responseStore) delete(id, owner string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[id]; !ok || item.owner != owner {
+		return false
+	}
+	delete(s.items, id)
+	for i, v := range s.order {
+		if v == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// handleStoredResponse 处理 GET / DELETE /v1/responses/{id}
+func (p *Proxy) handleStoredResponse(c *gin.Context) {
+	id := strings.TrimPrefix(c.Request.URL.Path, "/v1/responses/")
+	owner := keyOwner(c.Request.Header)
+	switch c.Request.Method {
+	case http.MethodGet:
+		item, ok := p.responses.get(id, owner)
+		if !ok {
+			c.JSON(http.StatusNotFound, openai.NewErrorResponse(http.StatusNotFound, fmt.Sprintf("Response with id '%s' not found.", id), nil))
+			return
+		}
+		c.JSON(http.StatusOK, item.response)
+	case http.MethodDelete:
+		if !p.responses.delete(id, owner) {
+			c.JSON(http.StatusNotFound, openai.NewErrorResponse(http.StatusNotFound, fmt.Sprintf("Response with id '%s' not found.", id), nil))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "object": "response.deleted", "deleted": true})
+	default:
+		c.Status(http.StatusMethodNotAllowed)
+	}
+}
+
+// responsesFrontend 接收 Responses API 请求，转换为 chat/completions 转发到上游
+type responsesFrontend struct {
+	store *responseStore
+}
+
+func (f responsesFrontend) prepare(req *http.Request, body []byte) ([]byte, responseTranslator, error) {
+	var rreq responsesRequest
+	if err := json.Unmarshal(body, &rreq); err != nil {
+		return nil, nil, fmt.Errorf("invalid responses request: %w", err)
+	}
+
+	// 之前的对话来自本地保存的响应，instructions 不会沿用；只能串联同一个 key 创建的响应
+	owner := keyOwner(req.Header)
+	var history []openai.Message
+	if rreq.PreviousResponseID != "" {
+		prev, ok := f.store.get(rreq.PreviousResponseID, owner)
+		if !ok {
+			return nil, nil, fmt.Errorf("previous response with id '%s' not found", rreq.PreviousResponseID)
+		}
+		history = append(history, prev.messages...)
+	}
+	input, err := responsesInputMessages(rreq.Input)
+	if err != nil {
+		return nil, nil, err
+	}
+	history = append(history, input...)
+
+	creq := openai.ChatCompletionRequest{
+		Model:             rreq.Model,
+		Stream:            rreq.Stream,
+		Temperature:       rreq.Temperature,
+		TopP:              rreq.TopP,
+		MaxTokens:         rreq.MaxOutputTokens,
+		ParallelToolCalls: rreq.ParallelToolCalls,
+		User:              rreq.User,
+	}
+	if rreq.Stream {
+		creq.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+	if rreq.Instructions != "" {
+		creq.Messages = append(creq.Messages, openai.Message{Role: "system", Content: rreq.Instructions})
+	}
+	creq.Messages = append(creq.Messages, history...)
+
+	for _, tool := range rreq.Tools {
+		if tool.Type != "function" {
+			return nil, nil, fmt.Errorf("tool type '%s' is not supported", tool.Type)
+		}
+		creq.Tools = append(creq.Tools, openai.Tool{
+			Type: "function",
+			Function: openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+				Strict:      tool.Strict,
+			},
+		})
+	}
+	creq.ToolChoice = responsesToolChoice(rreq.ToolChoice)
+
+	if rreq.Text != nil && rreq.Text.Format != nil {
+		switch format := rreq.Text.Format; format.Type {
+		case "json_object":
+			creq.ResponseFormat = json.RawMessage(`{"type":"json_object"}`)
+		case "json_schema":
+			creq.ResponseFormat, _ = json.Marshal(map[string]interface{}{
+				"type": "json_schema",
+				"json_schema": map[string]interface{}{
+					"name":   format.Name,
+					"schema": format.Schema,
+					"strict": format.Strict,
+				},
+			})
+		}
+	}
+
+	chatBody, err := json.Marshal(creq)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp := &responsesResponse{
+		ID:                newID("resp"),
+		Object:            "response",
+		CreatedAt:         time.Now().Unix(),
+		Status:            "in_progress",
+		Model:             rreq.Model,
+		Output:            []responsesItem{},
+		Tools:             rreq.Tools,
+		ToolChoice:        rreq.ToolChoice,
+		Temperature:       rreq.Temperature,
+		TopP:              rreq.TopP,
+		MaxOutputTokens:   rreq.MaxOutputTokens,
+		ParallelToolCalls: rreq.ParallelToolCalls == nil || *rreq.ParallelToolCalls,
+		Store:             rreq.Store == nil || *rreq.Store,
+		Metadata:          rreq.Metadata,
+	}
+	if resp.Tools == nil {
+		resp.Tools = []responsesTool{}
+	}
+	if len(resp.ToolChoice) == 0 {
+		resp.ToolChoice = json.RawMessage(`"auto"`)
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+	if rreq.Instructions != "" {
+		resp.Instructions = &rreq.Instructions
+	}
+	if rreq.PreviousResponseID != "" {
+		resp.PreviousResponseID = &rreq.PreviousResponseID
+	}
+
+	return chatBody, &responsesTranslator{store: f.store, owner: owner, response: resp, history: history}, nil
+}
+
+func (f responsesFrontend) writeError(c *gin.Context, status int, err error) {
+	c.JSON(status, openai.NewErrorResponse(status, err.Error(), nil))
+}
+
+// responsesInputMessages 把 input（字符串或输入项数组）转换为 chat 消息
+func responsesInputMessages(raw json.RawMessage) ([]openai.Message, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []openai.Message{{Role: "user", Content: text}}, nil
+	}
+
+	var items []responsesInputItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+
+	var messages []openai.Message
+	for _, item := range items {
+		switch item.Type {
+		case "function_call":
+			call := openai.ToolCall{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: openai.FunctionCall{Name: item.Name, Arguments: item.Arguments},
+			}
+			// 连续的函数调用合并到同一条 assistant 消息中
+			if n := len(messages); n > 0 && messages[n-1].Role == "assistant" && len(messages[n-1].ToolCalls) > 0 {
+				messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, call)
+			} else {
+				messages = append(messages, openai.Message{Role: "assistant", ToolCalls: []openai.ToolCall{call}})
+			}
+		case "function_call_output":
+			messages = append(messages, openai.Message{Role: "tool", ToolCallID: item.CallID, Content: item.Output})
+		case "", "message":
+			msg, err := responsesMessage(item)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// responsesMessage 转换消息类型的输入项
+func responsesMessage(item responsesInputItem) (openai.Message, error) {
+	msg := openai.Message{Role: item.Role}
+	if msg.Role == "developer" {
+		msg.Role = "system"
+	}
+
+	var text string
+	if err := json.Unmarshal(item.Content, &text); err == nil {
+		msg.Content = text
+		return msg, nil
+	}
+
+	var parts []responsesContentPart
+	if err := json.Unmarshal(item.Content, &parts); err != nil {
+		return msg, fmt.Errorf("invalid message content: %w", err)
+	}
+	hasImage := false
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "text":
+			msg.Content += part.Text
+			msg.Parts = append(msg.Parts, openai.ContentPart{Type: "text", Text: part.Text})
+		case "input_image":
+			if part.ImageURL == "" {
+				return msg, fmt.Errorf("input_image without image_url is not supported")
+			}
+			hasImage = true
+			msg.Parts = append(msg.Parts, openai.ContentPart{
+				Type:     "image_url",
+				ImageURL: &openai.ImageURL{URL: part.ImageURL, Detail: part.Detail},
+			})
+		}
+	}
+	if !hasImage {
+		msg.Parts = nil
+	}
+	return msg, nil
+}
+
+// responsesToolChoice 转换 tool_choice，函数形式为 {"type":"function","name":"..."}
+func responsesToolChoice(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	var named struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &named); err == nil && named.Type == "function" {
+		return openai.MarshalToolChoice(openai.ToolChoice{Mode: "function", Function: named.Name})
+	}
+	return raw
+}
+
+// responsesTranslator 把 chat/completions 响应转换为 Responses API 响应，完成后保存到本地
+type responsesTranslator struct {
+	store    *responseStore
+	owner    string
+	response *responsesResponse
+	history  []openai.Message
+
+	// 流式转换状态
+	seq          int
+	started      bool
+	done         bool
+	text         *responsesItem
+	textIndex    int
+	tools        map[int]*responsesItem
+	toolIndex    map[int]int
+	toolArgs     map[int]*strings.Builder
+	finishReason string
+	usage        *openai.Usage
+}
+
+func (t *responsesTranslator) translateResponse(status int, body []byte) []byte {
+	if status >= http.StatusBadRequest {
+		errType, message := errorMessage(status, body)
+		errResp := openai.NewErrorResponse(status, message, nil)
+		errResp.Error.Type = errType
+		data, _ := json.Marshal(errResp)
+		return data
+	}
+
+	var resp openai.ChatCompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+
+	if len(resp.Choices) > 0 {
+		choice := resp.Choices[0]
+		if choice.Message.Content != "" {
+			t.response.Output = append(t.response.Output, responsesItem{
+				Type:    "message",
+				ID:      newID("msg"),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []responsesOutputPart{{Type: "output_text", Text: choice.Message.Content, Annotations: []interface{}{}}},
+			})
+		}
+		for _, tc := range choice.Message.ToolCalls {
+			args := tc.Function.Arguments
+			t.response.Output = append(t.response.Output, responsesItem{
+				Type:      "function_call",
+				ID:        newID("fc"),
+				Status:    "completed",
+				CallID:    tc.ID,
+				Name:      tc.Function.Name,
+				Arguments: &args,
+			})
+		}
+		t.finishReason = choice.FinishReason
+	}
+	t.usage = resp.Usage
+	t.complete()
+
+	data, _ := json.Marshal(t.response)
+	return data
+}
+
+// complete 设置响应的最终状态和用量，并保存到本地
+func (t *responsesTranslator) complete() {
+	t.response.Status = "completed"
+	if t.finishReason == openai.FinishReasonLength {
+		t.response.Status = "incomplete"
+		t.response.IncompleteDetails = &incompleteDetail{Reason: "max_output_tokens"}
+	} else if t.finishReason == openai.FinishReasonContentFilter {
+		t.response.Status = "incomplete"
+		t.response.IncompleteDetails = &incompleteDetail{Reason: "content_filter"}
+	}
+	if t.usage != nil {
+		t.response.Usage = &responsesUsage{
+			InputTokens:  t.usage.PromptTokens,
+			OutputTokens: t.usage.CompletionTokens,
+			TotalTokens:  t.usage.TotalTokens,
+		}
+	}
+
+	if !t.response.Store {
+		return
+	}
+	messages := append([]openai.Message(nil), t.history...)
+	assistant := openai.Message{Role: "assistant"}
+	for _, item := range t.response.Output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				assistant.Content += part.Text
+			}
+		case "function_call":
+			assistant.ToolCalls = append(assistant.ToolCalls, openai.ToolCall{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: openai.FunctionCall{Name: item.Name, Arguments: *item.Arguments},
+			})
+		}
+	}
+	messages = append(messages, assistant)
+	t.store.put(&storedResponse{response: t.response, messages: messages, owner: t.owner})
+}
+
+// event 创建带序号的流式事件
+func (t *responsesTranslator) event(eventType string, fields map[string]interface{}) sseEvent {
+	fields["type"] = eventType
+	fields["sequence_number"] = t.seq
+	t.seq++
+	return newJSONEvent(eventType, fields)
+}
+
+func (t *responsesTranslator) start() []sseEvent {
+	t.started = true
+	t.tools = make(map[int]*responsesItem)
+	t.toolIndex = make(map[int]int)
+	t.toolArgs = make(map[int]*strings.Builder)
+	return []sseEvent{
+		t.event("response.created", map[string]interface{}{"response": t.response}),
+		t.event("response.in_progress", map[string]interface{}{"response": t.response}),
+	}
+}
+
+func (t *responsesTranslator) translateChunk(chunk *openai.ChatCompletionChunk) []sseEvent {
+	var events []sseEvent
+	if !t.started {
+		events = append(events, t.start()...)
+	}
+	if chunk.Usage != nil {
+		t.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			if t.text == nil {
+				events = append(events, t.openText()...)
+			}
+			t.text.Content[0].Text += choice.Delta.Content
+			events = append(events, t.event("response.output_text.delta", map[string]interface{}{
+				"item_id":       t.text.ID,
+				"output_index":  t.textIndex,
+				"content_index": 0,
+				"delta":         choice.Delta.Content,
+			}))
+		}
+		for _, tc := range choice.Delta.ToolCalls {
+			index := 0
+			if tc.Index != nil {
+				index = *tc.Index
+			}
+			item, ok := t.tools[index]
+			if !ok {
+				// 函数调用开始前先结束文本输出
+				events = append(events, t.closeText()...)
+				empty := ""
+				item = &responsesItem{
+					Type:      "function_call",
+					ID:        newID("fc"),
+					Status:    "in_progress",
+					CallID:    tc.ID,
+					Name:      tc.Function.Name,
+					Arguments: &empty,
+				}
+				t.tools[index] = item
+				t.toolArgs[index] = &strings.Builder{}
+				t.toolIndex[index] = len(t.response.Output)
+				t.response.Output = append(t.response.Output, *item)
+				events = append(events, t.event("response.output_item.added", map[string]interface{}{
+					"output_index": t.toolIndex[index],
+					"item":         item,
+				}))
+			}
+			if tc.Function.Arguments != "" {
+				t.toolArgs[index].WriteString(tc.Function.Arguments)
+				events = append(events, t.event("response.function_call_arguments.delta", map[string]interface{}{
+					"item_id":      item.ID,
+					"output_index": t.toolIndex[index],
+					"delta":        tc.Function.Arguments,
+				}))
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			t.finishReason = *choice.FinishReason
+		}
+	}
+	return events
+}
+
+// openText 开始一个文本输出项
+func (t *responsesTranslator) openText() []sseEvent {
+	t.text = &responsesItem{
+		Type:    "message",
+		ID:      newID("msg"),
+		Status:  "in_progress",
+		Role:    "assistant",
+		Content: []responsesOutputPart{{Type: "output_text", Text: "", Annotations: []interface{}{}}},
+	}
+	t.textIndex = len(t.response.Output)
+	t.response.Output = append(t.response.Output, *t.text)
+
+	emptyItem := *t.text
+	emptyItem.Content = []responsesOutputPart{}
+	return []sseEvent{
+		t.event("response.output_item.added", map[string]interface{}{"output_index": t.textIndex, "item": emptyItem}),
+		t.event("response.content_part.added", map[string]interface{}{
+			"item_id":       t.text.ID,
+			"output_index":  t.textIndex,
+			"content_index": 0,
+			"part":          responsesOutputPart{Type: "output_text", Text: "", Annotations: []interface{}{}},
+		}),
+	}
+}
+
+// closeText 结束当前的文本输出项
+func (t *responsesTranslator) closeText() []sseEvent {
+	if t.text == nil {
+		return nil
+	}
+	item := t.text
+	t.text = nil
+	item.Status = "completed"
+	t.response.Output[t.textIndex] = *item
+	part := item.Content[0]
+	return []sseEvent{
+		t.event("response.output_text.done", map[string]interface{}{
+			"item_id":       item.ID,
+			"output_index":  t.textIndex,
+			"content_index": 0,
+			"text":          part.Text,
+		}),
+		t.event("response.content_part.done", map[string]interface{}{
+			"item_id":       item.ID,
+			"output_index":  t.textIndex,
+			"content_index": 0,
+			"part":          part,
+		}),
+		t.event("response.output_item.done", map[string]interface{}{"output_index": t.textIndex, "item": item}),
+	}
+}
+
+func (t *responsesTranslator) finishStream() []sseEvent {
+	if t.done {
+		return nil
+	}
+	t.done = true
+
+	var events []sseEvent
+	if !t.started {
+		events = append(events, t.start()...)
+	}
+	events = append(events, t.closeText()...)
+
+	indexes := make([]int, 0, len(t.tools))
+	for index := range t.tools {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		item := t.tools[index]
+		args := t.toolArgs[index].String()
+		item.Arguments = &args
+		item.Status = "completed"
+		outputIndex := t.toolIndex[index]
+		t.response.Output[outputIndex] = *item
+		events = append(events,
+			t.event("response.function_call_arguments.done", map[string]interface{}{
+				"item_id":      item.ID,
+				"output_index": outputIndex,
+				"arguments":    args,
+			}),
+			t.event("response.output_item.done", map[string]interface{}{"output_index": outputIndex, "item": item}),
+		)
+	}
+
+	t.complete()
+	eventType := "response.completed"
+	if t.response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	return append(events, t.event(eventType, map[string]interface{}{"response": t.response}))
+}
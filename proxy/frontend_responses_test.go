@@ -0,0 +1,337 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// doResponses 以 key 发送请求到 /v1/responses/{id}
+func doResponses(t *testing.T, method, url, key string) (int, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body json.RawMessage
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func TestResponsesFrontendRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, req openai.ChatCompletionRequest)
+	}{
+		{"string input and instructions", `{"model":"gpt-4o","instructions":"be brief","input":"hi","max_output_tokens":32,"user":"u1"}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				if req.Model != "gpt-4o" || req.MaxTokens == nil || *req.MaxTokens != 32 || req.User != "u1" {
+					t.Errorf("unexpected request: %+v", req)
+				}
+				if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[0].Content != "be brief" ||
+					req.Messages[1].Role != "user" || req.Messages[1].Content != "hi" {
+					t.Errorf("unexpected messages: %+v", req.Messages)
+				}
+			}},
+		{"function calls and outputs", `{"model":"gpt-4o","input":[
+			{"role":"developer","content":"no markdown"},
+			{"role":"user","content":[{"type":"input_text","text":"weather?"}]},
+			{"type":"function_call","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Paris\"}"},
+			{"type":"function_call","call_id":"call_2","name":"get_time","arguments":"{}"},
+			{"type":"function_call_output","call_id":"call_1","output":"sunny"}]}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				if len(req.Messages) != 4 || req.Messages[0].Role != "system" || req.Messages[1].Content != "weather?" {
+					t.Fatalf("unexpected messages: %+v", req.Messages)
+				}
+				// 连续的函数调用合并到同一条 assistant 消息中
+				if calls := req.Messages[2].ToolCalls; req.Messages[2].Role != "assistant" || len(calls) != 2 ||
+					calls[0].ID != "call_1" || calls[1].Function.Name != "get_time" {
+					t.Errorf("unexpected assistant message: %+v", req.Messages[2])
+				}
+				if tool := req.Messages[3]; tool.Role != "tool" || tool.ToolCallID != "call_1" || tool.Content != "sunny" {
+					t.Errorf("unexpected tool message: %+v", tool)
+				}
+			}},
+		{"image", `{"model":"gpt-4o","input":[{"role":"user","content":[{"type":"input_text","text":"what is this"},
+			{"type":"input_image","image_url":"https://example.com/a.png","detail":"low"}]}]}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				parts := req.Messages[0].Parts
+				if len(parts) != 2 || parts[1].ImageURL == nil || parts[1].ImageURL.URL != "https://example.com/a.png" || parts[1].ImageURL.Detail != "low" {
+					t.Errorf("unexpected parts: %+v", parts)
+				}
+			}},
+		{"tools and text format", `{"model":"gpt-4o","input":"hi","parallel_tool_calls":false,
+			"tools":[{"type":"function","name":"get_weather","parameters":{"type":"object"}}],
+			"tool_choice":{"type":"function","name":"get_weather"},
+			"text":{"format":{"type":"json_schema","name":"answer","schema":{"type":"object"}}}}`,
+			func(t *testing.T, req openai.ChatCompletionRequest) {
+				if len(req.Tools) != 1 || req.Tools[0].Function.Name != "get_weather" || string(req.Tools[0].Function.Parameters) != `{"type":"object"}` {
+					t.Errorf("unexpected tools: %+v", req.Tools)
+				}
+				if choice := openai.ParseToolChoice(req.ToolChoice); choice.Mode != "function" || choice.Function != "get_weather" {
+					t.Errorf("unexpected tool choice: %s", req.ToolChoice)
+				}
+				if req.ParallelToolCalls == nil || *req.ParallelToolCalls {
+					t.Errorf("parallel tool calls not disabled")
+				}
+				var format struct {
+					Type       string `json:"type"`
+					JSONSchema struct {
+						Name string `json:"name"`
+					} `json:"json_schema"`
+				}
+				if err := json.Unmarshal(req.ResponseFormat, &format); err != nil || format.Type != "json_schema" || format.JSONSchema.Name != "answer" {
+					t.Errorf("unexpected response format: %s", req.ResponseFormat)
+				}
+			}},
+	}
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	url := newFrontendTestProxy(t, f)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := postJSON(t, url+"/v1/responses", tt.body, nil); status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			req, _ := f.last()
+			tt.check(t, req)
+		})
+	}
+
+	// 不支持的工具类型直接拒绝
+	status, body := postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","input":"hi","tools":[{"type":"web_search"}]}`, nil)
+	var errResp openai.ErrorResponse
+	decodeJSON(t, body, &errResp)
+	if status != http.StatusBadRequest || !strings.Contains(errResp.Error.Message, "web_search") {
+		t.Errorf("unexpected response %d: %s", status, body)
+	}
+}
+
+func TestResponsesFrontendResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  string
+		check  func(t *testing.T, resp responsesResponse, body []byte)
+	}{
+		{"text", http.StatusOK, chatHello, func(t *testing.T, resp responsesResponse, _ []byte) {
+			if !strings.HasPrefix(resp.ID, "resp_") || resp.Object != "response" || resp.Status != "completed" || resp.Model != "gpt-4o" {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+			if len(resp.Output) != 1 || resp.Output[0].Type != "message" || resp.Output[0].Content[0].Text != "hello" {
+				t.Errorf("unexpected output: %+v", resp.Output)
+			}
+			if resp.Usage == nil || resp.Usage.InputTokens != 3 || resp.Usage.OutputTokens != 1 || resp.Usage.TotalTokens != 4 {
+				t.Errorf("unexpected usage: %+v", resp.Usage)
+			}
+		}},
+		{"tool calls", http.StatusOK, `{"id":"c2","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"checking",
+			"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			func(t *testing.T, resp responsesResponse, _ []byte) {
+				if len(resp.Output) != 2 || resp.Status != "completed" {
+					t.Fatalf("unexpected response: %+v", resp)
+				}
+				if item := resp.Output[1]; item.Type != "function_call" || item.CallID != "call_1" || item.Name != "get_weather" ||
+					item.Arguments == nil || *item.Arguments != `{"city":"Paris"}` {
+					t.Errorf("unexpected function call: %+v", item)
+				}
+			}},
+		{"max tokens", http.StatusOK, `{"id":"c3","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"he"},"finish_reason":"length"}]}`,
+			func(t *testing.T, resp responsesResponse, _ []byte) {
+				if resp.Status != "incomplete" || resp.IncompleteDetails == nil || resp.IncompleteDetails.Reason != "max_output_tokens" {
+					t.Errorf("unexpected response: %+v", resp)
+				}
+			}},
+		{"upstream error", http.StatusTooManyRequests, `{"error":{"message":"slow down","type":"tokens"}}`,
+			func(t *testing.T, _ responsesResponse, body []byte) {
+				var e openai.ErrorResponse
+				decodeJSON(t, body, &e)
+				if e.Error.Type != "tokens" || e.Error.Message != "slow down" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+		{"server error", http.StatusBadGateway, `bad gateway`,
+			func(t *testing.T, _ responsesResponse, body []byte) {
+				var e openai.ErrorResponse
+				decodeJSON(t, body, &e)
+				if e.Error.Type != "server_error" || e.Error.Message != "bad gateway" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := newFrontendTestProxy(t, newFakeChat(t, replyJSON(tt.status, tt.reply)))
+			status, body := postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","input":"hi"}`, nil)
+			if status != tt.status {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			var resp responsesResponse
+			if status == http.StatusOK {
+				decodeJSON(t, body, &resp)
+			}
+			tt.check(t, resp, body)
+		})
+	}
+}
+
+func TestResponsesFrontendStream(t *testing.T) {
+	toolChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\""}}]},"finish_reason":null}]}`
+	argsChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`
+	usageChunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8}}`
+
+	tests := []struct {
+		name   string
+		chunks []string
+		events []string // 依次出现的事件类型
+		output []string // 最终响应的输出项类型
+	}{
+		{"text", []string{chunkHel, chunkLo, usageChunk},
+			[]string{"response.created", "response.in_progress", "response.output_item.added", "response.content_part.added",
+				"response.output_text.delta", "response.output_text.delta",
+				"response.output_text.done", "response.content_part.done", "response.output_item.done", "response.completed"},
+			[]string{"message"}},
+		{"text then tool", []string{chunkHel, toolChunk, argsChunk, usageChunk},
+			[]string{"response.created", "response.in_progress", "response.output_item.added", "response.content_part.added",
+				"response.output_text.delta", "response.output_text.done", "response.content_part.done", "response.output_item.done",
+				"response.output_item.added", "response.function_call_arguments.delta", "response.function_call_arguments.delta",
+				"response.function_call_arguments.done", "response.output_item.done", "response.completed"},
+			[]string{"message", "function_call"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeChat(t, replyStream(tt.chunks...))
+			url := newFrontendTestProxy(t, f)
+			status, body := postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","stream":true,"input":"hi"}`, nil)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if req, _ := f.last(); req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+				t.Errorf("usage not requested: %+v", req.StreamOptions)
+			}
+
+			var types []string
+			var last struct {
+				Type           string            `json:"type"`
+				SequenceNumber int               `json:"sequence_number"`
+				Response       responsesResponse `json:"response"`
+			}
+			for i, ev := range readEvents(body) {
+				types = append(types, ev.Event)
+				decodeJSON(t, ev.Data, &last)
+				if last.Type != ev.Event || last.SequenceNumber != i {
+					t.Errorf("event %s carries type %s, sequence %d", ev.Event, last.Type, last.SequenceNumber)
+				}
+			}
+			if strings.Join(types, ",") != strings.Join(tt.events, ",") {
+				t.Errorf("events %v, want %v", types, tt.events)
+			}
+
+			resp := last.Response
+			var output []string
+			for _, item := range resp.Output {
+				output = append(output, item.Type)
+			}
+			if resp.Status != "completed" || strings.Join(output, ",") != strings.Join(tt.output, ",") || resp.Usage == nil || resp.Usage.TotalTokens != 8 {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+			if n := len(resp.Output); resp.Output[n-1].Type == "function_call" && *resp.Output[n-1].Arguments != `{"city":"Paris"}` {
+				t.Errorf("unexpected arguments: %s", *resp.Output[n-1].Arguments)
+			}
+
+			// 流式响应完成后同样保存，可以通过 ID 查询
+			if status, stored := doResponses(t, http.MethodGet, url+"/v1/responses/"+resp.ID, ""); status != http.StatusOK || !strings.Contains(string(stored), resp.ID) {
+				t.Errorf("stream response not stored: %d %s", status, stored)
+			}
+		})
+	}
+}
+
+func TestResponsesPreviousResponse(t *testing.T) {
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	url := newFrontendTestProxy(t, f)
+
+	var first responsesResponse
+	_, body := postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","instructions":"be brief","input":"hi"}`, nil)
+	decodeJSON(t, body, &first)
+
+	// 串联之前的对话，instructions 不会沿用
+	status, body := postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","input":"again","previous_response_id":"`+first.ID+`"}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	req, _ := f.last()
+	var roles []string
+	for _, msg := range req.Messages {
+		roles = append(roles, msg.Role+":"+msg.Content)
+	}
+	if strings.Join(roles, ",") != "user:hi,assistant:hello,user:again" {
+		t.Errorf("unexpected messages: %v", roles)
+	}
+	var second responsesResponse
+	decodeJSON(t, body, &second)
+	if second.PreviousResponseID == nil || *second.PreviousResponseID != first.ID {
+		t.Errorf("unexpected previous_response_id: %v", second.PreviousResponseID)
+	}
+
+	// store 为 false 的响应不保存
+	var unstored responsesResponse
+	_, body = postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","input":"hi","store":false}`, nil)
+	decodeJSON(t, body, &unstored)
+	if status, _ := doResponses(t, http.MethodGet, url+"/v1/responses/"+unstored.ID, ""); status != http.StatusNotFound {
+		t.Errorf("unstored response found: %d", status)
+	}
+
+	if status, _ := doResponses(t, http.MethodDelete, url+"/v1/responses/"+first.ID, ""); status != http.StatusOK {
+		t.Errorf("delete status %d", status)
+	}
+	if status, _ := postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","input":"again","previous_response_id":"`+first.ID+`"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("deleted response chained: %d", status)
+	}
+}
+
+func TestResponsesScopedToKey(t *testing.T) {
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	url := newFrontendTestProxy(t, f)
+
+	var created responsesResponse
+	_, body := postJSON(t, url+"/v1/responses", `{"model":"gpt-4o","input":"hi"}`, http.Header{"Authorization": {"Bearer sk-a"}})
+	decodeJSON(t, body, &created)
+	chain := `{"model":"gpt-4o","input":"again","previous_response_id":"` + created.ID + `"}`
+
+	// 其它 key 无法读取、串联或删除
+	for _, key := range []string{"sk-b", ""} {
+		if status, _ := doResponses(t, http.MethodGet, url+"/v1/responses/"+created.ID, key); status != http.StatusNotFound {
+			t.Errorf("key %q read response: %d", key, status)
+		}
+		var header http.Header
+		if key != "" {
+			header = http.Header{"Authorization": {"Bearer " + key}}
+		}
+		if status, body := postJSON(t, url+"/v1/responses", chain, header); status != http.StatusBadRequest {
+			t.Errorf("key %q chained response: %d %s", key, status, body)
+		}
+		if status, _ := doResponses(t, http.MethodDelete, url+"/v1/responses/"+created.ID, key); status != http.StatusNotFound {
+			t.Errorf("key %q deleted response: %d", key, status)
+		}
+	}
+
+	// anthropic 风格的 x-api-key 与 Bearer 是同一个 key
+	if status, _ := postJSON(t, url+"/v1/responses", chain, http.Header{"X-Api-Key": {"sk-a"}}); status != http.StatusOK {
+		t.Errorf("owner could not chain with x-api-key: %d", status)
+	}
+	if status, _ := doResponses(t, http.MethodGet, url+"/v1/responses/"+created.ID, "sk-a"); status != http.StatusOK {
+		t.Errorf("owner could not read response: %d", status)
+	}
+	if status, _ := doResponses(t, http.MethodDelete, url+"/v1/responses/"+created.ID, "sk-a"); status != http.StatusOK {
+		t.Errorf("owner could not delete response: %d", status)
+	}
+}
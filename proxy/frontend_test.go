@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// fakeChat 本地的 chat/completions 上游，reply 写出响应，收到的请求通过 last 读取
+type fakeChat struct {
+	*httptest.Server
+	mu      sync.Mutex
+	request openai.ChatCompletionRequest
+	header  http.Header
+}
+
+func newFakeChat(t *testing.T, reply func(w http.ResponseWriter, r *http.Request, req openai.ChatCompletionRequest)) *fakeChat {
+	f := &fakeChat{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid chat request: %v", err)
+		}
+		f.mu.Lock()
+		f.request, f.header = req, r.Header.Clone()
+		f.mu.Unlock()
+		reply(w, r, req)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// last 返回最近一次收到的请求
+func (f *fakeChat) last() (openai.ChatCompletionRequest, http.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.request, f.header
+}
+
+func newFrontendTestProxy(t *testing.T, f *fakeChat) string {
+	_, srv := newTestProxy(t, Config{TargetURL: f.URL})
+	return srv.URL
+}
+
+// replyJSON 返回固定的 JSON 响应
+func replyJSON(status int, body string) func(http.ResponseWriter, *http.Request, openai.ChatCompletionRequest) {
+	return func(w http.ResponseWriter, _ *http.Request, _ openai.ChatCompletionRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+// replyStream 以 SSE 逐个写出 chat.completion.chunk，最后写出 [DONE]
+func replyStream(chunks ...string) func(http.ResponseWriter, *http.Request, openai.ChatCompletionRequest) {
+	return func(w http.ResponseWriter, _ *http.Request, _ openai.ChatCompletionRequest) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range append(chunks, sseDone) {
+			io.WriteString(w, "data: "+chunk+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// readEvents 解析 SSE 响应体
+func readEvents(body []byte) []sseEvent {
+	var parser sseParser
+	return append(parser.Feed(body), parser.Flush()...)
+}
+
+const (
+	chatHello = `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":"gpt-4o",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`
+	chunkHel = `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"hel"},"finish_reason":null}]}`
+	chunkLo  = `{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`
+)
+
+// frontendCases 每个前端的请求和响应的特征
+var frontendCases = []struct {
+	name     string
+	path     string
+	body     string // 非流式请求
+	stream   string // 流式请求
+	object   string // 非流式响应中的对象类型
+	terminal string // 流式响应的收尾事件中出现的内容
+}{
+	{"anthropic", "/v1/messages",
+		`{"model":"gpt-4o","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"gpt-4o","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`,
+		`"type":"message"`, "message_stop"},
+	{"responses", "/v1/responses",
+		`{"model":"gpt-4o","input":"hi"}`,
+		`{"model":"gpt-4o","stream":true,"input":"hi"}`,
+		`"object":"response"`, "response.completed"},
+	{"completions", "/v1/completions",
+		`{"model":"gpt-4o","prompt":"hi"}`,
+		`{"model":"gpt-4o","stream":true,"prompt":"hi"}`,
+		`"object":"text_completion"`, sseDone},
+}
+
+func TestFrontendGzipUpstream(t *testing.T) {
+	f := newFakeChat(t, func(w http.ResponseWriter, r *http.Request, _ openai.ChatCompletionRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			io.WriteString(w, chatHello)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		io.WriteString(zw, chatHello)
+		zw.Close()
+	})
+	url := newFrontendTestProxy(t, f)
+
+	for _, tt := range frontendCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// 显式设置 Accept-Encoding 时客户端不会自动解压，响应必须是转换后的明文
+			req, _ := http.NewRequest(http.MethodPost, url+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+				t.Fatalf("status %d, encoding %q", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+			}
+			if !json.Valid(body) || !strings.Contains(string(body), tt.object) {
+				t.Errorf("response not translated: %q", body)
+			}
+		})
+	}
+}
+
+func TestFrontendAbortedStream(t *testing.T) {
+	f := newFakeChat(t, func(w http.ResponseWriter, _ *http.Request, _ openai.ChatCompletionRequest) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: "+chunkHel+"\n\n")
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+	url := newFrontendTestProxy(t, f)
+
+	for _, tt := range frontendCases {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(url+tt.path, "application/json", strings.NewReader(tt.stream))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			// 客户端看到连接中断，不会收到收尾事件
+			if err == nil {
+				t.Errorf("stream not interrupted: %q", body)
+			}
+			if !strings.Contains(string(body), "hel") {
+				t.Errorf("partial content not delivered: %q", body)
+			}
+			if strings.Contains(string(body), tt.terminal) {
+				t.Errorf("unexpected terminal event %s: %q", tt.terminal, body)
+			}
+
+			// 中断的 Responses API 响应不会保存
+			if m := regexp.MustCompile(`"id":"(resp_[0-9a-f]+)"`).FindSubmatch(body); m != nil {
+				resp, err := http.Get(url + "/v1/responses/" + string(m[1]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusNotFound {
+					t.Errorf("aborted response stored: status %d", resp.StatusCode)
+				}
+			} else if tt.name == "responses" {
+				t.Errorf("response id not found: %q", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHealthCheckInterval = 30 * time.Second
+	healthCheckTimeout         = 10 * time.Second
+)
+
+// healthy 最近一次健康检查是否成功，尚未检查或未开启健康检查时视为健康
+func (up *Upstream) healthy() bool {
+	up.stats.mu.Lock()
+	defer up.stats.mu.Unlock()
+	return !up.stats.unhealthy
+}
+
+// observeProbe 记录健康检查的结果，返回健康状态是否发生变化；连接失败、429 和 5xx 视为不健康
+func (up *Upstream) observeProbe(status int, err error, latency time.Duration) bool {
+	s := &up.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unhealthy := err != nil || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+	changed := unhealthy != s.unhealthy
+	s.unhealthy = unhealthy
+	s.probeStatus = status
+	s.probeError = ""
+	if err != nil {
+		s.probeError = err.Error()
+	}
+	s.probeLatency = latency
+	s.probedAt = time.Now()
+	return changed
+}
+
+// probeRequest 构造健康检查请求：配置了 HealthCheckBody 时发送 chat/completions 请求，否则请求上游列出模型的接口，
+// 两者都经过适配器改写为上游协议
+func (up *Upstream) probeRequest(ctx context.Context) (*http.Request, error) {
+	method, reqPath := http.MethodGet, "/v1/models"
+	var body []byte
+	if up.Config.HealthCheckBody != "" {
+		method, reqPath, body = http.MethodPost, "/v1/chat/completions", []byte(up.Config.HealthCheckBody)
+	}
+
+	target := *up.target
+	target.Path = reqPath
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range up.Config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if prober, ok := up.adapter.(HealthProber); ok && body == nil {
+		prober.RewriteProbe(req)
+		return req, nil
+	}
+	if body, err = up.adapter.RewriteRequest(req, body); err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return req, nil
+}
+
+// probe 通过上游的连接池对上游执行一次健康检查
+func (up *Upstream) probe(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	req, err := up.probeRequest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := up.transport.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return resp.StatusCode, nil
+}
+
+// startHealthCheck 在后台定期检查所有上游，结果用于 /readyz、上游状态和路由
+func (p *Proxy) startHealthCheck() {
+	if p.config.HealthCheckSeconds < 0 || len(p.upstreams) == 0 {
+		return
+	}
+	interval := defaultHealthCheckInterval
+	if p.config.HealthCheckSeconds > 0 {
+		interval = time.Duration(p.config.HealthCheckSeconds) * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopHealthCheck = cancel
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.probeUpstreams(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// probeUpstreams 并发检查所有上游
+func (p *Proxy) probeUpstreams(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, up := range p.upstreams {
+		wg.Add(1)
+		go func(up *Upstream) {
+			defer wg.Done()
+			start := time.Now()
+			status, err := up.probe(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if up.observeProbe(status, err, time.Since(start)) {
+				if up.healthy() {
+					p.logger.Info("Upstream", up.Config.Name, "is healthy again")
+				} else {
+					p.logger.Error(fmt.Sprintf("Upstream %s is unhealthy: status=%d err=%v", up.Config.Name, status, err))
+				}
+			}
+		}(up)
+	}
+	wg.Wait()
+}
+
+// handleHealthz 进程存活即返回 200
+func (p *Proxy) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz 配置了可用的上游并且至少一个上游健康时返回 200，否则返回 503
+func (p *Proxy) handleReadyz(c *gin.Context) {
+	upstreams := make([]gin.H, 0, len(p.upstreams))
+	ready := false
+	for _, up := range p.upstreams {
+		st := up.Status()
+		ready = ready || st.Healthy
+		upstreams = append(upstreams, gin.H{
+			"name":             st.Name,
+			"healthy":          st.Healthy,
+			"probe_status":     st.ProbeStatus,
+			"probe_error":      st.ProbeError,
+			"probe_latency_ms": st.ProbeLatencyMs,
+			"probed_at":        st.ProbedAt,
+		})
+	}
+	status, text := http.StatusOK, "ready"
+	if !ready {
+		status, text = http.StatusServiceUnavailable, "not ready"
+	}
+	c.JSON(status, gin.H{"status": text, "upstreams": upstreams})
+}
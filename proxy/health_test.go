@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProbeTarget 本地上游，按 status 返回响应，最近一次请求通过 last 读取，posts 为收到的 POST 请求数
+type fakeProbeTarget struct {
+	*httptest.Server
+	status atomic.Int32
+	posts  atomic.Int32
+	mu     sync.Mutex
+	method string
+	uri    string
+	header http.Header
+	body   string
+}
+
+func newFakeProbeTarget(t *testing.T) *fakeProbeTarget {
+	f := &fakeProbeTarget{}
+	f.status.Store(http.StatusOK)
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == http.MethodPost {
+			f.posts.Add(1)
+		}
+		f.mu.Lock()
+		f.method, f.uri, f.header, f.body = r.Method, r.URL.RequestURI(), r.Header.Clone(), string(body)
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(f.status.Load()))
+		io.WriteString(w, `{"object":"list","data":[]}`)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeProbeTarget) last() (method, uri string, header http.Header, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.method, f.uri, f.header, f.body
+}
+
+// readyz 请求 /readyz，返回状态码和各上游是否健康
+func readyz(t *testing.T, url string) (int, map[string]bool) {
+	t.Helper()
+	resp, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var ready struct {
+		Upstreams []struct {
+			Name    string `json:"name"`
+			Healthy bool   `json:"healthy"`
+		} `json:"upstreams"`
+	}
+	decodeJSON(t, body, &ready)
+	healthy := make(map[string]bool, len(ready.Upstreams))
+	for _, up := range ready.Upstreams {
+		healthy[up.Name] = up.Healthy
+	}
+	return resp.StatusCode, healthy
+}
+
+func TestProbeRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		conf   UpstreamConfig
+		method string
+		uri    string
+		header map[string]string // 期望的 header，值为空表示不应出现
+		body   string
+	}{
+		{"openai", UpstreamConfig{Headers: map[string]string{"Authorization": "Bearer sk-1"}},
+			http.MethodGet, "/models", map[string]string{"Authorization": "Bearer sk-1"}, ""},
+		{"health check body", UpstreamConfig{HealthCheckBody: `{"model":"gpt-4o-mini","max_tokens":1,"messages":[{"role":"user","content":"hi"}]}`},
+			http.MethodPost, "/chat/completions", map[string]string{"Content-Type": "application/json"}, `"max_tokens":1`},
+		{"azure", UpstreamConfig{Type: UpstreamTypeAzure, Headers: map[string]string{"Authorization": "Bearer az-key"}},
+			http.MethodGet, "/openai/models?api-version=" + defaultAzureAPIVersion, map[string]string{"api-key": "az-key", "Authorization": ""}, ""},
+		{"anthropic", UpstreamConfig{Type: UpstreamTypeAnthropic, Headers: map[string]string{"Authorization": "Bearer sk-ant"}},
+			http.MethodGet, "/v1/models", map[string]string{"x-api-key": "sk-ant", "anthropic-version": anthropicVersion, "Authorization": ""}, ""},
+		{"gemini", UpstreamConfig{Type: UpstreamTypeGemini, Headers: map[string]string{"Authorization": "Bearer goog"}},
+			http.MethodGet, "/v1beta/models", map[string]string{"x-goog-api-key": "goog", "Authorization": ""}, ""},
+		{"ollama", UpstreamConfig{Type: UpstreamTypeOllama},
+			http.MethodGet, "/api/tags", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeProbeTarget(t)
+			tt.conf.Name, tt.conf.TargetURL = tt.name, f.URL
+			p, _ := newTestProxy(t, Config{Upstreams: []UpstreamConfig{tt.conf}})
+			p.probeUpstreams(context.Background())
+
+			method, uri, header, body := f.last()
+			if method != tt.method || uri != tt.uri {
+				t.Errorf("probe %s %s, want %s %s", method, uri, tt.method, tt.uri)
+			}
+			for k, v := range tt.header {
+				if header.Get(k) != v {
+					t.Errorf("header %s = %q, want %q", k, header.Get(k), v)
+				}
+			}
+			if tt.body != "" && !strings.Contains(body, tt.body) {
+				t.Errorf("probe body %s, want %s", body, tt.body)
+			}
+			if st := p.upstreams[0].Status(); !st.Healthy || st.ProbeStatus != http.StatusOK || st.ProbedAt.IsZero() {
+				t.Errorf("unexpected status: %+v", st)
+			}
+		})
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	a, b := newFakeProbeTarget(t), newFakeProbeTarget(t)
+	p, srv := newTestProxy(t, Config{
+		PathPrefix: "/proxy",
+		Upstreams:  []UpstreamConfig{{Name: "a", TargetURL: a.URL}, {Name: "b", TargetURL: b.URL}},
+	})
+
+	// 尚未检查时视为健康
+	if status, healthy := readyz(t, srv.URL); status != http.StatusOK || !healthy["a"] || !healthy["b"] {
+		t.Fatalf("before probing: %d %v", status, healthy)
+	}
+
+	tests := []struct {
+		name    string
+		a, b    int // 两个上游返回的状态码
+		status  int
+		healthy map[string]bool
+	}{
+		{"one unhealthy", http.StatusServiceUnavailable, http.StatusOK, http.StatusOK, map[string]bool{"a": false, "b": true}},
+		{"rate limited", http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable, map[string]bool{"a": false, "b": false}},
+		{"client errors are healthy", http.StatusUnauthorized, http.StatusNotFound, http.StatusOK, map[string]bool{"a": true, "b": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.status.Store(int32(tt.a))
+			b.status.Store(int32(tt.b))
+			p.probeUpstreams(context.Background())
+			status, healthy := readyz(t, srv.URL)
+			if status != tt.status || healthy["a"] != tt.healthy["a"] || healthy["b"] != tt.healthy["b"] {
+				t.Errorf("readyz %d %v, want %d %v", status, healthy, tt.status, tt.healthy)
+			}
+		})
+	}
+
+	// 连接失败视为不健康
+	a.status.Store(http.StatusOK)
+	b.Close()
+	p.probeUpstreams(context.Background())
+	if status, healthy := readyz(t, srv.URL); status != http.StatusOK || !healthy["a"] || healthy["b"] {
+		t.Errorf("after closing b: %d %v", status, healthy)
+	}
+	if st := p.upstreams[1].Status(); st.ProbeError == "" {
+		t.Errorf("probe error not recorded: %+v", st)
+	}
+
+	// /healthz 只反映进程存活
+	resp, err := http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("healthz status %d", resp.StatusCode)
+	}
+}
+
+func TestHealthCheckRoutesAroundUnhealthyUpstream(t *testing.T) {
+	a, b := newFakeProbeTarget(t), newFakeProbeTarget(t)
+	_, srv := newTestProxy(t, Config{
+		HealthCheckSeconds: 1,
+		Upstreams:          []UpstreamConfig{{Name: "a", TargetURL: a.URL}, {Name: "b", TargetURL: b.URL}},
+	})
+	a.status.Store(http.StatusBadGateway)
+
+	// 后台检查立即执行一次，之后每秒一次
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, healthy := readyz(t, srv.URL); !healthy["a"] && healthy["b"] {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background probe did not mark upstream a unhealthy")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if status, body := postJSON(t, srv.URL+"/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil); status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	if a.posts.Load() != 0 || b.posts.Load() != 1 {
+		t.Errorf("chat requests: a got %d, b got %d; want the request on b", a.posts.Load(), b.posts.Load())
+	}
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	// cutoffGrace 取消剩余请求后等待它们退出的时间
+	cutoffGrace = 5 * time.Second
+)
+
+// inflightRequests 进行中的请求（包括流式响应、WebSocket 和 batch 请求），关闭服务时等待或取消
+type inflightRequests struct {
+	mu       sync.Mutex
+	next     int64
+	requests map[int64]*inflightRequest
+	draining chan struct{} // 开始关闭服务时关闭
+}
+
+type inflightRequest struct {
+	cancel    context.CancelFunc
+	websocket bool
+}
+
+type inflightKey struct{}
+
+// begin 登记一个请求，返回可以被取消的 context 和请求结束时调用的函数
+func (r *inflightRequests) begin(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	if r.requests == nil {
+		r.requests = make(map[int64]*inflightRequest)
+	}
+	r.next++
+	id := r.next
+	r.requests[id] = &inflightRequest{cancel: cancel}
+	r.mu.Unlock()
+	return context.WithValue(ctx, inflightKey{}, id), func() {
+		r.mu.Lock()
+		delete(r.requests, id)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// markWebSocket 标记 ctx 对应的请求为 WebSocket 会话，开始关闭服务时会话会收到 1001 关闭帧，不计入被中断的请求
+func (r *inflightRequests) markWebSocket(ctx context.Context) {
+	id, _ := ctx.Value(inflightKey{}).(int64)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req, ok := r.requests[id]; ok {
+		req.websocket = true
+	}
+}
+
+// drainingCh 返回开始关闭服务时关闭的 channel
+func (r *inflightRequests) drainingCh() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.drainingLocked()
+}
+
+func (r *inflightRequests) drainingLocked() chan struct{} {
+	if r.draining == nil {
+		r.draining = make(chan struct{})
+	}
+	return r.draining
+}
+
+// drain 通知 WebSocket 会话服务即将关闭，返回会话数量
+func (r *inflightRequests) drain() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch := r.drainingLocked()
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+	sessions := 0
+	for _, req := range r.requests {
+		if req.websocket {
+			sessions++
+		}
+	}
+	return sessions
+}
+
+func (r *inflightRequests) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// cancelAll 取消所有进行中的请求，返回取消的 HTTP 请求数量（不包括 WebSocket 会话）
+func (r *inflightRequests) cancelAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cut := 0
+	for _, req := range r.requests {
+		req.cancel()
+		if !req.websocket {
+			cut++
+		}
+	}
+	return cut
+}
+
+// wait 等待所有请求结束
+func (r *inflightRequests) wait(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for r.count() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Start 启动代理服务（ListenAddr）和管理 API（AdminAddr，配置了时），阻塞直到服务停止。
+// ctx 结束时优雅关闭：不再接受新连接，等待进行中的请求最多 DrainTimeoutSeconds 秒，之后取消剩余的请求
+func (p *Proxy) Start(ctx context.Context) error {
+	gin.SetMode(gin.ReleaseMode)
+	var servers []*http.Server
+	if p.config.ListenAddr != "" {
+		servers = append(servers, &http.Server{Addr: p.config.ListenAddr, Handler: p.router()})
+	}
+	if p.config.AdminAddr != "" {
+		admin, err := p.adminRouter()
+		if err != nil {
+			return err
+		}
+		servers = append(servers, &http.Server{Addr: p.config.AdminAddr, Handler: admin})
+	}
+	if len(servers) == 0 {
+		return errors.New("neither listen_addr nor admin_addr is configured")
+	}
+
+	p.mu.Lock()
+	if p.config.ListenAddr != "" {
+		p.server = servers[0]
+	}
+	if p.config.AdminAddr != "" {
+		p.adminServer = servers[len(servers)-1]
+	}
+	p.mu.Unlock()
+
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			p.logger.Info("Listening on", srv.Addr)
+			errc <- srv.ListenAndServe()
+		}(srv)
+	}
+
+	select {
+	case err := <-errc:
+		// 已经通过 Shutdown 关闭
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		// 其中一个服务启动失败，关闭其它服务
+		ctx, cancel := context.WithTimeout(context.Background(), p.drainTimeout())
+		defer cancel()
+		_ = p.Shutdown(ctx)
+		return err
+	case <-ctx.Done():
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.drainTimeout())
+		defer cancel()
+		return p.Shutdown(ctx)
+	}
+}
+
+// Shutdown 优雅关闭：停止接受新连接和后台任务，等待进行中的请求（包括流式响应）结束，
+// ctx 结束时取消剩余的上游请求并记录被中断的数量。没有通过 Start 启动服务时只等待和取消请求
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	server, admin := p.server, p.adminServer
+	p.mu.Unlock()
+
+	p.logger.Info(fmt.Sprintf("Shutting down, %d in-flight requests", p.inflight.count()))
+	// WebSocket 会话没有自然结束的时候，开始关闭时就发送 1001 关闭帧
+	if sessions := p.inflight.drain(); sessions > 0 {
+		p.logger.Info(fmt.Sprintf("Closing %d WebSocket sessions", sessions))
+	}
+	// 管理 API 的连接（如仪表盘的 SSE）直接关闭
+	if admin != nil {
+		_ = admin.Close()
+	}
+	if p.stopHealthCheck != nil {
+		p.stopHealthCheck()
+	}
+	// 未完成的 batch 在重启后继续执行
+	if p.batches != nil {
+		p.batches.close()
+	}
+
+	// 请求结束后关闭插件，释放上游连接池中的空闲连接
+	defer func() {
+		p.closePlugins()
+		for _, up := range p.upstreams {
+			up.transport.CloseIdleConnections()
+		}
+	}()
+
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+	// 被接管的连接（WebSocket）和 batch 请求不在 http.Server 的等待范围内
+	if err == nil {
+		err = p.inflight.wait(ctx)
+	}
+	if err == nil {
+		p.logger.Info("All in-flight requests finished")
+		return nil
+	}
+
+	cut := p.inflight.cancelAll()
+	p.logger.Error(fmt.Sprintf("Drain timeout reached, cut off %d in-flight requests", cut))
+	graceCtx, cancel := context.WithTimeout(context.Background(), cutoffGrace)
+	defer cancel()
+	_ = p.inflight.wait(graceCtx)
+	if server != nil {
+		_ = server.Close()
+	}
+	return fmt.Errorf("shutdown: %d in-flight requests cut off: %w", cut, err)
+}
+
+// drainTimeout 返回关闭服务时等待进行中的请求的时间
+func (p *Proxy) drainTimeout() time.Duration {
+	if p.config.DrainTimeoutSeconds > 0 {
+		return time.Duration(p.config.DrainTimeoutSeconds) * time.Second
+	}
+	return defaultDrainTimeout
+}
@@ -45,6 +45,17 @@ func (l *DefaultLogger) Error(args ...interface{}) {
 	l.error.Println(args...)
 }
 
+// memBody 内存中的请求体，LoggingTransport 可以直接取得内容，不需要读取后再替换
+type memBody struct {
+	*bytes.Buffer
+}
+
+func newMemBody(body []byte) *memBody {
+	return &memBody{Buffer: bytes.NewBuffer(body)}
+}
+
+func (*memBody) Close() error { return nil }
+
 // LoggingTransport 自定义传输层
 type LoggingTransport struct {
 	Transport http.RoundTripper
@@ -58,7 +69,11 @@ func (t *LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	t.Logger.Info(fmt.Sprintf("[Request] %s %s", req.Method, req.URL))
 	t.Logger.Debug("Request Headers:", req.Header)
 
-	if req.Body != nil && !strings.Contains(req.Header.Get("Content-Type"), "text/event-stream") {
+	// 内存中的请求体直接取得内容；multipart 请求体以流的方式转发，不能读取
+	if body, ok := req.Body.(*memBody); ok {
+		t.Logger.Debug("Request Body:", body.String())
+	} else if req.Body != nil && !strings.Contains(req.Header.Get("Content-Type"), "text/event-stream") &&
+		!strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
 		body, _ := io.ReadAll(req.Body)
 		req.Body = io.NopCloser(bytes.NewBuffer(body))
 		t.Logger.Debug("Request Body:", string(body))
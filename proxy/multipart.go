@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+// maxFormFieldSize 单个文本字段的大小上限，文件部分不受限制
+const maxFormFieldSize = 1 << 20
+
+// formField multipart 中的一个文本字段
+type formField struct {
+	header textproto.MIMEHeader
+	name   string
+	value  string
+}
+
+// multipartBoundary 返回 multipart/form-data 请求的 boundary，其它请求返回空字符串
+func multipartBoundary(req *http.Request) string {
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// handleMultipart 以流的方式转发 multipart/form-data 请求（音频转写、图片编辑等）
+//
+// 第一个文件之前的文本字段会先读出来，用其中的 model 匹配路由，经过路由中的 AdmitPlugin 检查、FormPlugin 改写后选择上游；
+// 之后的内容边读边写，文件不会整体缓存在内存中
+func (p *Proxy) handleMultipart(c *gin.Context, boundary string) {
+	mr := multipart.NewReader(c.Request.Body, boundary)
+
+	var leading []formField
+	var firstFile *multipart.Part
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.writeMultipartError(c, err)
+			return
+		}
+		if part.FileName() != "" {
+			firstFile = part
+			break
+		}
+		field, err := readFormField(part)
+		if err != nil {
+			p.writeMultipartError(c, err)
+			return
+		}
+		leading = append(leading, field)
+	}
+
+	pctx := pluginPKG.NewRequestContext(c.Request, formContextBody(leading))
+	tracked := p.traffic.track(c, c.Request.URL.Path)
+	tracked.route(contextMeta(pctx), "")
+	route := p.matchRoute(pctx, c.Request.URL.Path)
+	plugins := p.routePlugins(route)
+	defer func() {
+		if err := recover(); err != nil {
+			tracked.done(true)
+			panic(err)
+		}
+		tracked.done(false)
+	}()
+	p.logger.Info(fmt.Sprintf("Incoming multipart request: %s %s (route %s)", c.Request.Method, c.Request.URL.Path, route.Name))
+
+	if !p.admit(c, plugins, pctx) {
+		return
+	}
+	if err := p.rewriteFormFields(c.Request, leading, plugins); err != nil {
+		p.logger.Error("Plugin error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	meta := requestMeta{}
+	for _, field := range leading {
+		switch field.name {
+		case "model":
+			meta.Model = field.value
+		case "stream":
+			meta.Stream = field.value == "true"
+		}
+	}
+	upstream := p.routeUpstream(route, meta.Model)
+	if upstream == nil {
+		p.logger.Error("No upstream available for model:", meta.Model)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no upstream available"})
+		return
+	}
+	tracked.route(meta, upstream.Config.Name)
+	upstream.applyHeaders(c.Request, c.GetHeader("Authorization"), p.logger)
+	// 适配器只需要从请求体中读取模型来改写路径和认证头，multipart 请求体本身原样转发
+	stub, _ := json.Marshal(requestMeta{Model: meta.Model})
+	if _, err := upstream.adapter.RewriteRequest(c.Request, stub); err != nil {
+		p.logger.Error("Failed to rewrite request for upstream:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	src := c.Request.Body
+	c.Request.Body = pipeBody(src, func(_ io.Reader, w io.Writer) error {
+		return p.copyMultipart(c.Request, w, boundary, mr, leading, firstFile, plugins)
+	})
+	// 字段可能被改写，长度未知，使用 chunked 编码
+	c.Request.ContentLength = -1
+	c.Request.Header.Del("Content-Length")
+
+	p.forward(c, upstream, meta, nil)
+}
+
+// copyMultipart 使用原 boundary 重新写出 multipart 请求体：先写改写后的前导字段，再逐个复制剩余部分
+func (p *Proxy) copyMultipart(req *http.Request, w io.Writer, boundary string, mr *multipart.Reader, leading []formField, part *multipart.Part, plugins []pluginPKG.Plugin) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, field := range leading {
+		if err := writeFormField(mw, field); err != nil {
+			return err
+		}
+	}
+
+	for part != nil {
+		if part.FileName() != "" {
+			dst, err := mw.CreatePart(part.Header)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(dst, part); err != nil {
+				return err
+			}
+		} else {
+			// 文件之后的字段逐个交给插件改写
+			field, err := readFormField(part)
+			if err != nil {
+				return err
+			}
+			fields := []formField{field}
+			if err := p.rewriteFormFields(req, fields, plugins); err != nil {
+				return err
+			}
+			if err := writeFormField(mw, fields[0]); err != nil {
+				return err
+			}
+		}
+
+		next, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		part = next
+	}
+	return mw.Close()
+}
+
+// rewriteFormFields 把文本字段交给插件链中实现了 FormPlugin 的插件改写，改写结果写回 fields
+func (p *Proxy) rewriteFormFields(req *http.Request, fields []formField, plugins []pluginPKG.Plugin) error {
+	values := make(url.Values, len(fields))
+	for _, field := range fields {
+		values.Add(field.name, field.value)
+	}
+
+	for _, plugin := range plugins {
+		fp, ok := plugin.(pluginPKG.FormPlugin)
+		if !ok {
+			continue
+		}
+		if err := fp.BeforeForm(req, values); err != nil {
+			return err
+		}
+	}
+
+	// 同名字段按出现顺序取值，插件删除的字段置为空
+	seen := make(map[string]int, len(fields))
+	for i := range fields {
+		name := fields[i].name
+		value := ""
+		if vs := values[name]; seen[name] < len(vs) {
+			value = vs[seen[name]]
+		}
+		seen[name]++
+		if value == fields[i].value {
+			continue
+		}
+		// 值被改写后原来的传输编码不再适用
+		header := make(textproto.MIMEHeader, len(fields[i].header))
+		for k, v := range fields[i].header {
+			header[k] = v
+		}
+		header.Del("Content-Transfer-Encoding")
+		fields[i].header = header
+		fields[i].value = value
+	}
+	return nil
+}
+
+// formContextBody 把前导文本字段转换为 JSON 请求体，用于匹配路由；同名字段取第一个值，stream 转换为布尔值
+func formContextBody(fields []formField) []byte {
+	body := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if _, ok := body[field.name]; ok {
+			continue
+		}
+		if field.name == "stream" {
+			body[field.name] = field.value == "true"
+			continue
+		}
+		body[field.name] = field.value
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+func readFormField(part *multipart.Part) (formField, error) {
+	defer part.Close()
+	data, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+	if err != nil {
+		return formField{}, err
+	}
+	if len(data) > maxFormFieldSize {
+		return formField{}, fmt.Errorf("form field %q is too large", part.FormName())
+	}
+	return formField{header: part.Header, name: part.FormName(), value: string(data)}, nil
+}
+
+func writeFormField(mw *multipart.Writer, field formField) error {
+	dst, err := mw.CreatePart(field.header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(dst, field.value)
+	return err
+}
+
+func (p *Proxy) writeMultipartError(c *gin.Context, err error) {
+	p.logger.Error("Failed to read multipart body:", err)
+	message := "invalid multipart body: " + strings.TrimPrefix(err.Error(), "multipart: ")
+	c.JSON(http.StatusBadRequest, openai.NewErrorResponse(http.StatusBadRequest, message, nil))
+}
@@ -3,48 +3,211 @@ package proxy
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
-	"path"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 
+	"github.com/bagaking/openapi-proxy/openai"
 	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
 )
 
 // Proxy OpenAI 协议代理
 type Proxy struct {
-	config  Config
-	plugins []pluginPKG.Plugin
-	logger  Logger
-	mu      sync.RWMutex
+	config    Config
+	plugins   []pluginPKG.Plugin
+	upstreams []*Upstream
+	responses *responseStore
+	sessions  *sessionRegistry
+	batches   *batchManager
+	coalescer *coalescer
+	traffic   *trafficMonitor
+	disabled  map[string]bool // 停用的插件名称
+	logger    Logger
+
+	inflight        inflightRequests
+	server          *http.Server // Start 启动的代理服务
+	adminServer     *http.Server // Start 启动的管理 API
+	stopHealthCheck context.CancelFunc
+	mu              sync.RWMutex
 }
 
 // 创建新的代理实例
 func NewProxy(cfg Config) *Proxy {
-	return &Proxy{
-		config:  cfg,
-		plugins: make([]pluginPKG.Plugin, 0),
-		logger:  NewDefaultLogger(),
+	p := &Proxy{
+		config:    cfg,
+		plugins:   make([]pluginPKG.Plugin, 0),
+		responses: newResponseStore(cfg.ResponseStoreSize),
+		sessions:  newSessionRegistry(),
+		traffic:   newTrafficMonitor(cfg.DashboardRecords),
+		disabled:  make(map[string]bool),
+		logger:    NewDefaultLogger(),
 	}
+	for _, name := range cfg.DisabledPlugins {
+		p.disabled[name] = true
+	}
+	for _, upConf := range cfg.upstreamConfigs() {
+		up, err := newUpstream(upConf)
+		if err != nil {
+			p.logger.Error("Failed to create upstream:", err)
+			continue
+		}
+		up.proxy = p.newReverseProxy(up)
+		p.upstreams = append(p.upstreams, up)
+	}
+	if cfg.BatchDir != "" {
+		batches, err := newBatchManager(p, cfg)
+		if err != nil {
+			p.logger.Error("Failed to start batch manager:", err)
+		}
+		p.batches = batches
+	}
+	if cfg.CoalesceRequests {
+		p.coalescer = newCoalescer()
+	}
+	p.validateRoutes()
+	p.startHealthCheck()
+	return p
 }
 
-// RegisterPlugin 注册插件
-func (p *Proxy) RegisterPlugin(plugin pluginPKG.Plugin) {
+// RegisterPlugin 注册插件：名称重复时返回错误，实现了 Initializer 的插件先初始化，
+// 按 Priority 插入执行顺序，相同优先级的插件排在已注册的插件之后
+func (p *Proxy) RegisterPlugin(plugin pluginPKG.Plugin) error {
+	name := pluginName(plugin)
+	if p.hasPlugin(name) {
+		return fmt.Errorf("%w: %s", errPluginExists, name)
+	}
+	if initializer, ok := plugin.(pluginPKG.Initializer); ok {
+		if err := initializer.Init(context.Background(), p.pluginDeps()); err != nil {
+			return fmt.Errorf("init plugin %s: %w", name, err)
+		}
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.plugins = append(p.plugins, plugin)
+	// 初始化期间可能注册了同名插件
+	if slices.ContainsFunc(p.plugins, func(other pluginPKG.Plugin) bool { return pluginName(other) == name }) {
+		closePlugin(plugin, p.logger)
+		return fmt.Errorf("%w: %s", errPluginExists, name)
+	}
+	priority := pluginPriority(plugin)
+	i := len(p.plugins)
+	for i > 0 && pluginPriority(p.plugins[i-1]) > priority {
+		i--
+	}
+	p.plugins = slices.Insert(p.plugins, i, plugin)
+	return nil
+}
+
+// UnregisterPlugin 移除插件，实现了 Closer 的插件会被关闭
+func (p *Proxy) UnregisterPlugin(name string) error {
+	p.mu.Lock()
+	i := slices.IndexFunc(p.plugins, func(plugin pluginPKG.Plugin) bool { return pluginName(plugin) == name })
+	if i < 0 {
+		p.mu.Unlock()
+		return fmt.Errorf("%w: %s", errPluginNotFound, name)
+	}
+	plugin := p.plugins[i]
+	p.plugins = slices.Delete(p.plugins, i, i+1)
+	delete(p.disabled, name)
+	p.mu.Unlock()
+
+	closePlugin(plugin, p.logger)
+	return nil
+}
+
+// Plugins 按执行顺序返回已注册的插件，包括停用的插件
+func (p *Proxy) Plugins() []pluginPKG.Plugin {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Clone(p.plugins)
+}
+
+func (p *Proxy) hasPlugin(name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.ContainsFunc(p.plugins, func(plugin pluginPKG.Plugin) bool { return pluginName(plugin) == name })
+}
+
+// registerConfiguredPlugins 按配置中的类型名创建并注册插件
+func (p *Proxy) registerConfiguredPlugins(specs []PluginSpec) error {
+	for _, spec := range specs {
+		plugin, err := pluginPKG.New(spec.Type, p.pluginDeps(), spec.Config)
+		if err != nil {
+			return fmt.Errorf("create plugin %s: %w", spec.Type, err)
+		}
+		if err := p.RegisterPlugin(plugin); err != nil {
+			// 没有注册的插件不会在 Shutdown 时关闭
+			closePlugin(plugin, p.logger)
+			return err
+		}
+		if spec.Enabled != nil && !*spec.Enabled {
+			p.mu.Lock()
+			p.disabled[pluginName(plugin)] = true
+			p.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// closePlugins 按执行顺序的倒序关闭所有插件
+func (p *Proxy) closePlugins() {
+	plugins := p.Plugins()
+	for i := len(plugins) - 1; i >= 0; i-- {
+		closePlugin(plugins[i], p.logger)
+	}
+}
+
+func (p *Proxy) pluginDeps() pluginPKG.Deps {
+	return pluginPKG.Deps{Logger: p.logger}
+}
+
+// activePlugins 按执行顺序返回启用的插件
+func (p *Proxy) activePlugins() []pluginPKG.Plugin {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]pluginPKG.Plugin, 0, len(p.plugins))
+	for _, plugin := range p.plugins {
+		if !p.disabled[pluginName(plugin)] && pluginSelfEnabled(plugin) {
+			out = append(out, plugin)
+		}
+	}
+	return out
+}
+
+// pluginPriority 返回插件的优先级，未实现 Prioritized 时为 0
+func pluginPriority(plugin pluginPKG.Plugin) int {
+	if prioritized, ok := plugin.(pluginPKG.Prioritized); ok {
+		return prioritized.Priority()
+	}
+	return 0
+}
+
+// pluginSelfEnabled 插件自身是否启用，未实现 Switchable 时总是启用
+func pluginSelfEnabled(plugin pluginPKG.Plugin) bool {
+	if switchable, ok := plugin.(pluginPKG.Switchable); ok {
+		return switchable.Enabled()
+	}
+	return true
+}
+
+func closePlugin(plugin pluginPKG.Plugin, logger Logger) {
+	if closer, ok := plugin.(pluginPKG.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("Failed to close plugin", pluginName(plugin), ":", err)
+		}
+	}
 }
 
 // corsMiddleware 创建一个统一处理 CORS 的中间件
@@ -76,9 +239,8 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// 启动代理服务
-func (p *Proxy) Start() error {
-	gin.SetMode(gin.ReleaseMode)
+// router 创建代理服务的路由
+func (p *Proxy) router() *gin.Engine {
 	r := gin.New()
 
 	// 使用自定义的 recovery 中间件
@@ -90,7 +252,7 @@ func (p *Proxy) Start() error {
 	// 所有请求都转发
 	r.Any("/*path", p.handleRequest)
 
-	return r.Run(p.config.ListenAddr)
+	return r
 }
 
 // 自定义 recovery 中间件
@@ -98,10 +260,11 @@ func (p *Proxy) customRecovery() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				// 如果是正常的流式响应中断，忽略它
+				// 响应中途中断（上游断开或合并的领头请求失败），交给 net/http 直接断开客户端连接，
+				// 不能正常结束响应，否则客户端会把截断的响应当作完整的响应
 				if err == http.ErrAbortHandler {
-					p.logger.Info("Stream completed normally")
-					return
+					p.logger.Info("Stream interrupted, aborting client connection")
+					panic(err)
 				}
 
 				// 其他 panic 才记录错误
@@ -120,10 +283,25 @@ func (p *Proxy) handleRequest(c *gin.Context) {
 		return
 	}
 
+	// 登记进行中的请求，关闭服务时等待这些请求结束，超时后取消
+	ctx, done := p.inflight.begin(c.Request.Context())
+	defer done()
+	c.Request = c.Request.WithContext(ctx)
+
 	// 包装响应写入器以支持流式响应
 	wrappedWriter := newStreamResponseWriter(c.Writer)
 	c.Writer = wrappedWriter
 
+	// 健康检查，不受路径前缀影响
+	switch c.Request.URL.Path {
+	case "/healthz":
+		p.handleHealthz(c)
+		return
+	case "/readyz":
+		p.handleReadyz(c)
+		return
+	}
+
 	// 处理路径前缀
 	requestPath := c.Request.URL.Path
 	if p.config.PathPrefix != "" {
@@ -136,21 +314,37 @@ func (p *Proxy) handleRequest(c *gin.Context) {
 		c.Request.URL.Path = strings.TrimPrefix(requestPath, p.config.PathPrefix)
 	}
 
+	// 本地 Batch API
+	if p.batches != nil && isBatchAPIPath(c.Request.URL.Path) {
+		p.batches.handle(c)
+		return
+	}
+
+	// WebSocket 升级请求（如 Realtime API）
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		p.handleWebSocket(c)
+		return
+	}
+
 	// 3. 检查是否是 models 请求
 	if c.Request.URL.Path == "/v1/models" {
 		p.handleModelsRequest(c)
 		return
 	}
 
-	// 4. 解析目标 URL
-	targetURL, err := url.Parse(p.config.TargetURL)
-	if err != nil {
-		p.logger.Error("Failed to parse target URL:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// 查询或删除本地保存的 Responses API 响应
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/responses/") {
+		p.handleStoredResponse(c)
 		return
 	}
 
-	// 5. 读取请求体
+	// multipart 请求（音频、图片接口）以流的方式转发，不读取完整的请求体
+	if boundary := multipartBoundary(c.Request); boundary != "" {
+		p.handleMultipart(c, boundary)
+		return
+	}
+
+	// 4. 读取请求体
 	reqBody, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		p.logger.Error("Failed to read request body:", err)
@@ -159,71 +353,228 @@ func (p *Proxy) handleRequest(c *gin.Context) {
 	}
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(reqBody))
 
-	// 6. 检查是否是流式请求
-	var isStreamRequest bool
-	var requestBody map[string]interface{}
-	if err := json.NewDecoder(bytes.NewBuffer(reqBody)).Decode(&requestBody); err == nil {
-		if stream, ok := requestBody["stream"].(bool); ok && stream {
-			isStreamRequest = true
+	// 5. 其它协议的请求转换为 chat/completions，响应在写出时转换回去
+	clientPath := c.Request.URL.Path
+	if fe := p.frontendFor(c.Request); fe != nil {
+		chatBody, translator, err := fe.prepare(c.Request, reqBody)
+		if err != nil {
+			p.logger.Error("Failed to convert request:", err)
+			fe.writeError(c, http.StatusBadRequest, err)
+			return
 		}
+		tw := newTranslateWriter(c.Writer, translator)
+		c.Writer = tw
+		defer func() {
+			// 上游中途失败时 forward 以 http.ErrAbortHandler 中断连接，不能再写出收尾事件让客户端以为响应已经完整
+			if err := recover(); err != nil {
+				panic(err)
+			}
+			tw.finish()
+		}()
+		// 响应需要解析后转换，不能让上游返回压缩的响应体；不设置时 Transport 会自动请求 gzip 并解压
+		c.Request.Header.Del("Accept-Encoding")
+
+		c.Request.URL.Path = "/v1/chat/completions"
+		reqBody = chatBody
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(reqBody))
+		c.Request.ContentLength = int64(len(reqBody))
 	}
 
-	// 7. 记录请求信息
-	p.logger.Info(fmt.Sprintf("Incoming request: %s %s", c.Request.Method, c.Request.URL.Path))
+	// 请求体只解析一次，插件通过共享的上下文读取和修改
+	pctx := pluginPKG.NewRequestContext(c.Request, reqBody)
+
+	// 记录请求的状态码、耗时和 token 用量，供仪表盘使用
+	tracked := p.traffic.track(c, clientPath)
+	tracked.route(contextMeta(pctx), "")
+	route := p.matchRoute(pctx, clientPath)
+	plugins := p.routePlugins(route)
+	defer func() {
+		if err := recover(); err != nil {
+			tracked.done(true)
+			panic(err)
+		}
+		tracked.done(false)
+	}()
+
+	// 6. 记录请求信息
+	p.logger.Info(fmt.Sprintf("Incoming request: %s %s (route %s)", c.Request.Method, c.Request.URL.Path, route.Name))
 	p.logger.Debug("Request headers:", c.Request.Header)
 	if len(reqBody) > 0 {
 		p.logger.Debug("Request body:", string(reqBody))
 	}
 
-	// 8. 创建反向代理
-	proxy := &httputil.ReverseProxy{
-		Director: func(req *http.Request) {
-			p.logger.Info("Proxying request to:", targetURL.String())
+	// 7. 执行路由的插件链
+	for _, plugin := range plugins {
+		if err := plugin.BeforeRequest(pctx); err != nil {
+			p.writePluginError(c, err)
+			return
+		}
+	}
 
-			req.URL.Scheme = targetURL.Scheme
-			req.URL.Host = targetURL.Host
-			req.Host = targetURL.Host
+	// 8. 检查是否有 Mock 直接响应
+	if mockResp := c.Request.Header.Get("X-Mock-Direct-Response"); mockResp != "" {
+		p.logger.Info("Using mock response directly")
+		c.Header("Content-Type", "application/json")
+		c.String(http.StatusOK, mockResp)
+		return
+	}
 
-			// 删除可能导致目标服务器添加 CORS 头部的请求头
-			req.Header.Del("Origin")
-			req.Header.Del("Referer")
+	// 9. 实现了 ResponsePlugin 的插件（如缓存）可以直接给出响应，或者记录本次响应
+	handled, records, err := p.respondFromPlugins(c, plugins, pctx)
+	if err != nil {
+		p.logger.Error("Plugin error:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if handled {
+		return
+	}
+	var recorder *recordWriter
+	if len(records) > 0 {
+		recorder = newRecordWriter(c.Writer)
+		c.Writer = recorder
+	}
 
-			// 修改路径处理逻辑
-			originalPath := req.URL.Path
-			if strings.HasPrefix(originalPath, "/v1/") {
-				newPath := strings.TrimPrefix(originalPath, "/v1")
-				req.URL.Path = path.Join(targetURL.Path, newPath)
-				p.logger.Debug("Rewritten path:", req.URL.Path)
+	// 10. 选择上游并改写请求，插件的修改在这里统一编码
+	reqBody = pctx.Body()
+	meta := contextMeta(pctx)
+	upstream := p.routeUpstream(route, meta.Model)
+	if upstream == nil {
+		p.logger.Error("No upstream available for model:", meta.Model)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no upstream available"})
+		return
+	}
+	tracked.route(meta, upstream.Config.Name)
+	upstream.applyHeaders(c.Request, c.GetHeader("Authorization"), p.logger)
+
+	// 11. 合并相同的并发请求，跟随者直接复用领头请求的响应
+	if p.coalescer != nil && c.Request.Method == http.MethodPost {
+		key := coalesceKey(c.Request, reqBody)
+		f, leader := p.coalescer.join(key)
+		if !leader {
+			p.followFlight(c, f)
+			return
+		}
+		finish := p.leadFlight(c, key, f)
+		defer func() {
+			// 转发中途失败时 forward 会 panic，跟随者同样中断
+			if err := recover(); err != nil {
+				finish(false)
+				panic(err)
 			}
+			finish(true)
+		}()
+	}
 
-			// 处理认证头
-			clientAuth := c.GetHeader("Authorization")
-			if clientAuth != "" && clientAuth != "Bearer" {
-				req.Header.Set("Authorization", clientAuth)
-				p.logger.Debug("Using client Authorization token")
-			} else if p.config.Headers["Authorization"] != "" {
-				req.Header.Set("Authorization", p.config.Headers["Authorization"])
-				p.logger.Debug("Using configured Authorization token")
-			} else {
-				p.logger.Error("No valid Authorization token available")
-			}
+	if c.Request.Method == http.MethodPost && c.Request.URL.Path == "/v1/embeddings" {
+		p.handleEmbeddings(c, upstream, reqBody)
+	} else {
+		if reqBody, err = upstream.adapter.RewriteRequest(c.Request, reqBody); err != nil {
+			p.logger.Error("Failed to rewrite request for upstream:", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = newMemBody(reqBody)
+		c.Request.ContentLength = int64(len(reqBody))
 
-			// 复制必要的 headers
-			copyHeaders := []string{
-				"Content-Type",
-				"Accept",
-				"Accept-Encoding",
-				"User-Agent",
-				"Content-Length",
-			}
-			for _, h := range copyHeaders {
-				if v := c.GetHeader(h); v != "" {
-					req.Header.Set(h, v)
-				}
-			}
+		// 12. 转发到上游
+		p.forward(c, upstream, meta, plugins)
+	}
+
+	// 13. 响应完整写出后交给插件记录，流式响应中途中断时 forward 会 panic，不会执行到这里
+	if recorder != nil {
+		resp := recorder.response()
+		for _, record := range records {
+			record(resp)
+		}
+	}
+}
+
+// respondFromPlugins 依次调用插件链中实现了 ResponsePlugin 的插件，任一插件写出响应后停止
+func (p *Proxy) respondFromPlugins(c *gin.Context, plugins []pluginPKG.Plugin, pctx *pluginPKG.RequestContext) (bool, []func(*pluginPKG.Response), error) {
+	var records []func(*pluginPKG.Response)
+	for _, plugin := range plugins {
+		rp, ok := plugin.(pluginPKG.ResponsePlugin)
+		if !ok {
+			continue
+		}
+		handled, record, err := rp.Respond(c.Writer, pctx)
+		if err != nil || handled {
+			return handled, nil, err
+		}
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+	return false, records, nil
+}
+
+// admit 依次调用插件链中实现了 AdmitPlugin 的插件，用于不经过 BeforeRequest 的 multipart 和 WebSocket 请求；
+// 拒绝时写出错误响应并返回 false
+func (p *Proxy) admit(c *gin.Context, plugins []pluginPKG.Plugin, pctx *pluginPKG.RequestContext) bool {
+	for _, plugin := range plugins {
+		ap, ok := plugin.(pluginPKG.AdmitPlugin)
+		if !ok {
+			continue
+		}
+		if err := ap.Admit(pctx); err != nil {
+			p.writePluginError(c, err)
+			return false
+		}
+	}
+	return true
+}
+
+// writePluginError 插件拒绝请求时以 RejectError 的状态码返回 OpenAI 格式的错误，其它错误返回 500
+func (p *Proxy) writePluginError(c *gin.Context, err error) {
+	p.logger.Error("Plugin error:", err)
+	var reject *pluginPKG.RejectError
+	if errors.As(err, &reject) {
+		c.JSON(reject.Status, openai.NewErrorResponse(reject.Status, reject.Message, reject.Code))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// forwardState 转发过程中每个请求的状态，通过 context 传给上游共享的反向代理
+type forwardState struct {
+	meta    requestMeta
+	start   time.Time
+	plugins []pluginPKG.Plugin // 处理响应的插件链
+}
+
+type forwardStateKey struct{}
+
+// forward 通过上游的反向代理把已经改写好的请求转发到上游，响应经过适配器转换后写回客户端
+func (p *Proxy) forward(c *gin.Context, upstream *Upstream, meta requestMeta, plugins []pluginPKG.Plugin) {
+	state := &forwardState{meta: meta, start: time.Now(), plugins: plugins}
+	req := c.Request.WithContext(context.WithValue(c.Request.Context(), forwardStateKey{}, state))
+	upstream.proxy.ServeHTTP(c.Writer, req)
+}
+
+// newReverseProxy 创建上游的反向代理，所有请求共享上游的连接池
+func (p *Proxy) newReverseProxy(upstream *Upstream) *httputil.ReverseProxy {
+	stateOf := func(req *http.Request) *forwardState {
+		if state, ok := req.Context().Value(forwardStateKey{}).(*forwardState); ok {
+			return state
+		}
+		return &forwardState{start: time.Now()}
+	}
+
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			p.logger.Info("Proxying request to:", upstream.target.String())
+
+			req.URL.Scheme = upstream.target.Scheme
+			req.URL.Host = upstream.target.Host
+			req.Host = upstream.target.Host
+
+			// 删除可能导致目标服务器添加 CORS 头部的请求头
+			req.Header.Del("Origin")
+			req.Header.Del("Referer")
 
 			// 设置 X-Forwarded-For
-			if clientIP, _, err := net.SplitHostPort(c.Request.RemoteAddr); err == nil {
+			if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
 				if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
 					clientIP = prior + ", " + clientIP
 				}
@@ -234,16 +585,8 @@ func (p *Proxy) handleRequest(c *gin.Context) {
 			p.logger.Debug("Final request headers:", req.Header)
 		},
 		Transport: &LoggingTransport{
-			Transport: &http.Transport{
-				ResponseHeaderTimeout: 30 * time.Second,
-				DisableKeepAlives:     false,
-				MaxIdleConnsPerHost:   100,
-				IdleConnTimeout:       90 * time.Second,
-				ForceAttemptHTTP2:     true,
-				MaxIdleConns:          100,
-				TLSHandshakeTimeout:   10 * time.Second,
-			},
-			Logger: p.logger,
+			Transport: upstream.transport,
+			Logger:    p.logger,
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			// 检查是否是正常的流式响应结束
@@ -266,14 +609,22 @@ func (p *Proxy) handleRequest(c *gin.Context) {
 
 			// 其他错误才记录
 			p.logger.Error("Proxy error:", err)
+			upstream.observe(0, err, time.Since(stateOf(r).start))
 			w.WriteHeader(http.StatusBadGateway)
 			w.Write([]byte(fmt.Sprintf("Proxy Error: %v", err)))
 		},
 		ModifyResponse: func(resp *http.Response) error {
+			state := stateOf(resp.Request)
 			p.logger.Info("Received response:", resp.Status)
+			upstream.observe(resp.StatusCode, nil, time.Since(state.start))
+
+			// 转换上游协议的响应
+			if err := upstream.adapter.ModifyResponse(resp); err != nil {
+				return err
+			}
 
 			// 处理流式响应
-			if isStreamRequest {
+			if state.meta.Stream {
 				// 设置 SSE headers
 				resp.Header.Set("Content-Type", "text/event-stream")
 				resp.Header.Set("Cache-Control", "no-cache")
@@ -282,6 +633,14 @@ func (p *Proxy) handleRequest(c *gin.Context) {
 				resp.Header.Set("X-Accel-Buffering", "no")
 			}
 
+			// 执行插件链的 AfterResponse
+			for _, plugin := range state.plugins {
+				if err := plugin.AfterResponse(resp); err != nil {
+					p.logger.Error("Plugin error:", err)
+					return err
+				}
+			}
+
 			// 确保删除所有可能的 CORS 头部
 			resp.Header.Del("Access-Control-Allow-Origin")
 			resp.Header.Del("Access-Control-Allow-Methods")
@@ -294,37 +653,40 @@ func (p *Proxy) handleRequest(c *gin.Context) {
 			return nil
 		},
 	}
+}
 
-	// 9. 执行请求前的插件
+// 处理 models 请求
+func (p *Proxy) handleModelsRequest(c *gin.Context) {
 	p.mu.RLock()
-	for _, plugin := range p.plugins {
-		if err := plugin.BeforeRequest(c.Request); err != nil {
-			p.logger.Error("Plugin error:", err)
-			p.mu.RUnlock()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-	}
+	models := append([]ModelInfo(nil), p.config.Models...)
 	p.mu.RUnlock()
 
-	// 10. 检查是否有 Mock 直接响应
-	if mockResp := c.Request.Header.Get("X-Mock-Direct-Response"); mockResp != "" {
-		p.logger.Info("Using mock response directly")
-		c.Header("Content-Type", "application/json")
-		c.String(http.StatusOK, mockResp)
-		return
+	// 合并支持列出模型的上游（如 Ollama）返回的模型
+	seen := make(map[string]bool, len(models))
+	for _, m := range models {
+		seen[m.ID] = true
+	}
+	for _, up := range p.upstreams {
+		lister, ok := up.adapter.(ModelLister)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		listed, err := lister.ListModels(ctx, &http.Client{Transport: up.transport})
+		cancel()
+		if err != nil {
+			p.logger.Error("Failed to list models from upstream", up.Config.Name, ":", err)
+			continue
+		}
+		for _, m := range listed {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+				models = append(models, m)
+			}
+		}
 	}
 
-	// 11. 执行代理转发
-	proxy.ServeHTTP(c.Writer, c.Request)
-
-	// 注意: 这里不会继续执行，因为 ServeHTTP 已经写入了响应
-}
-
-// 处理 models 请求
-func (p *Proxy) handleModelsRequest(c *gin.Context) {
 	// 如果配置中没有模型列表，使用默认值
-	models := p.config.Models
 	if len(models) == 0 {
 		models = []ModelInfo{
 			{
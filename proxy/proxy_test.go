@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestProxy 创建代理并通过本地 HTTP 服务提供，测试结束时关闭代理和服务
+func newTestProxy(t testing.TB, conf Config) (*Proxy, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if conf.HealthCheckSeconds == 0 {
+		conf.HealthCheckSeconds = -1
+	}
+	p, err := NewCursorProxy(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p.router())
+	t.Cleanup(func() {
+		srv.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = p.Shutdown(ctx)
+	})
+	return p, srv
+}
+
+// postJSON 发送 JSON 请求，返回状态码和响应体
+func postJSON(t testing.TB, url, body string, header http.Header) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// sseData 返回 SSE 响应中每个事件的 data
+func sseData(body []byte) []string {
+	var out []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+			out = append(out, strings.TrimSpace(data))
+		}
+	}
+	return out
+}
+
+// decodeJSON 解析 JSON，失败时结束测试
+func decodeJSON(t testing.TB, data []byte, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"strings"
+
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+// RouteConfig 路由规则：按路径、方法、模型和请求头匹配请求，为匹配的请求指定插件链和上游
+//
+// 模式中的 * 匹配任意字符（包括 /），条件为空时不限制；按配置顺序匹配，第一个匹配的路由生效，
+// 配置的路由都不匹配时使用内置路由（chat 执行所有插件，embeddings、音频和图片接口不执行 Mock，其它路径不执行 Mock 和模型映射）
+type RouteConfig struct {
+	Name    string            `json:"name"`              // 路由名称，用于日志
+	Paths   []string          `json:"paths,omitempty"`   // 路径模式，如 "*/chat/completions"，匹配客户端请求的路径或转换后的路径（如 /v1/messages 转换为 /v1/chat/completions）
+	Methods []string          `json:"methods,omitempty"` // 请求方法
+	Models  []string          `json:"models,omitempty"`  // 模型模式，匹配客户端请求的模型（映射之前），如 "gpt-4o*"
+	Headers map[string]string `json:"headers,omitempty"` // 请求头的值模式，所有请求头都匹配时才匹配
+
+	Plugins     []string `json:"plugins,omitempty"`      // 按顺序执行的插件名称，为空时按全局顺序执行所有启用的插件
+	SkipPlugins []string `json:"skip_plugins,omitempty"` // 不执行的插件名称模式，"*" 表示不执行任何插件
+	Upstream    string   `json:"upstream,omitempty"`     // 转发到的上游名称，为空时按模型选择上游
+}
+
+// defaultRoutes 内置路由，保持插件只处理各自关心的接口
+var defaultRoutes = []RouteConfig{
+	{Name: "chat", Paths: []string{"*/chat/completions*"}},
+	{Name: "embeddings", Paths: []string{"*/embeddings*"}, SkipPlugins: []string{"MockPlugin"}},
+	{Name: "audio", Paths: []string{"*/audio/*"}, SkipPlugins: []string{"MockPlugin"}},
+	{Name: "images", Paths: []string{"*/images/*"}, SkipPlugins: []string{"MockPlugin"}},
+	{Name: "default", SkipPlugins: []string{"MockPlugin", "ModelMapPlugin"}},
+}
+
+// matches 判断请求是否匹配路由，clientPath 是客户端请求的路径（去掉 PathPrefix）
+func (r *RouteConfig) matches(ctx *pluginPKG.RequestContext, clientPath string) bool {
+	req := ctx.Request
+	if len(r.Paths) > 0 && !matchAny(r.Paths, clientPath) && !matchAny(r.Paths, req.URL.Path) {
+		return false
+	}
+	if len(r.Methods) > 0 && !containsFold(r.Methods, req.Method) {
+		return false
+	}
+	if len(r.Models) > 0 && !matchAny(r.Models, ctx.Model()) {
+		return false
+	}
+	for name, pattern := range r.Headers {
+		if !matchGlob(pattern, req.Header.Get(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRoute 返回请求匹配的路由，内置路由的最后一条匹配所有请求
+func (p *Proxy) matchRoute(ctx *pluginPKG.RequestContext, clientPath string) *RouteConfig {
+	for i := range p.config.Routes {
+		if p.config.Routes[i].matches(ctx, clientPath) {
+			return &p.config.Routes[i]
+		}
+	}
+	for i := range defaultRoutes {
+		if defaultRoutes[i].matches(ctx, clientPath) {
+			return &defaultRoutes[i]
+		}
+	}
+	return &defaultRoutes[len(defaultRoutes)-1]
+}
+
+// routePlugins 按执行顺序返回路由中启用的插件
+func (p *Proxy) routePlugins(route *RouteConfig) []pluginPKG.Plugin {
+	active := p.activePlugins()
+	var chain []pluginPKG.Plugin
+	if len(route.Plugins) > 0 {
+		byName := make(map[string]pluginPKG.Plugin, len(active))
+		for _, plugin := range active {
+			byName[pluginName(plugin)] = plugin
+		}
+		for _, name := range route.Plugins {
+			if plugin, ok := byName[name]; ok {
+				chain = append(chain, plugin)
+			}
+		}
+	} else {
+		chain = active
+	}
+	if len(route.SkipPlugins) == 0 {
+		return chain
+	}
+	out := chain[:0:0]
+	for _, plugin := range chain {
+		if !matchAny(route.SkipPlugins, pluginName(plugin)) {
+			out = append(out, plugin)
+		}
+	}
+	return out
+}
+
+// routeUpstream 返回路由指定的上游，未指定时按模型选择
+func (p *Proxy) routeUpstream(route *RouteConfig, model string) *Upstream {
+	if route.Upstream == "" {
+		return p.selectUpstream(model)
+	}
+	for _, up := range p.upstreams {
+		if up.Config.Name == route.Upstream {
+			return up
+		}
+	}
+	return nil
+}
+
+// validateRoutes 检查路由引用的上游是否存在
+func (p *Proxy) validateRoutes() {
+	for _, route := range p.config.Routes {
+		if route.Upstream != "" && p.routeUpstream(&route, "") == nil {
+			p.logger.Error("Route", route.Name, "refers to unknown upstream:", route.Upstream)
+		}
+	}
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob 匹配只包含 * 通配符的模式，* 匹配任意字符（包括 /）
+func matchGlob(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// recordingUpstream 记录收到的请求路径、multipart 表单中的 model 字段和 JSON 请求体的上游，WebSocket 请求会被接受
+type recordingUpstream struct {
+	*httptest.Server
+	mu     sync.Mutex
+	paths  []string
+	models []string
+	bodies []string
+}
+
+func newRecordingUpstream(t *testing.T) *recordingUpstream {
+	u := &recordingUpstream{}
+	upgrader := websocket.Upgrader{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.paths = append(u.paths, r.URL.Path)
+		u.mu.Unlock()
+		if websocket.IsWebSocketUpgrade(r) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err == nil {
+				u.mu.Lock()
+				u.models = append(u.models, r.FormValue("model"))
+				u.mu.Unlock()
+			}
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"text":"ok"}`)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		u.mu.Lock()
+		u.bodies = append(u.bodies, string(body))
+		u.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","created":0,"model":"gpt-4o",`+
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],`+
+			`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *recordingUpstream) seen() ([]string, []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.paths...), append([]string(nil), u.models...)
+}
+
+func (u *recordingUpstream) requestBodies() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.bodies...)
+}
+
+// postForm 发送带文件的 multipart 表单，model 在文件之前
+func postForm(t *testing.T, url, model string) int {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("model", model)
+	fw, _ := mw.CreateFormFile("file", "audio.wav")
+	fw.Write([]byte("RIFF0000WAVE"))
+	mw.Close()
+	resp, err := http.Post(url, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRouteMultipartModelMap(t *testing.T) {
+	up := newRecordingUpstream(t)
+	_, srv := newTestProxy(t, Config{TargetURL: up.URL, ModelMappings: map[string]string{"cursor-whisper": "whisper-1"}})
+
+	// 内置的 audio 路由执行模型映射，其它路径不执行
+	for _, path := range []string{"/v1/audio/transcriptions", "/v1/uploads"} {
+		if status := postForm(t, srv.URL+path, "cursor-whisper"); status != http.StatusOK {
+			t.Fatalf("%s: status %d", path, status)
+		}
+	}
+	_, models := up.seen()
+	if len(models) != 2 || models[0] != "whisper-1" || models[1] != "cursor-whisper" {
+		t.Errorf("upstream models %q, want mapped only for audio", models)
+	}
+}
+
+func TestRouteUpstreamForMultipartAndWebSocket(t *testing.T) {
+	a, b := newRecordingUpstream(t), newRecordingUpstream(t)
+	_, srv := newTestProxy(t, Config{
+		Upstreams: []UpstreamConfig{{Name: "a", TargetURL: a.URL}, {Name: "b", TargetURL: b.URL}},
+		Routes: []RouteConfig{
+			{Name: "whisper", Paths: []string{"*/audio/*"}, Models: []string{"whisper*"}, Upstream: "b"},
+			{Name: "realtime", Paths: []string{"/v1/realtime"}, Upstream: "b"},
+		},
+	})
+
+	if status := postForm(t, srv.URL+"/v1/audio/transcriptions", "whisper-1"); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	if status := postForm(t, srv.URL+"/v1/audio/transcriptions", "gpt-4o-transcribe"); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/realtime?model=gpt-4o-realtime", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+
+	_, aModels := a.seen()
+	bPaths, bModels := b.seen()
+	if len(aModels) != 1 || aModels[0] != "gpt-4o-transcribe" {
+		t.Errorf("upstream a got %q", aModels)
+	}
+	if len(bModels) != 1 || bModels[0] != "whisper-1" {
+		t.Errorf("upstream b got %q", bModels)
+	}
+	if len(bPaths) != 2 || bPaths[1] != "/realtime" {
+		t.Errorf("upstream b paths %q, want the realtime session", bPaths)
+	}
+}
+
+func TestRouteMatchesClientPath(t *testing.T) {
+	a, b := newRecordingUpstream(t), newRecordingUpstream(t)
+	_, srv := newTestProxy(t, Config{
+		Upstreams: []UpstreamConfig{{Name: "a", TargetURL: a.URL}, {Name: "b", TargetURL: b.URL}},
+		Routes:    []RouteConfig{{Name: "anthropic", Paths: []string{"/v1/messages"}, Upstream: "b"}},
+	})
+
+	// Anthropic 请求转换为 chat/completions 后仍按客户端路径匹配
+	status, body := postJSON(t, srv.URL+"/v1/messages",
+		`{"model":"gpt-4o","max_tokens":16,"messages":[{"role":"user","content":"hello"}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("messages status %d: %s", status, body)
+	}
+	status, body = postJSON(t, srv.URL+"/v1/chat/completions",
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("chat status %d: %s", status, body)
+	}
+
+	aPaths, _ := a.seen()
+	bPaths, _ := b.seen()
+	if len(bPaths) != 1 || len(aPaths) != 1 {
+		t.Errorf("upstream a got %q, b got %q; want the Messages request on b", aPaths, bPaths)
+	}
+}
+
+func TestTrafficMultipartAndWebSocket(t *testing.T) {
+	up := newRecordingUpstream(t)
+	p, srv := newTestProxy(t, Config{Upstreams: []UpstreamConfig{{Name: "a", TargetURL: up.URL}}})
+
+	if status := postForm(t, srv.URL+"/v1/audio/transcriptions", "whisper-1"); status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/realtime?model=gpt-4o-realtime", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+
+	// 会话在两端都关闭后才记录
+	var records []TrafficRecord
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if records = p.TrafficSnapshot().Requests; len(records) == 2 {
+			break
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("traffic records %+v, want multipart and WebSocket", records)
+	}
+	want := []TrafficRecord{
+		{Path: "/v1/realtime", Model: "gpt-4o-realtime", Status: http.StatusSwitchingProtocols},
+		{Path: "/v1/audio/transcriptions", Model: "whisper-1", Status: http.StatusOK},
+	}
+	for i, rec := range records {
+		if rec.Path != want[i].Path || rec.Model != want[i].Model || rec.Status != want[i].Status || rec.Upstream != "a" {
+			t.Errorf("records[%d] = %+v", i, rec)
+		}
+	}
+}
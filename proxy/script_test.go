@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// writeScript 写入脚本文件，修改时间推后一秒，保证重新加载时能发现修改
+func writeScript(t *testing.T, path, src string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err == nil {
+		later := info.ModTime().Add(time.Second)
+		_ = os.Chtimes(path, later, later)
+	}
+}
+
+// newScriptProxy 创建只注册了脚本插件的代理，config 是插件配置中 path 之外的字段，返回代理地址和脚本路径
+func newScriptProxy(t *testing.T, upstream, src, config string) (string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rewrite.star")
+	writeScript(t, path, src)
+	raw, _ := json.Marshal(path)
+	conf := `{"path":` + string(raw)
+	if config != "" {
+		conf += "," + config
+	}
+	conf += "}"
+	_, srv := newTestProxy(t, Config{
+		TargetURL: upstream,
+		Plugins:   []PluginSpec{{Type: "script", Config: json.RawMessage(conf)}},
+	})
+	return srv.URL, path
+}
+
+const scriptChat = `{"model":"cursor-small","messages":[{"role":"user","content":"hi"}]}`
+
+func TestScriptPlugin(t *testing.T) {
+	src := `
+def before_request(req):
+    if req["model"].startswith("cursor-"):
+        req["body"]["model"] = "gpt-4o-mini"
+    req["headers"]["X-Team"] = "infra"
+
+def after_response(resp):
+    resp["body"]["choices"][0]["message"]["content"] = resp["request"]["path"]
+    resp["headers"]["X-Script"] = "done"
+
+def on_chunk(chunk):
+    delta = chunk["choices"][0]["delta"]
+    if delta.get("content") == "lo":
+        return False
+    delta["content"] = delta["content"].upper()
+    return chunk
+`
+	f := newFakeChat(t, func(w http.ResponseWriter, r *http.Request, req openai.ChatCompletionRequest) {
+		if req.Stream {
+			replyStream(chunkHel, chunkLo)(w, r, req)
+			return
+		}
+		replyJSON(http.StatusOK, chatHello)(w, r, req)
+	})
+	url, _ := newScriptProxy(t, f.URL, src, "")
+
+	resp, err := http.Post(url+"/v1/chat/completions", "application/json", strings.NewReader(scriptChat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var completion openai.ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		t.Fatal(err)
+	}
+	req, header := f.last()
+	if req.Model != "gpt-4o-mini" || header.Get("X-Team") != "infra" {
+		t.Errorf("request not rewritten: model %s, headers %v", req.Model, header)
+	}
+	if resp.Header.Get("X-Script") != "done" || completion.Choices[0].Message.Content != "/chat/completions" {
+		t.Errorf("response not rewritten: %+v, headers %v", completion, resp.Header)
+	}
+
+	// 流式响应逐个事件交给 on_chunk，返回 False 的事件被丢弃
+	status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	events := sseData(body)
+	if len(events) != 2 || !strings.Contains(events[0], `"content":"HEL"`) || events[1] != sseDone {
+		t.Errorf("unexpected events: %q", events)
+	}
+}
+
+func TestScriptErrorLineNumbers(t *testing.T) {
+	// 语法错误在创建代理时返回
+	path := filepath.Join(t.TempDir(), "broken.star")
+	writeScript(t, path, "def before_request(req):\n    pass\n\nx = 1 +* 2\n")
+	raw, _ := json.Marshal(path)
+	_, err := NewCursorProxy(Config{Plugins: []PluginSpec{{Type: "script", Config: json.RawMessage(`{"path":` + string(raw) + `}`)}}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "broken.star:4:") {
+		t.Errorf("syntax error %v, want the file name and line 4", err)
+	}
+
+	// 运行错误带有调用栈，fail_open 时请求继续
+	src := "def before_request(req):\n    model = req[\"model\"]\n    req[\"body\"][\"missing\"][\"x\"] = model\n"
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	tests := []struct {
+		name   string
+		config string
+		status int
+	}{
+		{"fail closed", "", http.StatusInternalServerError},
+		{"fail open", `"fail_open":true`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, _ := newScriptProxy(t, f.URL, src, tt.config)
+			status, body := postJSON(t, url+"/v1/chat/completions", scriptChat, nil)
+			if status != tt.status {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if status != http.StatusOK && (!strings.Contains(string(body), "rewrite.star:3:") || !strings.Contains(string(body), "before_request")) {
+				t.Errorf("error %s, want the backtrace with line 3", body)
+			}
+		})
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	src := "def before_request(req):\n    while True:\n        pass\n"
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	tests := []struct {
+		name   string
+		config string
+		reason string
+	}{
+		{"max steps", `"max_steps":1000,"timeout_ms":5000`, "too many steps"},
+		{"timeout", `"max_steps":100000000000,"timeout_ms":20`, "timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, _ := newScriptProxy(t, f.URL, src, tt.config)
+			start := time.Now()
+			status, body := postJSON(t, url+"/v1/chat/completions", scriptChat, nil)
+			if status != http.StatusInternalServerError || !strings.Contains(string(body), tt.reason) {
+				t.Errorf("status %d: %s, want %q", status, body, tt.reason)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("script ran for %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestScriptReload(t *testing.T) {
+	version := func(v string) string {
+		return "def before_request(req):\n    req[\"headers\"][\"X-Script-Version\"] = \"" + v + "\"\n"
+	}
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	url, path := newScriptProxy(t, f.URL, version("v1"), `"reload_seconds":1`)
+
+	seen := func() string {
+		t.Helper()
+		if status, body := postJSON(t, url+"/v1/chat/completions", scriptChat, nil); status != http.StatusOK {
+			t.Fatalf("status %d: %s", status, body)
+		}
+		_, header := f.last()
+		return header.Get("X-Script-Version")
+	}
+	if v := seen(); v != "v1" {
+		t.Fatalf("version %q, want v1", v)
+	}
+
+	// 加载失败时继续使用之前的版本
+	writeScript(t, path, "def before_request(req)\n")
+	time.Sleep(2500 * time.Millisecond)
+	if v := seen(); v != "v1" {
+		t.Fatalf("version %q after a broken edit, want v1", v)
+	}
+
+	// 修复后重新加载
+	writeScript(t, path, version("v2"))
+	deadline := time.Now().Add(3 * time.Second)
+	for seen() != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatal("script not reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
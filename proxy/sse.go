@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// sseDone OpenAI 流式响应的结束标记
+const sseDone = "[DONE]"
+
+// sseEvent SSE 事件
+type sseEvent struct {
+	Event string
+	Data  []byte
+}
+
+// newJSONEvent 创建数据为 JSON 的 SSE 事件
+func newJSONEvent(event string, v interface{}) sseEvent {
+	data, _ := json.Marshal(v)
+	return sseEvent{Event: event, Data: data}
+}
+
+// writeSSE 写出一个 SSE 事件
+func writeSSE(w io.Writer, ev sseEvent) error {
+	var buf bytes.Buffer
+	if ev.Event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(ev.Event)
+		buf.WriteByte('\n')
+	}
+	for _, line := range bytes.Split(ev.Data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// parseSSEBlock 解析一个以空行结束的 SSE 事件块，没有数据的块（如注释）返回 false
+func parseSSEBlock(block []byte) (sseEvent, bool) {
+	var ev sseEvent
+	var data [][]byte
+	hasData := false
+	for _, line := range bytes.Split(block, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(line) == 0 || line[0] == ':' {
+			continue
+		}
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "event":
+			ev.Event = string(value)
+		case "data":
+			hasData = true
+			data = append(data, value)
+		}
+	}
+	ev.Data = bytes.Join(data, []byte("\n"))
+	return ev, hasData
+}
+
+// sseParser 增量解析分段写入的 SSE 数据
+type sseParser struct {
+	buf []byte
+}
+
+// Feed 写入数据，返回已经完整的事件
+func (s *sseParser) Feed(data []byte) []sseEvent {
+	s.buf = append(s.buf, data...)
+	var events []sseEvent
+	for {
+		idx, size := sseBoundary(s.buf)
+		if idx < 0 {
+			return events
+		}
+		if ev, ok := parseSSEBlock(s.buf[:idx]); ok {
+			events = append(events, ev)
+		}
+		s.buf = s.buf[idx+size:]
+	}
+}
+
+// Flush 返回缓冲中剩余的不完整事件
+func (s *sseParser) Flush() []sseEvent {
+	defer func() { s.buf = nil }()
+	if ev, ok := parseSSEBlock(s.buf); ok {
+		return []sseEvent{ev}
+	}
+	return nil
+}
+
+// sseBoundary 查找事件之间的空行，返回位置和空行长度
+func sseBoundary(buf []byte) (int, int) {
+	if idx := bytes.Index(buf, []byte("\n\n")); idx >= 0 {
+		if crlf := bytes.Index(buf, []byte("\r\n\r\n")); crlf >= 0 && crlf < idx {
+			return crlf, 4
+		}
+		return idx, 2
+	}
+	if idx := bytes.Index(buf, []byte("\r\n\r\n")); idx >= 0 {
+		return idx, 4
+	}
+	return -1, 0
+}
+
+// sseReader 从流中逐个读取 SSE 事件
+type sseReader struct {
+	r *bufio.Reader
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Next 读取下一个事件，流结束时返回 io.EOF
+func (s *sseReader) Next() (sseEvent, error) {
+	var block bytes.Buffer
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if len(line) > 0 && strings.TrimSpace(string(line)) != "" {
+			block.Write(line)
+		} else if block.Len() > 0 {
+			if ev, ok := parseSSEBlock(block.Bytes()); ok {
+				return ev, nil
+			}
+			block.Reset()
+		}
+		if err != nil {
+			if ev, ok := parseSSEBlock(block.Bytes()); ok {
+				return ev, nil
+			}
+			return sseEvent{}, err
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+const (
+	defaultTrafficRecords = 200     // 默认保留的最近请求数量
+	trafficMinutes        = 60      // 按分钟统计的时间窗口
+	trafficMaxBody        = 1 << 20 // 非流式响应最多读取的字节数，用于解析 usage
+)
+
+// TrafficRecord 一次请求的记录
+type TrafficRecord struct {
+	ID               int64     `json:"id"`
+	Time             time.Time `json:"time"`
+	Method           string    `json:"method"`
+	Path             string    `json:"path"`
+	Model            string    `json:"model"`
+	Upstream         string    `json:"upstream"`
+	Key              string    `json:"key"` // 脱敏后的客户端密钥
+	Stream           bool      `json:"stream"`
+	Status           int       `json:"status"`
+	LatencyMS        int64     `json:"latency_ms"`
+	TTFTMS           int64     `json:"ttft_ms"` // 写出第一个字节的耗时
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cached           bool      `json:"cached"`
+	Coalesced        bool      `json:"coalesced"`
+	Aborted          bool      `json:"aborted"` // 响应中途中断
+}
+
+// UsageStats 按模型或密钥汇总的用量
+type UsageStats struct {
+	Requests         int64 `json:"requests"`
+	Errors           int64 `json:"errors"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// TrafficMinute 一分钟内的请求统计
+type TrafficMinute struct {
+	Time     time.Time `json:"time"`
+	Requests int64     `json:"requests"`
+	Errors   int64     `json:"errors"`
+	Tokens   int64     `json:"tokens"`
+}
+
+// TrafficSnapshot 流量统计的快照
+type TrafficSnapshot struct {
+	Requests []TrafficRecord       `json:"requests"` // 最近的请求，按时间倒序
+	Models   map[string]UsageStats `json:"models"`
+	Keys     map[string]UsageStats `json:"keys"`
+	Minutes  []TrafficMinute       `json:"minutes"` // 最近一小时每分钟的统计，按时间正序
+}
+
+// trafficMonitor 在内存中记录最近的请求和用量，并推送给订阅者（仪表盘）
+type trafficMonitor struct {
+	mu          sync.Mutex
+	seq         int64
+	records     []TrafficRecord // 环形缓冲
+	next        int
+	full        bool
+	models      map[string]*UsageStats
+	keys        map[string]*UsageStats
+	minutes     [trafficMinutes]TrafficMinute
+	subscribers map[chan TrafficRecord]struct{}
+}
+
+func newTrafficMonitor(size int) *trafficMonitor {
+	if size <= 0 {
+		size = defaultTrafficRecords
+	}
+	return &trafficMonitor{
+		records:     make([]TrafficRecord, size),
+		models:      make(map[string]*UsageStats),
+		keys:        make(map[string]*UsageStats),
+		subscribers: make(map[chan TrafficRecord]struct{}),
+	}
+}
+
+// add 保存一条记录并推送给订阅者，订阅者处理不过来时丢弃
+func (m *trafficMonitor) add(rec TrafficRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	rec.ID = m.seq
+	m.records[m.next] = rec
+	m.next = (m.next + 1) % len(m.records)
+	if m.next == 0 {
+		m.full = true
+	}
+
+	failed := rec.Status >= http.StatusBadRequest || rec.Aborted
+	for _, usage := range []*UsageStats{usageOf(m.models, rec.Model), usageOf(m.keys, rec.Key)} {
+		usage.Requests++
+		if failed {
+			usage.Errors++
+		}
+		usage.PromptTokens += int64(rec.PromptTokens)
+		usage.CompletionTokens += int64(rec.CompletionTokens)
+	}
+
+	minute := rec.Time.Truncate(time.Minute)
+	bucket := &m.minutes[minute.Unix()/60%trafficMinutes]
+	if !bucket.Time.Equal(minute) {
+		*bucket = TrafficMinute{Time: minute}
+	}
+	bucket.Requests++
+	if failed {
+		bucket.Errors++
+	}
+	bucket.Tokens += int64(rec.PromptTokens + rec.CompletionTokens)
+
+	for ch := range m.subscribers {
+		select {
+		case ch <- rec:
+		default:
+		}
+	}
+}
+
+func usageOf(stats map[string]*UsageStats, name string) *UsageStats {
+	usage, ok := stats[name]
+	if !ok {
+		usage = &UsageStats{}
+		stats[name] = usage
+	}
+	return usage
+}
+
+// subscribe 订阅新的请求记录，返回的函数用于取消订阅
+func (m *trafficMonitor) subscribe() (<-chan TrafficRecord, func()) {
+	ch := make(chan TrafficRecord, 64)
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+	return ch, func() {
+		m.mu.Lock()
+		delete(m.subscribers, ch)
+		m.mu.Unlock()
+	}
+}
+
+// snapshot 返回当前的统计，withRequests 为 false 时不包含请求列表
+func (m *trafficMonitor) snapshot(withRequests bool) TrafficSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snap := TrafficSnapshot{
+		Models: make(map[string]UsageStats, len(m.models)),
+		Keys:   make(map[string]UsageStats, len(m.keys)),
+	}
+	if withRequests {
+		n := m.next
+		if m.full {
+			n = len(m.records)
+		}
+		snap.Requests = make([]TrafficRecord, 0, n)
+		for i := 1; i <= n; i++ {
+			snap.Requests = append(snap.Requests, m.records[(m.next-i+len(m.records))%len(m.records)])
+		}
+	}
+	for name, usage := range m.models {
+		snap.Models[name] = *usage
+	}
+	for name, usage := range m.keys {
+		snap.Keys[name] = *usage
+	}
+	since := time.Now().Truncate(time.Minute).Add(-(trafficMinutes - 1) * time.Minute)
+	for _, bucket := range m.minutes {
+		if !bucket.Time.Before(since) {
+			snap.Minutes = append(snap.Minutes, bucket)
+		}
+	}
+	sort.Slice(snap.Minutes, func(i, j int) bool { return snap.Minutes[i].Time.Before(snap.Minutes[j].Time) })
+	return snap
+}
+
+// TrafficSnapshot 返回最近的请求和按模型、密钥、分钟汇总的用量
+func (p *Proxy) TrafficSnapshot() TrafficSnapshot {
+	return p.traffic.snapshot(true)
+}
+
+// clientKey 返回客户端的 key，依次读取 Authorization、api-key 和 x-api-key
+func clientKey(h http.Header) string {
+	key := strings.TrimPrefix(h.Get("Authorization"), "Bearer ")
+	if key == "" {
+		key = h.Get("api-key")
+	}
+	if key == "" {
+		key = h.Get("x-api-key")
+	}
+	return key
+}
+
+// maskKey 返回脱敏后的客户端密钥，只保留前 3 位和后 4 位
+func maskKey(h http.Header) string {
+	key := clientKey(h)
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:3] + "..." + key[len(key)-4:]
+}
+
+// trafficWriter 包装 gin.ResponseWriter，记录状态码、首字节耗时和响应中的 usage
+type trafficWriter struct {
+	gin.ResponseWriter
+	monitor   *trafficMonitor
+	record    TrafficRecord
+	start     time.Time
+	firstByte time.Time
+	stream    bool
+	parser    sseParser
+	body      bytes.Buffer
+}
+
+// track 开始记录请求，path 为客户端请求的路径（转换协议之前），响应写出完成后调用 done
+func (m *trafficMonitor) track(c *gin.Context, path string) *trafficWriter {
+	w := &trafficWriter{
+		ResponseWriter: c.Writer,
+		monitor:        m,
+		start:          time.Now(),
+		record: TrafficRecord{
+			Method: c.Request.Method,
+			Path:   path,
+			Key:    maskKey(c.Request.Header),
+			Status: http.StatusOK,
+		},
+	}
+	c.Writer = w
+	return w
+}
+
+// route 记录请求的模型、是否流式和选择的上游
+func (w *trafficWriter) route(meta requestMeta, upstream string) {
+	w.record.Model = meta.Model
+	w.record.Stream = meta.Stream
+	w.record.Upstream = upstream
+}
+
+// WriteHeader 实现 http.ResponseWriter
+func (w *trafficWriter) WriteHeader(code int) {
+	w.record.Status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write 实现 io.Writer
+func (w *trafficWriter) Write(data []byte) (int, error) {
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+		w.stream = strings.Contains(w.Header().Get("Content-Type"), "text/event-stream")
+	}
+	if w.stream {
+		for _, ev := range w.parser.Feed(data) {
+			w.observeUsage(ev.Data)
+		}
+	} else if w.body.Len() < trafficMaxBody {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString 实现 io.StringWriter
+func (w *trafficWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// observeUsage 从响应（或流式响应的一个分片）中读取 token 用量
+func (w *trafficWriter) observeUsage(data []byte) {
+	if !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var v struct {
+		Usage *struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			InputTokens      int `json:"input_tokens"`
+			OutputTokens     int `json:"output_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil || v.Usage == nil {
+		return
+	}
+	w.record.PromptTokens = max(w.record.PromptTokens, v.Usage.PromptTokens+v.Usage.InputTokens)
+	w.record.CompletionTokens = max(w.record.CompletionTokens, v.Usage.CompletionTokens+v.Usage.OutputTokens)
+}
+
+// done 请求结束，保存记录
+func (w *trafficWriter) done(aborted bool) {
+	now := time.Now()
+	if !w.stream {
+		w.observeUsage(w.body.Bytes())
+	}
+	header := w.Header()
+	w.record.Time = w.start
+	w.record.LatencyMS = now.Sub(w.start).Milliseconds()
+	if !w.firstByte.IsZero() {
+		w.record.TTFTMS = w.firstByte.Sub(w.start).Milliseconds()
+	}
+	w.record.Cached = header.Get("X-Cache") == pluginPKG.CacheHit || header.Get("X-Semantic-Cache") == pluginPKG.CacheHit
+	w.record.Coalesced = header.Get("X-Coalesced") == "true"
+	w.record.Aborted = aborted
+	w.monitor.add(w.record)
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TransportConfig 转发到上游使用的连接配置，每个上游持有一个长期复用的连接池，未配置的字段使用默认值
+type TransportConfig struct {
+	DialTimeoutSeconds           int `json:"dial_timeout_seconds,omitempty"`            // 建立 TCP 连接的超时，默认 10
+	TLSHandshakeTimeoutSeconds   int `json:"tls_handshake_timeout_seconds,omitempty"`   // TLS 握手的超时，默认 10
+	ResponseHeaderTimeoutSeconds int `json:"response_header_timeout_seconds,omitempty"` // 等待上游响应头的超时，默认 30，小于 0 表示不限制
+	IdleConnTimeoutSeconds       int `json:"idle_conn_timeout_seconds,omitempty"`       // 空闲连接保留的时间，默认 90
+
+	MaxIdleConns        int  `json:"max_idle_conns,omitempty"`          // 空闲连接总数上限，默认 100
+	MaxIdleConnsPerHost int  `json:"max_idle_conns_per_host,omitempty"` // 每个 host 的空闲连接上限，默认 100
+	MaxConnsPerHost     int  `json:"max_conns_per_host,omitempty"`      // 每个 host 的连接数上限，0 表示不限制
+	DisableKeepAlives   bool `json:"disable_keep_alives,omitempty"`     // 每个请求使用新的连接
+	DisableHTTP2        bool `json:"disable_http2,omitempty"`           // 只使用 HTTP/1.1
+
+	ProxyURL string `json:"proxy_url,omitempty"` // 访问上游使用的代理（http、https、socks5），为 "env" 时使用 HTTPS_PROXY 等环境变量，为空时直连
+
+	CAFile             string `json:"ca_file,omitempty"`              // 额外信任的 CA 证书（PEM），用于自签名证书的上游
+	CertFile           string `json:"cert_file,omitempty"`            // 客户端证书（PEM），用于要求 mTLS 的上游
+	KeyFile            string `json:"key_file,omitempty"`             // 客户端证书的私钥
+	ServerName         string `json:"server_name,omitempty"`          // 校验证书使用的服务器名称（SNI），为空时使用上游地址的 host
+	MinTLSVersion      string `json:"min_tls_version,omitempty"`      // 最低 TLS 版本："1.2"（默认）或 "1.3"
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"` // 不校验上游证书，仅用于测试
+}
+
+// seconds 返回配置的秒数，未配置时使用默认值，小于 0 时返回 0（不限制）
+func seconds(v int, def time.Duration) time.Duration {
+	switch {
+	case v > 0:
+		return time.Duration(v) * time.Second
+	case v < 0:
+		return 0
+	}
+	return def
+}
+
+func orDefault(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// NewTransport 根据配置创建转发到上游使用的传输层，cfg 为 nil 时使用默认配置
+func NewTransport(cfg *TransportConfig) (*http.Transport, error) {
+	if cfg == nil {
+		cfg = &TransportConfig{}
+	}
+	dialer := &net.Dialer{
+		Timeout:   seconds(cfg.DialTimeoutSeconds, 10*time.Second),
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   seconds(cfg.TLSHandshakeTimeoutSeconds, 10*time.Second),
+		ResponseHeaderTimeout: seconds(cfg.ResponseHeaderTimeoutSeconds, 30*time.Second),
+		IdleConnTimeout:       seconds(cfg.IdleConnTimeoutSeconds, 90*time.Second),
+		MaxIdleConns:          orDefault(cfg.MaxIdleConns, 100),
+		MaxIdleConnsPerHost:   orDefault(cfg.MaxIdleConnsPerHost, 100),
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		ForceAttemptHTTP2:     !cfg.DisableHTTP2,
+	}
+	if cfg.DisableHTTP2 {
+		// 非 nil 的空 map 关闭 HTTP/2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	switch cfg.ProxyURL {
+	case "":
+	case "env":
+		t.Proxy = http.ProxyFromEnvironment
+	default:
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy url: %w", err)
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+	return t, nil
+}
+
+// tlsConfig 根据配置创建 TLS 配置，没有配置 TLS 相关字段时返回 nil
+func (cfg *TransportConfig) tlsConfig() (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.ServerName == "" && cfg.MinTLSVersion == "" && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+	conf := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	switch cfg.MinTLSVersion {
+	case "", "1.2":
+	case "1.3":
+		conf.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported min_tls_version %q", cfg.MinTLSVersion)
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file %s", cfg.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 对比每个请求新建传输层与复用上游连接池的转发开销，以及大请求体经过插件的开销：
+//
+//	go test -run '^$' -bench BenchmarkForward ./proxy
+
+const benchChatBody = `{"model":"gpt-4o","messages":[{"role":"user","content":"hello"}]}`
+
+const benchChatResponse = `{"id":"chatcmpl-bench","object":"chat.completion","created":0,"model":"gpt-4o",` +
+	`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],` +
+	`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`
+
+// newBenchUpstream 启动本地 TLS 上游，返回上游和写入了其证书的 CA 文件
+func newBenchUpstream(b *testing.B) (*httptest.Server, string) {
+	b.Helper()
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, benchChatResponse)
+	}))
+	b.Cleanup(upstream.Close)
+
+	caFile := filepath.Join(b.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0o600); err != nil {
+		b.Fatal(err)
+	}
+	return upstream, caFile
+}
+
+func BenchmarkForwardTransportPerRequest(b *testing.B) {
+	upstream, _ := newBenchUpstream(b)
+	pool := x509.NewCertPool()
+	pool.AddCert(upstream.Certificate())
+	benchReverseProxy(b, upstream.URL, func() *http.Transport {
+		return &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}
+	})
+}
+
+func BenchmarkForwardSharedTransport(b *testing.B) {
+	upstream, caFile := newBenchUpstream(b)
+	shared, err := NewTransport(&TransportConfig{CAFile: caFile})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(shared.CloseIdleConnections)
+	benchReverseProxy(b, upstream.URL, func() *http.Transport { return shared })
+}
+
+func BenchmarkForwardProxyHandler(b *testing.B) {
+	benchProxyHandler(b, []byte(benchChatBody))
+}
+
+func BenchmarkForwardLargePrompt(b *testing.B) {
+	benchProxyHandler(b, benchLargeChatBody())
+}
+
+// benchReverseProxy 使用 transport 返回的传输层转发请求，对比每个请求新建传输层和复用连接池
+func benchReverseProxy(b *testing.B, targetURL string, transport func() *http.Transport) {
+	target, _ := url.Parse(targetURL)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rp := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = target.Scheme
+				req.URL.Host = target.Host
+				req.Host = target.Host
+			},
+			Transport: transport(),
+		}
+		w := httptest.NewRecorder()
+		rp.ServeHTTP(w, newBenchRequest([]byte(benchChatBody)))
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+// benchProxyHandler 通过完整的代理处理流程（插件、模型映射、上游选择、适配器）转发请求
+func benchProxyHandler(b *testing.B, body []byte) {
+	upstream, caFile := newBenchUpstream(b)
+	gin.SetMode(gin.ReleaseMode)
+
+	// 代理的日志在创建时绑定 stdout/stderr，基准测试期间丢弃
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = devNull, devNull
+	p, err := NewCursorProxy(Config{
+		TargetURL:          upstream.URL,
+		HealthCheckSeconds: -1,
+		Transport:          &TransportConfig{CAFile: caFile},
+	}, map[string]string{"cursor-large": "gpt-4o"})
+	os.Stdout, os.Stderr = stdout, stderr
+	if err != nil {
+		devNull.Close()
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = p.Shutdown(ctx)
+		devNull.Close()
+	})
+	handler := p.router()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newBenchRequest(body))
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+// benchLargeChatBody 模拟 Cursor 带上下文的大请求（约 200KB），模型需要映射
+func benchLargeChatBody() []byte {
+	messages := make([]string, 0, 100)
+	content := strings.Repeat("func main() { fmt.Println(\"hello\") }\\n", 50)
+	for i := 0; i < 100; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages = append(messages, fmt.Sprintf(`{"role":%q,"content":%q}`, role, content))
+	}
+	return []byte(`{"model":"cursor-large","temperature":0.2,"messages":[` + strings.Join(messages, ",") + `]}`)
+}
+
+func newBenchRequest(body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
@@ -1,12 +1,79 @@
 package proxy
 
-// Config 配置结构
+import (
+	"encoding/json"
+
+	"github.com/bagaking/openapi-proxy/plugin"
+)
+
+// Config 配置结构，可以通过 LoadConfig 从 JSON 文件加载
 type Config struct {
-	ListenAddr string            // 监听地址
-	TargetURL  string            // 目标服务地址
-	PathPrefix string            // 路由前缀，如 "/openai"
-	Headers    map[string]string // 需要添加的 header
-	Models     []ModelInfo       // 支持的模型列表
+	ListenAddr string            `json:"listen_addr"` // 监听地址
+	TargetURL  string            `json:"target_url"`  // 目标服务地址
+	PathPrefix string            `json:"path_prefix"` // 路由前缀，如 "/openai"
+	Headers    map[string]string `json:"headers"`     // 需要添加的 header
+	Models     []ModelInfo       `json:"models"`      // 支持的模型列表
+	Upstreams  []UpstreamConfig  `json:"upstreams"`   // 上游列表，为空时使用 TargetURL 和 Headers 作为唯一的 OpenAI 兼容上游
+
+	Transport *TransportConfig `json:"transport,omitempty"` // 上游连接的默认配置，UpstreamConfig.Transport 可以单独覆盖
+
+	ResponseStoreSize int      `json:"response_store_size"` // Responses API 在本地保存的响应数量上限，默认 1000
+	AccessKeys        []string `json:"access_keys"`         // WebSocket 客户端访问密钥，为空时不校验并转发客户端的凭证
+
+	BatchDir               string `json:"batch_dir"`                 // 本地 Batch API（/v1/files、/v1/batches）的数据目录，为空时不启用，请求转发到上游
+	BatchConcurrency       int    `json:"batch_concurrency"`         // 每个 batch 同时执行的请求数，默认 4
+	BatchRequestsPerMinute int    `json:"batch_requests_per_minute"` // batch 请求的速率上限，0 表示不限制
+
+	Cache         *plugin.CacheConfig         `json:"cache,omitempty"`          // 响应缓存配置，为空时不启用（StartCursorProxy 中注册缓存插件）
+	SemanticCache *plugin.SemanticCacheConfig `json:"semantic_cache,omitempty"` // 语义缓存配置，为空时不启用，在响应缓存之后查找
+
+	CoalesceRequests bool `json:"coalesce_requests"` // 合并相同的并发请求（包括流式请求），只请求一次上游，响应分发给所有客户端
+
+	HealthCheckSeconds int `json:"health_check_seconds"` // 上游健康检查的间隔秒数，默认 30，小于 0 时不检查
+
+	ModelMappings   map[string]string `json:"model_mappings"`    // 模型映射，与 StartCursorProxy 的 mappings 参数合并，参数优先
+	Plugins         []PluginSpec      `json:"plugins,omitempty"` // 按类型创建的插件，在内置插件之后注册
+	Routes          []RouteConfig     `json:"routes,omitempty"`  // 路由规则，为匹配的请求指定插件链和上游
+	DisabledPlugins []string          `json:"disabled_plugins"`  // 停用的插件名称
+	PluginOrder     []string          `json:"plugin_order"`      // 插件执行顺序，未列出的插件排在后面，未配置时按插件的 Priority 排序
+
+	AdminAddr    string `json:"admin_addr"`    // 管理 API 的监听地址，为空时不启用
+	AdminKey     string `json:"admin_key"`     // 管理 API 的访问密钥，未配置时管理 API 不会启动
+	AdminPersist bool   `json:"admin_persist"` // 通过管理 API 修改配置后自动写回配置文件
+
+	DrainTimeoutSeconds int `json:"drain_timeout_seconds"` // 关闭服务时等待进行中的请求（包括流式响应）的秒数，默认 30，超时后取消剩余的请求
+
+	DashboardRecords int `json:"dashboard_records"` // 仪表盘保留的最近请求数量，默认 200
+
+	ConfigFile string `json:"-"` // 配置文件路径，LoadConfig 时设置，管理 API 保存配置时写回该文件
+}
+
+// 上游协议类型
+const (
+	UpstreamTypeOpenAI    = "openai"    // OpenAI 兼容协议（默认）
+	UpstreamTypeAzure     = "azure"     // Azure OpenAI 部署协议
+	UpstreamTypeAnthropic = "anthropic" // Anthropic Messages API
+	UpstreamTypeGemini    = "gemini"    // Google Gemini generateContent API
+	UpstreamTypeOllama    = "ollama"    // Ollama 原生 /api/chat
+)
+
+// UpstreamConfig 上游配置
+type UpstreamConfig struct {
+	Name      string            `json:"name"`       // 上游名称，用于日志
+	Type      string            `json:"type"`       // 上游协议类型，为空时为 UpstreamTypeOpenAI
+	TargetURL string            `json:"target_url"` // 上游服务地址
+	Headers   map[string]string `json:"headers"`    // 需要添加的 header，Authorization 仅在客户端未携带时使用
+	Models    []string          `json:"models"`     // 路由到该上游的模型，为空表示作为默认上游
+
+	APIVersion         string            `json:"api_version,omitempty"`          // Azure: api-version 查询参数
+	RealtimeAPIVersion string            `json:"realtime_api_version,omitempty"` // Azure: Realtime API（WebSocket）的 api-version，默认 2024-10-01-preview
+	Deployments        map[string]string `json:"deployments,omitempty"`          // Azure: 模型名到部署名的映射，未配置的模型直接使用模型名
+
+	HealthCheckBody string `json:"health_check_body,omitempty"` // 健康检查发送的 chat/completions 请求体（OpenAI 格式，如 max_tokens 为 1 的请求），为空时请求上游列出模型的接口
+
+	Transport *TransportConfig `json:"transport,omitempty"` // 连接配置（超时、连接池、代理、CA 证书和 TLS），为空时使用 Config.Transport
+
+	EmbeddingBatchSize int `json:"embedding_batch_size,omitempty"` // 单次发往该上游的 embeddings input 数量上限，默认 2048，超出时拆分为多个请求
 }
 
 // ModelInfo 模型信息
@@ -22,3 +89,10 @@ type ModelsResponse struct {
 	Object string      `json:"object"` // 固定为 "list"
 	Data   []ModelInfo `json:"data"`   // 模型列表
 }
+
+// PluginSpec 配置文件中按类型名创建的插件
+type PluginSpec struct {
+	Type    string          `json:"type"`              // 插件类型，如 model_map、cache，见 plugin.Types
+	Enabled *bool           `json:"enabled,omitempty"` // 为 false 时注册但不执行，可以通过管理 API 启用
+	Config  json.RawMessage `json:"config,omitempty"`  // 插件配置，为空时使用默认配置
+}
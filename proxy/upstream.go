@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+// Adapter 上游协议适配器
+type Adapter interface {
+	// RewriteRequest 把 OpenAI 协议的请求改写为上游协议（路径、认证头、请求体），返回新的请求体
+	RewriteRequest(req *http.Request, body []byte) ([]byte, error)
+	// ModifyResponse 把上游响应转换回 OpenAI 协议
+	ModifyResponse(resp *http.Response) error
+}
+
+// ModelLister 可以从上游获取模型列表的适配器，用于填充 /v1/models
+type ModelLister interface {
+	ListModels(ctx context.Context, client *http.Client) ([]ModelInfo, error)
+}
+
+// HealthProber 自定义健康检查请求的适配器，未实现时健康检查请求 GET /v1/models 经过 RewriteRequest 改写
+type HealthProber interface {
+	// RewriteProbe 把健康检查请求改写为上游列出模型（或其它开销很小）的接口，设置路径和认证头
+	RewriteProbe(req *http.Request)
+}
+
+// Upstream 上游服务
+type Upstream struct {
+	Config    UpstreamConfig
+	target    *url.URL
+	adapter   Adapter
+	transport *http.Transport        // 长期复用的连接池
+	proxy     *httputil.ReverseProxy // 转发使用的反向代理，由 Proxy 创建
+	stats     upstreamStats
+}
+
+// UpstreamStatus 上游的配置和请求统计
+type UpstreamStatus struct {
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	TargetURL     string    `json:"target_url"`
+	Models        []string  `json:"models"`
+	Requests      int64     `json:"requests"`
+	Failures      int64     `json:"failures"` // 连接失败、429 和 5xx
+	LastStatus    int       `json:"last_status"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastLatencyMs int64     `json:"last_latency_ms"` // 收到响应头的耗时
+	LastSeen      time.Time `json:"last_seen"`
+
+	Healthy        bool      `json:"healthy"`      // 最近一次健康检查是否成功，尚未检查时为 true
+	ProbeStatus    int       `json:"probe_status"` // 最近一次健康检查的状态码
+	ProbeError     string    `json:"probe_error,omitempty"`
+	ProbeLatencyMs int64     `json:"probe_latency_ms"`
+	ProbedAt       time.Time `json:"probed_at"`
+}
+
+// upstreamStats 上游的请求统计
+type upstreamStats struct {
+	mu          sync.Mutex
+	requests    int64
+	failures    int64
+	lastStatus  int
+	lastError   string
+	lastLatency time.Duration
+	lastSeen    time.Time
+
+	unhealthy    bool
+	probeStatus  int
+	probeError   string
+	probeLatency time.Duration
+	probedAt     time.Time
+}
+
+// observe 记录一次上游请求的结果，err 不为 nil 表示没有收到响应
+func (up *Upstream) observe(status int, err error, latency time.Duration) {
+	s := &up.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.lastStatus = status
+	s.lastError = ""
+	if err != nil {
+		s.lastError = err.Error()
+	}
+	if err != nil || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError {
+		s.failures++
+	}
+	s.lastLatency = latency
+	s.lastSeen = time.Now()
+}
+
+// Status 返回上游的配置和请求统计
+func (up *Upstream) Status() UpstreamStatus {
+	s := &up.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	typ := up.Config.Type
+	if typ == "" {
+		typ = UpstreamTypeOpenAI
+	}
+	return UpstreamStatus{
+		Name:          up.Config.Name,
+		Type:          typ,
+		TargetURL:     up.Config.TargetURL,
+		Models:        up.Config.Models,
+		Requests:      s.requests,
+		Failures:      s.failures,
+		LastStatus:    s.lastStatus,
+		LastError:     s.lastError,
+		LastLatencyMs: s.lastLatency.Milliseconds(),
+		LastSeen:      s.lastSeen,
+
+		Healthy:        !s.unhealthy,
+		ProbeStatus:    s.probeStatus,
+		ProbeError:     s.probeError,
+		ProbeLatencyMs: s.probeLatency.Milliseconds(),
+		ProbedAt:       s.probedAt,
+	}
+}
+
+// UpstreamStatuses 返回所有上游的状态
+func (p *Proxy) UpstreamStatuses() []UpstreamStatus {
+	out := make([]UpstreamStatus, 0, len(p.upstreams))
+	for _, up := range p.upstreams {
+		out = append(out, up.Status())
+	}
+	return out
+}
+
+// newUpstream 根据配置创建上游
+func newUpstream(conf UpstreamConfig) (*Upstream, error) {
+	target, err := url.Parse(conf.TargetURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse target url of upstream %q: %w", conf.Name, err)
+	}
+
+	transport, err := NewTransport(conf.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("transport of upstream %q: %w", conf.Name, err)
+	}
+
+	up := &Upstream{Config: conf, target: target, transport: transport}
+	switch conf.Type {
+	case "", UpstreamTypeOpenAI:
+		up.adapter = &openAIAdapter{target: target}
+	case UpstreamTypeAzure:
+		up.adapter = newAzureAdapter(conf, target)
+	case UpstreamTypeAnthropic:
+		up.adapter = &anthropicAdapter{target: target}
+	case UpstreamTypeGemini:
+		up.adapter = &geminiAdapter{target: target}
+	case UpstreamTypeOllama:
+		up.adapter = &ollamaAdapter{target: target, headers: conf.Headers}
+	default:
+		return nil, fmt.Errorf("unknown type %q of upstream %q", conf.Type, conf.Name)
+	}
+	return up, nil
+}
+
+// upstreamConfigs 返回配置中的上游列表，未配置时使用 TargetURL、Headers 和 Transport 构造默认上游
+func (cfg Config) upstreamConfigs() []UpstreamConfig {
+	if len(cfg.Upstreams) == 0 {
+		return []UpstreamConfig{{
+			Name:      "default",
+			Type:      UpstreamTypeOpenAI,
+			TargetURL: cfg.TargetURL,
+			Headers:   cfg.Headers,
+			Transport: cfg.Transport,
+		}}
+	}
+	// 未单独配置连接的上游使用 Config.Transport
+	upstreams := make([]UpstreamConfig, len(cfg.Upstreams))
+	for i, up := range cfg.Upstreams {
+		if up.Transport == nil {
+			up.Transport = cfg.Transport
+		}
+		upstreams[i] = up
+	}
+	return upstreams
+}
+
+// selectUpstream 根据模型选择上游：优先精确匹配模型，其次是未限定模型的默认上游，
+// 同一优先级中优先选择健康检查通过的上游，都不健康时仍按原来的顺序选择
+func (p *Proxy) selectUpstream(model string) *Upstream {
+	var matched, defaults []*Upstream
+	for _, up := range p.upstreams {
+		if slices.Contains(up.Config.Models, model) {
+			matched = append(matched, up)
+		} else if len(up.Config.Models) == 0 {
+			defaults = append(defaults, up)
+		}
+	}
+	for _, candidates := range [][]*Upstream{matched, defaults} {
+		for _, up := range candidates {
+			if up.healthy() {
+				return up
+			}
+		}
+	}
+	for _, candidates := range [][]*Upstream{matched, defaults, p.upstreams} {
+		if len(candidates) > 0 {
+			return candidates[0]
+		}
+	}
+	return nil
+}
+
+// applyHeaders 设置转发到上游的认证头和自定义 header
+func (up *Upstream) applyHeaders(req *http.Request, clientAuth string, logger Logger) {
+	if clientAuth != "" && clientAuth != "Bearer" {
+		req.Header.Set("Authorization", clientAuth)
+		logger.Debug("Using client Authorization token")
+	} else if up.Config.Headers["Authorization"] != "" {
+		req.Header.Set("Authorization", up.Config.Headers["Authorization"])
+		logger.Debug("Using configured Authorization token")
+	} else if len(up.Config.Headers) == 0 {
+		logger.Error("No valid Authorization token available")
+	}
+
+	for k, v := range up.Config.Headers {
+		if http.CanonicalHeaderKey(k) == "Authorization" {
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+}
+
+// requestMeta 请求体中路由需要的字段
+type requestMeta struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream"`
+}
+
+// parseRequestMeta 解析请求体中的模型和流式标志，非 JSON 请求体返回零值
+func parseRequestMeta(body []byte) requestMeta {
+	var meta requestMeta
+	_ = json.Unmarshal(body, &meta)
+	return meta
+}
+
+// contextMeta 从插件上下文中读取模型和流式标志，复用上下文的解析结果
+func contextMeta(ctx *pluginPKG.RequestContext) requestMeta {
+	return requestMeta{Model: ctx.Model(), Stream: ctx.Stream()}
+}
+
+// openAIAdapter OpenAI 兼容上游，仅改写路径前缀
+type openAIAdapter struct {
+	target *url.URL
+}
+
+func (a *openAIAdapter) RewriteRequest(req *http.Request, body []byte) ([]byte, error) {
+	if strings.HasPrefix(req.URL.Path, "/v1/") {
+		req.URL.Path = path.Join(a.target.Path, strings.TrimPrefix(req.URL.Path, "/v1"))
+	}
+	return body, nil
+}
+
+func (a *openAIAdapter) ModifyResponse(resp *http.Response) error {
+	return nil
+}
+
+// rewriteBody 读取完整的响应体并用 fn 的结果替换
+func rewriteBody(resp *http.Response, fn func(body []byte) []byte) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	body = fn(body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// pipeBody 把 src 经过 fn 转换后的内容作为新的响应体，转换在后台进行，适用于流式响应
+func pipeBody(src io.ReadCloser, fn func(r io.Reader, w io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer src.Close()
+		pw.CloseWithError(fn(src, pw))
+	}()
+	return pr
+}
@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// defaultAnthropicMaxTokens 请求未指定 max_tokens 时使用的值，Anthropic 要求必须指定
+const defaultAnthropicMaxTokens = 4096
+
+// anthropicAdapter Anthropic Messages API 上游，把 chat/completions 请求转换为 /v1/messages
+type anthropicAdapter struct {
+	target *url.URL
+}
+
+func (a *anthropicAdapter) RewriteRequest(req *http.Request, body []byte) ([]byte, error) {
+	if strings.TrimPrefix(req.URL.Path, "/v1") != "/chat/completions" {
+		return nil, fmt.Errorf("path %s is not supported by anthropic upstream", req.URL.Path)
+	}
+
+	var creq openai.ChatCompletionRequest
+	if err := json.Unmarshal(body, &creq); err != nil {
+		return nil, fmt.Errorf("invalid chat completion request: %w", err)
+	}
+	areq := openAIToAnthropicRequest(&creq)
+
+	req.URL.Path = a.path("/messages")
+	setAnthropicHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+	// 响应需要解析后转换，不转发客户端的 Accept-Encoding，由 Transport 请求 gzip 并解压
+	req.Header.Del("Accept-Encoding")
+
+	return json.Marshal(areq)
+}
+
+// RewriteProbe 健康检查请求 GET /v1/models
+func (a *anthropicAdapter) RewriteProbe(req *http.Request) {
+	req.URL.Path = a.path("/models")
+	setAnthropicHeaders(req)
+}
+
+// path 返回上游接口的路径，target 可以带或不带 /v1
+func (a *anthropicAdapter) path(op string) string {
+	if strings.HasSuffix(a.target.Path, "/v1") {
+		return path.Join(a.target.Path, op)
+	}
+	return path.Join(a.target.Path, "/v1", op)
+}
+
+// setAnthropicHeaders Anthropic 使用 x-api-key 认证，并需要 anthropic-version
+func setAnthropicHeaders(req *http.Request) {
+	if req.Header.Get("x-api-key") == "" {
+		if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != "" {
+			req.Header.Set("x-api-key", token)
+		}
+	}
+	req.Header.Del("Authorization")
+	if req.Header.Get("anthropic-version") == "" {
+		req.Header.Set("anthropic-version", anthropicVersion)
+	}
+}
+
+// openAIToAnthropicRequest 把 chat/completions 请求转换为 Anthropic Messages 请求
+func openAIToAnthropicRequest(creq *openai.ChatCompletionRequest) *anthropicRequest {
+	areq := &anthropicRequest{
+		Model:         creq.Model,
+		MaxTokens:     creq.MaxOutputTokens(),
+		TopP:          creq.TopP,
+		StopSequences: creq.Stop,
+		Stream:        creq.Stream,
+	}
+	if areq.MaxTokens <= 0 {
+		areq.MaxTokens = defaultAnthropicMaxTokens
+	}
+	if creq.Temperature != nil {
+		// OpenAI 的 temperature 取值范围是 0~2，Anthropic 是 0~1
+		temperature := *creq.Temperature
+		if temperature > 1 {
+			temperature = 1
+		}
+		areq.Temperature = &temperature
+	}
+	if creq.User != "" {
+		areq.Metadata = &anthropicMetadata{UserID: creq.User}
+	}
+
+	for _, msg := range creq.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			areq.System = append(areq.System, anthropicBlock{Type: "text", Text: msg.Content})
+		case "tool":
+			areq.appendBlocks("user", anthropicBlock{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   anthropicContent{{Type: "text", Text: msg.Content}},
+			})
+		case "assistant":
+			var blocks []anthropicBlock
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    tc.ID,
+					Name:  tc.Function.Name,
+					Input: toolInput(tc.Function.Arguments),
+				})
+			}
+			areq.appendBlocks("assistant", blocks...)
+		default:
+			areq.appendBlocks("user", openAIUserBlocks(msg)...)
+		}
+	}
+
+	for _, tool := range creq.Tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		areq.Tools = append(areq.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	switch choice := openai.ParseToolChoice(creq.ToolChoice); choice.Mode {
+	case "auto", "none":
+		areq.ToolChoice = &anthropicToolChoice{Type: choice.Mode}
+	case "required":
+		areq.ToolChoice = &anthropicToolChoice{Type: "any"}
+	case "function":
+		areq.ToolChoice = &anthropicToolChoice{Type: "tool", Name: choice.Function}
+	}
+	if creq.ParallelToolCalls != nil && !*creq.ParallelToolCalls && len(areq.Tools) > 0 {
+		if areq.ToolChoice == nil {
+			areq.ToolChoice = &anthropicToolChoice{Type: "auto"}
+		}
+		disable := true
+		areq.ToolChoice.DisableParallelToolUse = &disable
+	}
+	return areq
+}
+
+// appendBlocks 追加消息内容，Anthropic 要求角色交替出现，相同角色的连续消息会合并
+func (r *anthropicRequest) appendBlocks(role string, blocks ...anthropicBlock) {
+	if len(blocks) == 0 {
+		return
+	}
+	if n := len(r.Messages); n > 0 && r.Messages[n-1].Role == role {
+		r.Messages[n-1].Content = append(r.Messages[n-1].Content, blocks...)
+		return
+	}
+	r.Messages = append(r.Messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+// openAIUserBlocks 转换用户消息的内容，图片转换为 image 块
+func openAIUserBlocks(msg openai.Message) []anthropicBlock {
+	if msg.Parts == nil {
+		if msg.Content == "" {
+			return nil
+		}
+		return []anthropicBlock{{Type: "text", Text: msg.Content}}
+	}
+
+	var blocks []anthropicBlock
+	for _, part := range msg.Parts {
+		switch part.Type {
+		case "text":
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			if mediaType, data, ok := parseDataURL(part.ImageURL.URL); ok {
+				blocks = append(blocks, anthropicBlock{Type: "image", Source: &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}})
+			} else {
+				blocks = append(blocks, anthropicBlock{Type: "image", Source: &anthropicImageSource{Type: "url", URL: part.ImageURL.URL}})
+			}
+		}
+	}
+	return blocks
+}
+
+// parseDataURL 解析 base64 编码的 data URL
+func parseDataURL(s string) (mediaType, data string, ok bool) {
+	rest, found := strings.CutPrefix(s, "data:")
+	if !found {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(rest, ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
+
+// openAIFinishReason 把 Anthropic stop_reason 转换为 OpenAI finish_reason
+func openAIFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return openai.FinishReasonLength
+	case "tool_use":
+		return openai.FinishReasonToolCalls
+	case "refusal":
+		return openai.FinishReasonContentFilter
+	default:
+		return openai.FinishReasonStop
+	}
+}
+
+func (a *anthropicAdapter) ModifyResponse(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusBadRequest {
+		return rewriteBody(resp, func(body []byte) []byte {
+			var aerr anthropicError
+			if err := json.Unmarshal(body, &aerr); err != nil || aerr.Error.Message == "" {
+				return body
+			}
+			out := openai.NewErrorResponse(resp.StatusCode, aerr.Error.Message, nil)
+			if aerr.Error.Type != "api_error" {
+				out.Error.Type = aerr.Error.Type
+			}
+			data, _ := json.Marshal(out)
+			return data
+		})
+	}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body = pipeBody(resp.Body, translateAnthropicStream)
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+
+	return rewriteBody(resp, func(body []byte) []byte {
+		var aresp anthropicResponse
+		if err := json.Unmarshal(body, &aresp); err != nil {
+			return body
+		}
+		data, _ := json.Marshal(anthropicToOpenAIResponse(&aresp))
+		return data
+	})
+}
+
+// anthropicToOpenAIResponse 把 Anthropic Messages 响应转换为 chat.completion
+func anthropicToOpenAIResponse(aresp *anthropicResponse) *openai.ChatCompletionResponse {
+	msg := openai.Message{Role: "assistant"}
+	for _, block := range aresp.Content {
+		switch block.Type {
+		case "text":
+			msg.Content += block.Text
+		case "thinking":
+			msg.ReasoningContent += block.Thinking
+		case "tool_use":
+			msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: openai.FunctionCall{Name: block.Name, Arguments: string(toolInput(string(block.Input)))},
+			})
+		}
+	}
+
+	finishReason := openai.FinishReasonStop
+	if aresp.StopReason != nil {
+		finishReason = openAIFinishReason(*aresp.StopReason)
+	}
+	return &openai.ChatCompletionResponse{
+		ID:      aresp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   aresp.Model,
+		Choices: []openai.Choice{{Index: 0, Message: msg, FinishReason: finishReason}},
+		Usage: &openai.Usage{
+			PromptTokens:     aresp.Usage.InputTokens,
+			CompletionTokens: aresp.Usage.OutputTokens,
+			TotalTokens:      aresp.Usage.InputTokens + aresp.Usage.OutputTokens,
+		},
+	}
+}
+
+// translateAnthropicStream 把 Anthropic 流式事件转换为 chat.completion.chunk
+func translateAnthropicStream(r io.Reader, w io.Writer) error {
+	reader := newSSEReader(r)
+	chunk := openai.ChatCompletionChunk{Object: "chat.completion.chunk", Created: time.Now().Unix()}
+	var usage openai.Usage
+	toolIndex := -1
+
+	emit := func(delta openai.Delta, finishReason *string, u *openai.Usage) error {
+		out := chunk
+		out.Choices = []openai.ChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}}
+		out.Usage = u
+		return writeSSE(w, newJSONEvent("", out))
+	}
+
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return writeSSE(w, sseEvent{Data: []byte(sseDone)})
+		}
+		if err != nil {
+			return err
+		}
+
+		var aev anthropicStreamEvent
+		if err := json.Unmarshal(ev.Data, &aev); err != nil {
+			continue
+		}
+
+		switch aev.Type {
+		case "message_start":
+			if aev.Message != nil {
+				chunk.ID = aev.Message.ID
+				chunk.Model = aev.Message.Model
+				usage.PromptTokens = aev.Message.Usage.InputTokens
+			}
+			err = emit(openai.Delta{Role: "assistant"}, nil, nil)
+		case "content_block_start":
+			if aev.ContentBlock != nil && aev.ContentBlock.Type == "tool_use" {
+				toolIndex++
+				index := toolIndex
+				err = emit(openai.Delta{ToolCalls: []openai.ToolCall{{
+					Index:    &index,
+					ID:       aev.ContentBlock.ID,
+					Type:     "function",
+					Function: openai.FunctionCall{Name: aev.ContentBlock.Name},
+				}}}, nil, nil)
+			}
+		case "content_block_delta":
+			if aev.Delta == nil {
+				continue
+			}
+			switch aev.Delta.Type {
+			case "text_delta":
+				err = emit(openai.Delta{Content: aev.Delta.Text}, nil, nil)
+			case "thinking_delta":
+				err = emit(openai.Delta{ReasoningContent: aev.Delta.Thinking}, nil, nil)
+			case "input_json_delta":
+				index := toolIndex
+				err = emit(openai.Delta{ToolCalls: []openai.ToolCall{{
+					Index:    &index,
+					Function: openai.FunctionCall{Arguments: aev.Delta.PartialJSON},
+				}}}, nil, nil)
+			}
+		case "message_delta":
+			if aev.Usage != nil {
+				usage.CompletionTokens = aev.Usage.OutputTokens
+				if aev.Usage.InputTokens > 0 {
+					usage.PromptTokens = aev.Usage.InputTokens
+				}
+			}
+			if aev.Delta != nil && aev.Delta.StopReason != nil {
+				usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+				finalUsage := usage
+				err = emit(openai.Delta{}, openai.StringPtr(openAIFinishReason(*aev.Delta.StopReason)), &finalUsage)
+			}
+		case "error":
+			message := "upstream stream error"
+			if aev.Error != nil {
+				message = aev.Error.Message
+			}
+			err = writeSSE(w, newJSONEvent("", openai.NewErrorResponse(http.StatusInternalServerError, message, nil)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
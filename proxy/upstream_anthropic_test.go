@@ -0,0 +1,326 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// fakeAnthropic 本地的 Anthropic 服务，messages 返回 /v1/messages 的响应，收到的请求通过 last 读取
+type fakeAnthropic struct {
+	*httptest.Server
+	mu      sync.Mutex
+	path    string
+	request anthropicRequest
+	header  http.Header
+}
+
+func newFakeAnthropic(t *testing.T, messages func(w http.ResponseWriter, r *http.Request)) *fakeAnthropic {
+	f := &fakeAnthropic{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req anthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid messages request: %v", err)
+		}
+		f.mu.Lock()
+		f.path, f.request, f.header = r.URL.Path, req, r.Header.Clone()
+		f.mu.Unlock()
+		messages(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAnthropic) last() (string, anthropicRequest, http.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.path, f.request, f.header
+}
+
+func newAnthropicTestProxy(t *testing.T, f *fakeAnthropic) string {
+	_, srv := newTestProxy(t, Config{Upstreams: []UpstreamConfig{{
+		Name: "anthropic", Type: UpstreamTypeAnthropic, TargetURL: f.URL,
+		Headers: map[string]string{"Authorization": "Bearer sk-ant"},
+	}}})
+	return srv.URL
+}
+
+// writeMaybeGzip 写出 JSON 响应，请求接受 gzip 时压缩响应体
+func writeMaybeGzip(w http.ResponseWriter, r *http.Request, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(status)
+	zw := gzip.NewWriter(w)
+	io.WriteString(zw, body)
+	zw.Close()
+}
+
+// postGzip 以显式的 Accept-Encoding: gzip 发送请求，客户端不会自动解压，返回响应的 Content-Encoding
+func postGzip(t *testing.T, url, body string) (int, string, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get("Content-Encoding"), data
+}
+
+const anthropicHello = `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"hello"}],` +
+	`"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`
+
+func TestAnthropicUpstreamRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, req anthropicRequest)
+	}{
+		{"system and defaults", `{"model":"claude","temperature":1.5,"user":"u1","stop":"END",
+			"messages":[{"role":"system","content":"be brief"},{"role":"developer","content":"no markdown"},{"role":"user","content":"hi"}]}`,
+			func(t *testing.T, req anthropicRequest) {
+				if req.Model != "claude" || req.MaxTokens != defaultAnthropicMaxTokens || req.Temperature == nil || *req.Temperature != 1 {
+					t.Errorf("unexpected request: %+v", req)
+				}
+				if req.Metadata == nil || req.Metadata.UserID != "u1" || len(req.StopSequences) != 1 {
+					t.Errorf("unexpected metadata or stop: %+v", req)
+				}
+				if len(req.System) != 2 || req.System.Text() != "be briefno markdown" || len(req.Messages) != 1 {
+					t.Errorf("unexpected system %+v, messages %+v", req.System, req.Messages)
+				}
+			}},
+		{"tool calls and results", `{"model":"claude","max_completion_tokens":32,"messages":[
+			{"role":"user","content":"weather?"},
+			{"role":"assistant","content":"checking","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},
+				{"id":"call_2","type":"function","function":{"name":"get_time","arguments":""}}]},
+			{"role":"tool","tool_call_id":"call_1","content":"sunny"},
+			{"role":"tool","tool_call_id":"call_2","content":"noon"},
+			{"role":"user","content":"thanks"}]}`,
+			func(t *testing.T, req anthropicRequest) {
+				if req.MaxTokens != 32 || len(req.Messages) != 3 {
+					t.Fatalf("unexpected request: %+v", req)
+				}
+				assistant := req.Messages[1].Content
+				if len(assistant) != 3 || assistant[1].Type != "tool_use" || string(assistant[1].Input) != `{"city":"Paris"}` || string(assistant[2].Input) != "{}" {
+					t.Errorf("unexpected assistant blocks: %+v", assistant)
+				}
+				// 工具结果和之后的用户消息合并为一条 user 消息
+				user := req.Messages[2]
+				if user.Role != "user" || len(user.Content) != 3 || user.Content[0].Type != "tool_result" ||
+					user.Content[1].ToolUseID != "call_2" || user.Content[2].Text != "thanks" {
+					t.Errorf("unexpected user message: %+v", user)
+				}
+			}},
+		{"images", `{"model":"claude","messages":[{"role":"user","content":[{"type":"text","text":"compare"},
+			{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`,
+			func(t *testing.T, req anthropicRequest) {
+				blocks := req.Messages[0].Content
+				if len(blocks) != 3 || blocks[1].Source == nil || blocks[1].Source.Type != "base64" || blocks[1].Source.MediaType != "image/png" ||
+					blocks[2].Source == nil || blocks[2].Source.Type != "url" {
+					t.Errorf("unexpected blocks: %+v", blocks)
+				}
+			}},
+		{"tools", `{"model":"claude","tool_choice":"required","parallel_tool_calls":false,
+			"tools":[{"type":"function","function":{"name":"get_weather"}}],"messages":[{"role":"user","content":"hi"}]}`,
+			func(t *testing.T, req anthropicRequest) {
+				if len(req.Tools) != 1 || string(req.Tools[0].InputSchema) != `{"type":"object","properties":{}}` {
+					t.Errorf("unexpected tools: %+v", req.Tools)
+				}
+				tc := req.ToolChoice
+				if tc == nil || tc.Type != "any" || tc.DisableParallelToolUse == nil || !*tc.DisableParallelToolUse {
+					t.Errorf("unexpected tool choice: %+v", tc)
+				}
+			}},
+	}
+	f := newFakeAnthropic(t, func(w http.ResponseWriter, r *http.Request) { writeMaybeGzip(w, r, http.StatusOK, anthropicHello) })
+	url := newAnthropicTestProxy(t, f)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := postJSON(t, url+"/v1/chat/completions", tt.body, nil); status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			path, req, header := f.last()
+			if path != "/v1/messages" || header.Get("x-api-key") != "sk-ant" || header.Get("Authorization") != "" ||
+				header.Get("anthropic-version") != anthropicVersion {
+				t.Errorf("unexpected path %s, headers %v", path, header)
+			}
+			tt.check(t, req)
+		})
+	}
+}
+
+func TestAnthropicUpstreamResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  string
+		check  func(t *testing.T, body []byte)
+	}{
+		{"text", http.StatusOK, anthropicHello, func(t *testing.T, body []byte) {
+			var resp openai.ChatCompletionResponse
+			decodeJSON(t, body, &resp)
+			if resp.Object != "chat.completion" || resp.ID != "msg_1" || resp.Choices[0].Message.Content != "hello" ||
+				resp.Choices[0].FinishReason != openai.FinishReasonStop || resp.Usage.TotalTokens != 4 {
+				t.Errorf("unexpected response: %s", body)
+			}
+		}},
+		{"thinking and tool use", http.StatusOK, `{"id":"msg_2","type":"message","role":"assistant","model":"claude","content":[
+			{"type":"thinking","thinking":"hmm"},{"type":"tool_use","id":"tu_1","name":"get_weather","input":{"city":"Paris"}}],
+			"stop_reason":"tool_use","usage":{"input_tokens":1,"output_tokens":1}}`, func(t *testing.T, body []byte) {
+			var resp openai.ChatCompletionResponse
+			decodeJSON(t, body, &resp)
+			choice := resp.Choices[0]
+			if choice.FinishReason != openai.FinishReasonToolCalls || choice.Message.ReasoningContent != "hmm" || len(choice.Message.ToolCalls) != 1 {
+				t.Fatalf("unexpected response: %s", body)
+			}
+			if call := choice.Message.ToolCalls[0]; call.ID != "tu_1" || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` {
+				t.Errorf("unexpected tool call: %+v", call)
+			}
+		}},
+		{"max tokens", http.StatusOK, `{"id":"msg_3","type":"message","content":[{"type":"text","text":"he"}],"stop_reason":"max_tokens","usage":{}}`,
+			func(t *testing.T, body []byte) {
+				var resp openai.ChatCompletionResponse
+				decodeJSON(t, body, &resp)
+				if resp.Choices[0].FinishReason != openai.FinishReasonLength {
+					t.Errorf("unexpected finish reason: %s", body)
+				}
+			}},
+		{"rate limited", http.StatusTooManyRequests, `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`,
+			func(t *testing.T, body []byte) {
+				var resp openai.ErrorResponse
+				decodeJSON(t, body, &resp)
+				if resp.Error.Type != "rate_limit_error" || resp.Error.Message != "slow down" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+		{"api error", http.StatusInternalServerError, `{"type":"error","error":{"type":"api_error","message":"boom"}}`,
+			func(t *testing.T, body []byte) {
+				var resp openai.ErrorResponse
+				decodeJSON(t, body, &resp)
+				if resp.Error.Type != "server_error" || resp.Error.Message != "boom" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAnthropic(t, func(w http.ResponseWriter, r *http.Request) { writeMaybeGzip(w, r, tt.status, tt.reply) })
+			url := newAnthropicTestProxy(t, f)
+
+			// 客户端声明接受 gzip 时响应也要先解压再转换
+			status, encoding, body := postGzip(t, url+"/v1/chat/completions", `{"model":"claude","messages":[{"role":"user","content":"hi"}]}`)
+			if status != tt.status || encoding != "" {
+				t.Fatalf("status %d, encoding %q: %q", status, encoding, body)
+			}
+			tt.check(t, body)
+		})
+	}
+}
+
+func TestAnthropicUpstreamStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		check  func(t *testing.T, chunks []openai.ChatCompletionChunk, events []string)
+	}{
+		{"text and tool", []string{
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[],"usage":{"input_tokens":3,"output_tokens":0}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"tu_1","name":"get_weather","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+			`{"type":"message_stop"}`,
+		}, func(t *testing.T, chunks []openai.ChatCompletionChunk, _ []string) {
+			var content, args strings.Builder
+			for _, c := range chunks {
+				if c.ID != "msg_1" || c.Model != "claude" || len(c.Choices) != 1 {
+					t.Fatalf("unexpected chunk: %+v", c)
+				}
+				content.WriteString(c.Choices[0].Delta.Content)
+				for _, tc := range c.Choices[0].Delta.ToolCalls {
+					if tc.Index == nil || *tc.Index != 0 {
+						t.Errorf("unexpected tool call index: %+v", tc)
+					}
+					args.WriteString(tc.Function.Arguments)
+				}
+			}
+			if chunks[0].Choices[0].Delta.Role != "assistant" || content.String() != "Hello" || args.String() != `{"city":"Paris"}` {
+				t.Errorf("unexpected content %q, args %q", content.String(), args.String())
+			}
+			last := chunks[len(chunks)-1]
+			if fr := last.Choices[0].FinishReason; fr == nil || *fr != openai.FinishReasonToolCalls {
+				t.Errorf("unexpected finish reason: %v", fr)
+			}
+			if last.Usage == nil || last.Usage.PromptTokens != 3 || last.Usage.CompletionTokens != 7 || last.Usage.TotalTokens != 10 {
+				t.Errorf("unexpected usage: %+v", last.Usage)
+			}
+		}},
+		{"error event", []string{
+			`{"type":"message_start","message":{"id":"msg_2","model":"claude","content":[],"usage":{}}}`,
+			`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+		}, func(t *testing.T, _ []openai.ChatCompletionChunk, events []string) {
+			var resp openai.ErrorResponse
+			decodeJSON(t, []byte(events[1]), &resp)
+			if resp.Error.Message != "Overloaded" || resp.Error.Type != "server_error" {
+				t.Errorf("unexpected error event: %s", events[1])
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAnthropic(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, data := range tt.events {
+					var ev struct {
+						Type string `json:"type"`
+					}
+					_ = json.Unmarshal([]byte(data), &ev)
+					io.WriteString(w, "event: "+ev.Type+"\ndata: "+data+"\n\n")
+					w.(http.Flusher).Flush()
+				}
+			})
+			url := newAnthropicTestProxy(t, f)
+
+			status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"claude","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if _, req, _ := f.last(); !req.Stream {
+				t.Error("stream not forwarded")
+			}
+			events := sseData(body)
+			if len(events) == 0 || events[len(events)-1] != sseDone {
+				t.Fatalf("unexpected events: %q", events)
+			}
+			var chunks []openai.ChatCompletionChunk
+			for _, data := range events[:len(events)-1] {
+				var chunk openai.ChatCompletionChunk
+				decodeJSON(t, []byte(data), &chunk)
+				if chunk.Object == "chat.completion.chunk" {
+					chunks = append(chunks, chunk)
+				}
+			}
+			tt.check(t, chunks, events)
+		})
+	}
+}
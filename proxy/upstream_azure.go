@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+const (
+	// defaultAzureAPIVersion 未配置 api-version 时使用的版本
+	defaultAzureAPIVersion = "2024-10-21"
+	// defaultAzureRealtimeAPIVersion Realtime API 只在 preview 版本中提供
+	defaultAzureRealtimeAPIVersion = "2024-10-01-preview"
+)
+
+// azureAdapter Azure OpenAI 上游
+//
+// Azure 使用 /openai/deployments/{deployment}/chat/completions?api-version=... 形式的路径，
+// Realtime API 使用 /openai/realtime?api-version=...&deployment=...，并通过 api-key header 认证
+type azureAdapter struct {
+	target             *url.URL
+	apiVersion         string
+	realtimeAPIVersion string
+	deployments        map[string]string
+}
+
+func newAzureAdapter(conf UpstreamConfig, target *url.URL) *azureAdapter {
+	apiVersion := conf.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAzureAPIVersion
+	}
+	realtimeAPIVersion := conf.RealtimeAPIVersion
+	if realtimeAPIVersion == "" {
+		realtimeAPIVersion = defaultAzureRealtimeAPIVersion
+	}
+	return &azureAdapter{
+		target:             target,
+		apiVersion:         apiVersion,
+		realtimeAPIVersion: realtimeAPIVersion,
+		deployments:        conf.Deployments,
+	}
+}
+
+// deployment 返回模型对应的部署名
+func (a *azureAdapter) deployment(model string) string {
+	if dep, ok := a.deployments[model]; ok {
+		return dep
+	}
+	return model
+}
+
+func (a *azureAdapter) RewriteRequest(req *http.Request, body []byte) ([]byte, error) {
+	op := strings.TrimPrefix(req.URL.Path, "/v1")
+	query := req.URL.Query()
+	query.Set("api-version", a.apiVersion)
+	switch {
+	case op == "/models" || strings.HasPrefix(op, "/models/"):
+		req.URL.Path = path.Join(a.target.Path, "/openai", op)
+	case op == "/realtime":
+		// Realtime API 不在 deployments 路径下，部署名通过 deployment 查询参数传递
+		dep := a.deployment(parseRequestMeta(body).Model)
+		if dep == "" {
+			return nil, errors.New("azure upstream requires a model to resolve the realtime deployment")
+		}
+		req.URL.Path = path.Join(a.target.Path, "/openai/realtime")
+		query.Del("model")
+		query.Set("deployment", dep)
+		query.Set("api-version", a.realtimeAPIVersion)
+	default:
+		// 没有模型时无法确定部署，不能转发到 /openai/deployments//… 这样的路径
+		dep := a.deployment(parseRequestMeta(body).Model)
+		if dep == "" {
+			return nil, errors.New("azure upstream requires a model to resolve the deployment")
+		}
+		req.URL.Path = path.Join(a.target.Path, "/openai/deployments", url.PathEscape(dep), op)
+	}
+	req.URL.RawQuery = query.Encode()
+
+	// Azure 使用 api-key 认证，客户端或配置中的 Bearer token 转为 api-key
+	if req.Header.Get("api-key") == "" {
+		if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != "" {
+			req.Header.Set("api-key", token)
+		}
+	}
+	req.Header.Del("Authorization")
+
+	return body, nil
+}
+
+// azureError Azure 返回的错误体，兼容 API 网关返回的 statusCode/message 格式
+type azureError struct {
+	Error *struct {
+		Code       interface{}     `json:"code"`
+		Message    string          `json:"message"`
+		Param      interface{}     `json:"param"`
+		Type       string          `json:"type"`
+		InnerError json.RawMessage `json:"innererror"`
+	} `json:"error"`
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message"`
+}
+
+func (a *azureAdapter) ModifyResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest ||
+		!strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+
+	return rewriteBody(resp, func(body []byte) []byte {
+		return normalizeAzureError(resp.StatusCode, body)
+	})
+}
+
+// normalizeAzureError 把 Azure 错误（包括内容过滤错误）转换为标准 OpenAI 错误，无法识别时原样返回
+func normalizeAzureError(status int, body []byte) []byte {
+	var azErr azureError
+	if err := json.Unmarshal(body, &azErr); err != nil {
+		return body
+	}
+
+	var out openai.ErrorResponse
+	switch {
+	case azErr.Error != nil:
+		out = openai.NewErrorResponse(status, azErr.Error.Message, azErr.Error.Code)
+		out.Error.Param = azErr.Error.Param
+		if azErr.Error.Type != "" {
+			out.Error.Type = azErr.Error.Type
+		}
+		if azErr.Error.Code == "content_filter" || strings.Contains(string(azErr.Error.InnerError), "ResponsibleAIPolicyViolation") {
+			out.Error.Type = "invalid_request_error"
+			out.Error.Code = "content_filter"
+		}
+	case azErr.Message != "":
+		out = openai.NewErrorResponse(status, azErr.Message, nil)
+	default:
+		return body
+	}
+
+	normalized, err := json.Marshal(out)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeAzure 本地的 Azure OpenAI 服务，接受 HTTP 请求和 WebSocket 连接，收到的请求通过 last 读取
+type fakeAzure struct {
+	*httptest.Server
+	mu     sync.Mutex
+	url    *url.URL
+	header http.Header
+}
+
+func newFakeAzure(t *testing.T) *fakeAzure {
+	f := &fakeAzure{}
+	upgrader := websocket.Upgrader{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.url, f.header = r.URL, r.Header.Clone()
+		f.mu.Unlock()
+		if websocket.IsWebSocketUpgrade(r) {
+			if conn, err := upgrader.Upgrade(w, r, nil); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"object":"list","data":[]}`)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAzure) last() (*url.URL, http.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.url, f.header
+}
+
+func TestAzureRewrite(t *testing.T) {
+	f := newFakeAzure(t)
+	_, srv := newTestProxy(t, Config{Upstreams: []UpstreamConfig{{
+		Name: "azure", Type: UpstreamTypeAzure, TargetURL: f.URL,
+		Headers:     map[string]string{"Authorization": "Bearer az-key"},
+		Deployments: map[string]string{"gpt-4o": "my-gpt4o", "gpt-4o-realtime": "my-realtime"},
+	}}})
+
+	tests := []struct {
+		name    string
+		do      func(t *testing.T)
+		path    string
+		version string
+		query   url.Values // 额外的查询参数
+	}{
+		{"chat completions", func(t *testing.T) {
+			postJSON(t, srv.URL+"/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+		}, "/openai/deployments/my-gpt4o/chat/completions", defaultAzureAPIVersion, nil},
+		{"unmapped model", func(t *testing.T) {
+			postJSON(t, srv.URL+"/v1/chat/completions", `{"model":"gpt-4.1","messages":[{"role":"user","content":"hi"}]}`, nil)
+		}, "/openai/deployments/gpt-4.1/chat/completions", defaultAzureAPIVersion, nil},
+		{"models", func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/v1/models/gpt-4o")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}, "/openai/models/gpt-4o", defaultAzureAPIVersion, nil},
+		{"realtime", func(t *testing.T) {
+			ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/v1/realtime?model=gpt-4o-realtime", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ws.Close()
+		}, "/openai/realtime", defaultAzureRealtimeAPIVersion, url.Values{"deployment": {"my-realtime"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.do(t)
+			u, header := f.last()
+			if u == nil {
+				t.Fatal("upstream not called")
+			}
+			if u.Path != tt.path || u.Query().Get("api-version") != tt.version || u.Query().Has("model") {
+				t.Errorf("upstream url %s, want path %s and api-version %s", u, tt.path, tt.version)
+			}
+			for k := range tt.query {
+				if u.Query().Get(k) != tt.query.Get(k) {
+					t.Errorf("query %s = %q, want %q", k, u.Query().Get(k), tt.query.Get(k))
+				}
+			}
+			if header.Get("api-key") != "az-key" || header.Get("Authorization") != "" {
+				t.Errorf("unexpected auth headers: %v", header)
+			}
+		})
+	}
+}
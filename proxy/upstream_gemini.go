@@ -0,0 +1,577 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// geminiAdapter Google Gemini 上游，把 chat/completions 请求转换为 generateContent / streamGenerateContent
+type geminiAdapter struct {
+	target *url.URL
+}
+
+// geminiRequest generateContent 请求
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// geminiContent 一轮对话内容
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiPart 内容片段
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FileData         *geminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// geminiBlob 内联数据
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// geminiFileData 文件引用
+type geminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+// geminiFunctionCall 函数调用
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// geminiFunctionResponse 函数调用结果
+type geminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+// geminiTool 工具定义
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+// geminiFunctionDeclaration 函数声明
+type geminiFunctionDeclaration struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// geminiToolConfig 工具调用配置
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"` // AUTO / ANY / NONE
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+// geminiGenerationConfig 生成参数
+type geminiGenerationConfig struct {
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"topP,omitempty"`
+	MaxOutputTokens  *int            `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string        `json:"stopSequences,omitempty"`
+	CandidateCount   *int            `json:"candidateCount,omitempty"`
+	PresencePenalty  *float64        `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequencyPenalty,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	ResponseMimeType string          `json:"responseMimeType,omitempty"`
+	ResponseSchema   json.RawMessage `json:"responseSchema,omitempty"`
+}
+
+// geminiResponse generateContent 响应，流式响应的每个事件也是这个结构
+type geminiResponse struct {
+	Candidates []struct {
+		Index        int           `json:"index"`
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	ModelVersion string `json:"modelVersion"`
+	ResponseID   string `json:"responseId"`
+}
+
+// geminiError 错误响应
+type geminiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func (a *geminiAdapter) RewriteRequest(req *http.Request, body []byte) ([]byte, error) {
+	if strings.TrimPrefix(req.URL.Path, "/v1") != "/chat/completions" {
+		return nil, fmt.Errorf("path %s is not supported by gemini upstream", req.URL.Path)
+	}
+
+	var creq openai.ChatCompletionRequest
+	if err := json.Unmarshal(body, &creq); err != nil {
+		return nil, fmt.Errorf("invalid chat completion request: %w", err)
+	}
+	greq := openAIToGeminiRequest(&creq)
+
+	base := a.basePath()
+	query := req.URL.Query()
+	if creq.Stream {
+		req.URL.Path = path.Join(base, "/models", creq.Model+":streamGenerateContent")
+		query.Set("alt", "sse")
+	} else {
+		req.URL.Path = path.Join(base, "/models", creq.Model+":generateContent")
+	}
+	req.URL.RawQuery = query.Encode()
+
+	setGeminiAuth(req)
+	req.Header.Set("Content-Type", "application/json")
+	// 响应需要解析后转换，不转发客户端的 Accept-Encoding，由 Transport 请求 gzip 并解压
+	req.Header.Del("Accept-Encoding")
+
+	return json.Marshal(greq)
+}
+
+// RewriteProbe 健康检查请求 GET {base}/models
+func (a *geminiAdapter) RewriteProbe(req *http.Request) {
+	req.URL.Path = path.Join(a.basePath(), "/models")
+	setGeminiAuth(req)
+}
+
+// basePath 返回 API 版本路径，target 未指定时为 /v1beta
+func (a *geminiAdapter) basePath() string {
+	if base := a.target.Path; base != "" && base != "/" {
+		return base
+	}
+	return "/v1beta"
+}
+
+// setGeminiAuth Gemini 使用 x-goog-api-key 认证
+func setGeminiAuth(req *http.Request) {
+	if req.Header.Get("x-goog-api-key") == "" {
+		if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != "" {
+			req.Header.Set("x-goog-api-key", token)
+		}
+	}
+	req.Header.Del("Authorization")
+}
+
+// openAIToGeminiRequest 把 chat/completions 请求转换为 generateContent 请求
+func openAIToGeminiRequest(creq *openai.ChatCompletionRequest) *geminiRequest {
+	greq := &geminiRequest{}
+
+	// tool 消息只携带 tool_call_id，需要从之前的 assistant 消息中找到函数名
+	toolNames := make(map[string]string)
+	for _, msg := range creq.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			if greq.SystemInstruction == nil {
+				greq.SystemInstruction = &geminiContent{}
+			}
+			greq.SystemInstruction.Parts = append(greq.SystemInstruction.Parts, geminiPart{Text: msg.Content})
+		case "assistant":
+			var parts []geminiPart
+			if msg.Content != "" {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				toolNames[tc.ID] = tc.Function.Name
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{
+					Name: tc.Function.Name,
+					Args: toolInput(tc.Function.Arguments),
+				}})
+			}
+			greq.appendParts("model", parts...)
+		case "tool":
+			name := toolNames[msg.ToolCallID]
+			if name == "" {
+				name = msg.ToolCallID
+			}
+			greq.appendParts("user", geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     name,
+				Response: geminiFunctionResult(msg.Content),
+			}})
+		default:
+			greq.appendParts("user", openAIGeminiParts(msg)...)
+		}
+	}
+
+	var decls []geminiFunctionDeclaration
+	for _, tool := range creq.Tools {
+		decls = append(decls, geminiFunctionDeclaration{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  sanitizeGeminiSchema(tool.Function.Parameters),
+		})
+	}
+	if len(decls) > 0 {
+		greq.Tools = []geminiTool{{FunctionDeclarations: decls}}
+	}
+
+	choice := openai.ParseToolChoice(creq.ToolChoice)
+	if choice.Mode != "" {
+		greq.ToolConfig = &geminiToolConfig{}
+		switch choice.Mode {
+		case "none":
+			greq.ToolConfig.FunctionCallingConfig.Mode = "NONE"
+		case "required":
+			greq.ToolConfig.FunctionCallingConfig.Mode = "ANY"
+		case "function":
+			greq.ToolConfig.FunctionCallingConfig.Mode = "ANY"
+			greq.ToolConfig.FunctionCallingConfig.AllowedFunctionNames = []string{choice.Function}
+		default:
+			greq.ToolConfig.FunctionCallingConfig.Mode = "AUTO"
+		}
+	}
+
+	gen := &geminiGenerationConfig{
+		Temperature:      creq.Temperature,
+		TopP:             creq.TopP,
+		StopSequences:    creq.Stop,
+		CandidateCount:   creq.N,
+		PresencePenalty:  creq.PresencePenalty,
+		FrequencyPenalty: creq.FrequencyPenalty,
+		Seed:             creq.Seed,
+	}
+	if maxTokens := creq.MaxOutputTokens(); maxTokens > 0 {
+		gen.MaxOutputTokens = &maxTokens
+	}
+	if len(creq.ResponseFormat) > 0 {
+		var format struct {
+			Type       string `json:"type"`
+			JSONSchema struct {
+				Schema json.RawMessage `json:"schema"`
+			} `json:"json_schema"`
+		}
+		if err := json.Unmarshal(creq.ResponseFormat, &format); err == nil && format.Type != "text" {
+			gen.ResponseMimeType = "application/json"
+			gen.ResponseSchema = sanitizeGeminiSchema(format.JSONSchema.Schema)
+		}
+	}
+	greq.GenerationConfig = gen
+
+	return greq
+}
+
+// appendParts 追加对话内容，相同角色的连续消息会合并
+func (r *geminiRequest) appendParts(role string, parts ...geminiPart) {
+	if len(parts) == 0 {
+		return
+	}
+	if n := len(r.Contents); n > 0 && r.Contents[n-1].Role == role {
+		r.Contents[n-1].Parts = append(r.Contents[n-1].Parts, parts...)
+		return
+	}
+	r.Contents = append(r.Contents, geminiContent{Role: role, Parts: parts})
+}
+
+// openAIGeminiParts 转换用户消息的内容，data URL 图片转换为内联数据
+func openAIGeminiParts(msg openai.Message) []geminiPart {
+	if msg.Parts == nil {
+		if msg.Content == "" {
+			return nil
+		}
+		return []geminiPart{{Text: msg.Content}}
+	}
+
+	var parts []geminiPart
+	for _, part := range msg.Parts {
+		switch part.Type {
+		case "text":
+			parts = append(parts, geminiPart{Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			if mediaType, data, ok := parseDataURL(part.ImageURL.URL); ok {
+				parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: mediaType, Data: data}})
+			} else {
+				parts = append(parts, geminiPart{FileData: &geminiFileData{FileURI: part.ImageURL.URL}})
+			}
+		}
+	}
+	return parts
+}
+
+// geminiFunctionResult 把工具调用结果转换为 functionResponse.response，要求是 JSON 对象
+func geminiFunctionResult(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	data, _ := json.Marshal(map[string]string{"result": content})
+	return data
+}
+
+// sanitizeGeminiSchema 去掉 Gemini 不支持的 JSON Schema 字段
+func sanitizeGeminiSchema(schema json.RawMessage) json.RawMessage {
+	if len(schema) == 0 {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(schema, &v); err != nil {
+		return schema
+	}
+	data, err := json.Marshal(stripSchemaKeys(v))
+	if err != nil {
+		return schema
+	}
+	return data
+}
+
+func stripSchemaKeys(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		delete(val, "$schema")
+		delete(val, "additionalProperties")
+		for k, child := range val {
+			val[k] = stripSchemaKeys(child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = stripSchemaKeys(child)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// geminiFinishReason 把 Gemini finishReason 转换为 OpenAI finish_reason
+func geminiFinishReason(reason string, hasToolCalls bool) string {
+	if hasToolCalls {
+		return openai.FinishReasonToolCalls
+	}
+	switch reason {
+	case "MAX_TOKENS":
+		return openai.FinishReasonLength
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return openai.FinishReasonContentFilter
+	default:
+		return openai.FinishReasonStop
+	}
+}
+
+// geminiModel 从请求路径 /v1beta/models/{model}:generateContent 中解析模型名
+func geminiModel(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	name := path.Base(req.URL.Path)
+	model, _, _ := strings.Cut(name, ":")
+	return model
+}
+
+func (a *geminiAdapter) ModifyResponse(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusBadRequest {
+		return rewriteBody(resp, func(body []byte) []byte {
+			return convertGeminiError(resp.StatusCode, body)
+		})
+	}
+
+	model := geminiModel(resp.Request)
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		resp.Body = pipeBody(resp.Body, func(r io.Reader, w io.Writer) error {
+			return translateGeminiStream(model, r, w)
+		})
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+
+	return rewriteBody(resp, func(body []byte) []byte {
+		var gresp geminiResponse
+		if err := json.Unmarshal(body, &gresp); err != nil {
+			return body
+		}
+		data, _ := json.Marshal(geminiToOpenAIResponse(model, &gresp))
+		return data
+	})
+}
+
+// convertGeminiError 把 Gemini 错误转换为 OpenAI 错误，无法识别时原样返回
+func convertGeminiError(status int, body []byte) []byte {
+	var gerr geminiError
+	if err := json.Unmarshal(body, &gerr); err != nil || gerr.Error.Message == "" {
+		return body
+	}
+	data, _ := json.Marshal(openai.NewErrorResponse(status, gerr.Error.Message, gerr.Error.Status))
+	return data
+}
+
+// geminiToOpenAIResponse 把 generateContent 响应转换为 chat.completion
+func geminiToOpenAIResponse(model string, gresp *geminiResponse) *openai.ChatCompletionResponse {
+	out := &openai.ChatCompletionResponse{
+		ID:      geminiResponseID(gresp),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openai.Choice{},
+		Usage:   geminiUsage(gresp),
+	}
+
+	toolCalls := 0
+	for _, cand := range gresp.Candidates {
+		msg := openai.Message{Role: "assistant"}
+		for _, part := range cand.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				msg.ToolCalls = append(msg.ToolCalls, geminiToolCall(part.FunctionCall, toolCalls, nil))
+				toolCalls++
+			case part.Thought:
+				msg.ReasoningContent += part.Text
+			default:
+				msg.Content += part.Text
+			}
+		}
+		out.Choices = append(out.Choices, openai.Choice{
+			Index:        cand.Index,
+			Message:      msg,
+			FinishReason: geminiFinishReason(cand.FinishReason, len(msg.ToolCalls) > 0),
+		})
+	}
+
+	// 请求被安全策略拦截时没有候选结果
+	if len(out.Choices) == 0 && gresp.PromptFeedback != nil && gresp.PromptFeedback.BlockReason != "" {
+		out.Choices = append(out.Choices, openai.Choice{
+			Message:      openai.Message{Role: "assistant"},
+			FinishReason: openai.FinishReasonContentFilter,
+		})
+	}
+	return out
+}
+
+// geminiToolCall 把函数调用转换为 tool_call，Gemini 未返回调用 ID 时生成一个
+func geminiToolCall(fc *geminiFunctionCall, seq int, index *int) openai.ToolCall {
+	id := fc.ID
+	if id == "" {
+		id = fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), seq)
+	}
+	args := string(fc.Args)
+	if args == "" {
+		args = "{}"
+	}
+	return openai.ToolCall{
+		Index:    index,
+		ID:       id,
+		Type:     "function",
+		Function: openai.FunctionCall{Name: fc.Name, Arguments: args},
+	}
+}
+
+func geminiResponseID(gresp *geminiResponse) string {
+	if gresp.ResponseID != "" {
+		return "chatcmpl-" + gresp.ResponseID
+	}
+	return fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+}
+
+func geminiUsage(gresp *geminiResponse) *openai.Usage {
+	if gresp.UsageMetadata == nil {
+		return nil
+	}
+	completion := gresp.UsageMetadata.CandidatesTokenCount + gresp.UsageMetadata.ThoughtsTokenCount
+	return &openai.Usage{
+		PromptTokens:     gresp.UsageMetadata.PromptTokenCount,
+		CompletionTokens: completion,
+		TotalTokens:      gresp.UsageMetadata.PromptTokenCount + completion,
+	}
+}
+
+// translateGeminiStream 把 streamGenerateContent 的 SSE 事件转换为 chat.completion.chunk
+func translateGeminiStream(model string, r io.Reader, w io.Writer) error {
+	reader := newSSEReader(r)
+	chunk := openai.ChatCompletionChunk{
+		ID:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   model,
+	}
+	started := false
+	toolCalls := 0
+
+	for {
+		ev, err := reader.Next()
+		if err == io.EOF {
+			return writeSSE(w, sseEvent{Data: []byte(sseDone)})
+		}
+		if err != nil {
+			return err
+		}
+
+		var gerr geminiError
+		if json.Unmarshal(ev.Data, &gerr) == nil && gerr.Error.Message != "" {
+			if err := writeSSE(w, newJSONEvent("", openai.NewErrorResponse(gerr.Error.Code, gerr.Error.Message, gerr.Error.Status))); err != nil {
+				return err
+			}
+			continue
+		}
+		var gresp geminiResponse
+		if err := json.Unmarshal(ev.Data, &gresp); err != nil {
+			continue
+		}
+
+		out := chunk
+		for _, cand := range gresp.Candidates {
+			delta := openai.Delta{}
+			if !started {
+				delta.Role = "assistant"
+			}
+			for _, part := range cand.Content.Parts {
+				switch {
+				case part.FunctionCall != nil:
+					index := toolCalls
+					delta.ToolCalls = append(delta.ToolCalls, geminiToolCall(part.FunctionCall, toolCalls, &index))
+					toolCalls++
+				case part.Thought:
+					delta.ReasoningContent += part.Text
+				default:
+					delta.Content += part.Text
+				}
+			}
+			var finishReason *string
+			if cand.FinishReason != "" {
+				finishReason = openai.StringPtr(geminiFinishReason(cand.FinishReason, toolCalls > 0))
+				out.Usage = geminiUsage(&gresp)
+			}
+			out.Choices = append(out.Choices, openai.ChunkChoice{Index: cand.Index, Delta: delta, FinishReason: finishReason})
+		}
+		if len(out.Choices) == 0 {
+			continue
+		}
+		started = true
+		if err := writeSSE(w, newJSONEvent("", out)); err != nil {
+			return err
+		}
+	}
+}
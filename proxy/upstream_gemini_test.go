@@ -0,0 +1,310 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// fakeGemini 本地的 Gemini 服务，generate 返回 generateContent / streamGenerateContent 的响应，收到的请求通过 last 读取
+type fakeGemini struct {
+	*httptest.Server
+	mu      sync.Mutex
+	url     *url.URL
+	request geminiRequest
+	header  http.Header
+}
+
+func newFakeGemini(t *testing.T, generate func(w http.ResponseWriter, r *http.Request)) *fakeGemini {
+	f := &fakeGemini{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req geminiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid generateContent request: %v", err)
+		}
+		f.mu.Lock()
+		f.url, f.request, f.header = r.URL, req, r.Header.Clone()
+		f.mu.Unlock()
+		generate(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeGemini) last() (*url.URL, geminiRequest, http.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.url, f.request, f.header
+}
+
+func newGeminiTestProxy(t *testing.T, f *fakeGemini) string {
+	_, srv := newTestProxy(t, Config{Upstreams: []UpstreamConfig{{
+		Name: "gemini", Type: UpstreamTypeGemini, TargetURL: f.URL,
+		Headers: map[string]string{"Authorization": "Bearer sk-goog"},
+	}}})
+	return srv.URL
+}
+
+const geminiHello = `{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"hello"}]},"finishReason":"STOP"}],` +
+	`"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":1,"totalTokenCount":4},"responseId":"r1"}`
+
+func TestGeminiRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, req geminiRequest)
+	}{
+		{"system and generation config", `{"model":"gemini-2.0-flash","temperature":0.2,"max_tokens":64,"stop":"END",
+			"messages":[{"role":"system","content":"be brief"},{"role":"developer","content":"no markdown"},{"role":"user","content":"hi"}]}`,
+			func(t *testing.T, req geminiRequest) {
+				if req.SystemInstruction == nil || len(req.SystemInstruction.Parts) != 2 || req.SystemInstruction.Parts[1].Text != "no markdown" {
+					t.Errorf("unexpected system instruction: %+v", req.SystemInstruction)
+				}
+				if len(req.Contents) != 1 || req.Contents[0].Role != "user" || req.Contents[0].Parts[0].Text != "hi" {
+					t.Errorf("unexpected contents: %+v", req.Contents)
+				}
+				gen := req.GenerationConfig
+				if gen == nil || gen.Temperature == nil || *gen.Temperature != 0.2 || gen.MaxOutputTokens == nil || *gen.MaxOutputTokens != 64 ||
+					len(gen.StopSequences) != 1 {
+					t.Errorf("unexpected generation config: %+v", gen)
+				}
+			}},
+		{"tool calls and results", `{"model":"gemini-2.0-flash","messages":[
+			{"role":"user","content":"weather?"},
+			{"role":"assistant","content":"checking","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+			{"role":"tool","tool_call_id":"call_1","content":"sunny"},
+			{"role":"tool","tool_call_id":"call_2","content":"{\"ok\":true}"}]}`,
+			func(t *testing.T, req geminiRequest) {
+				if len(req.Contents) != 3 {
+					t.Fatalf("unexpected contents: %+v", req.Contents)
+				}
+				model := req.Contents[1]
+				if model.Role != "model" || len(model.Parts) != 2 || model.Parts[1].FunctionCall == nil ||
+					model.Parts[1].FunctionCall.Name != "get_weather" || string(model.Parts[1].FunctionCall.Args) != `{"city":"Paris"}` {
+					t.Errorf("unexpected model content: %+v", model)
+				}
+				// 连续的工具结果合并为一条 user 内容，函数名从之前的调用中查找
+				results := req.Contents[2].Parts
+				if req.Contents[2].Role != "user" || len(results) != 2 {
+					t.Fatalf("unexpected results: %+v", req.Contents[2])
+				}
+				if fr := results[0].FunctionResponse; fr == nil || fr.Name != "get_weather" || string(fr.Response) != `{"result":"sunny"}` {
+					t.Errorf("unexpected function response: %+v", fr)
+				}
+				if fr := results[1].FunctionResponse; fr == nil || fr.Name != "call_2" || string(fr.Response) != `{"ok":true}` {
+					t.Errorf("unexpected function response: %+v", fr)
+				}
+			}},
+		{"images", `{"model":"gemini-2.0-flash","messages":[{"role":"user","content":[{"type":"text","text":"compare"},
+			{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}},{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`,
+			func(t *testing.T, req geminiRequest) {
+				parts := req.Contents[0].Parts
+				if len(parts) != 3 || parts[1].InlineData == nil || parts[1].InlineData.MimeType != "image/png" || parts[1].InlineData.Data != "AAAA" ||
+					parts[2].FileData == nil || parts[2].FileData.FileURI != "https://example.com/a.png" {
+					t.Errorf("unexpected parts: %+v", parts)
+				}
+			}},
+		{"tools and schema", `{"model":"gemini-2.0-flash","tool_choice":{"type":"function","function":{"name":"get_weather"}},
+			"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"$schema":"x","type":"object","additionalProperties":false}}}],
+			"response_format":{"type":"json_schema","json_schema":{"schema":{"type":"object","additionalProperties":false}}},
+			"messages":[{"role":"user","content":"hi"}]}`,
+			func(t *testing.T, req geminiRequest) {
+				if len(req.Tools) != 1 || len(req.Tools[0].FunctionDeclarations) != 1 ||
+					string(req.Tools[0].FunctionDeclarations[0].Parameters) != `{"type":"object"}` {
+					t.Errorf("unexpected tools: %+v", req.Tools)
+				}
+				if tc := req.ToolConfig; tc == nil || tc.FunctionCallingConfig.Mode != "ANY" ||
+					len(tc.FunctionCallingConfig.AllowedFunctionNames) != 1 {
+					t.Errorf("unexpected tool config: %+v", tc)
+				}
+				if gen := req.GenerationConfig; gen.ResponseMimeType != "application/json" || string(gen.ResponseSchema) != `{"type":"object"}` {
+					t.Errorf("unexpected response format: %+v", gen)
+				}
+			}},
+	}
+	f := newFakeGemini(t, func(w http.ResponseWriter, r *http.Request) { writeMaybeGzip(w, r, http.StatusOK, geminiHello) })
+	url := newGeminiTestProxy(t, f)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := postJSON(t, url+"/v1/chat/completions", tt.body, nil); status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			u, req, header := f.last()
+			if u.Path != "/v1beta/models/gemini-2.0-flash:generateContent" || header.Get("x-goog-api-key") != "sk-goog" || header.Get("Authorization") != "" {
+				t.Errorf("unexpected url %s, headers %v", u, header)
+			}
+			tt.check(t, req)
+		})
+	}
+}
+
+func TestGeminiResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  string
+		check  func(t *testing.T, body []byte)
+	}{
+		{"text", http.StatusOK, geminiHello, func(t *testing.T, body []byte) {
+			var resp openai.ChatCompletionResponse
+			decodeJSON(t, body, &resp)
+			if resp.Object != "chat.completion" || resp.ID != "chatcmpl-r1" || resp.Model != "gemini-2.0-flash" ||
+				resp.Choices[0].Message.Content != "hello" || resp.Choices[0].FinishReason != openai.FinishReasonStop || resp.Usage.TotalTokens != 4 {
+				t.Errorf("unexpected response: %s", body)
+			}
+		}},
+		{"thought and function call", http.StatusOK, `{"candidates":[{"index":0,"content":{"role":"model","parts":[
+			{"text":"hmm","thought":true},{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],
+			"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":2,"thoughtsTokenCount":5}}`, func(t *testing.T, body []byte) {
+			var resp openai.ChatCompletionResponse
+			decodeJSON(t, body, &resp)
+			choice := resp.Choices[0]
+			if choice.FinishReason != openai.FinishReasonToolCalls || choice.Message.ReasoningContent != "hmm" || len(choice.Message.ToolCalls) != 1 {
+				t.Fatalf("unexpected response: %s", body)
+			}
+			if call := choice.Message.ToolCalls[0]; call.ID == "" || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` {
+				t.Errorf("unexpected tool call: %+v", call)
+			}
+			// 思考消耗的 token 计入 completion_tokens
+			if resp.Usage.CompletionTokens != 7 || resp.Usage.TotalTokens != 10 {
+				t.Errorf("unexpected usage: %+v", resp.Usage)
+			}
+		}},
+		{"max tokens", http.StatusOK, `{"candidates":[{"index":0,"content":{"parts":[{"text":"he"}]},"finishReason":"MAX_TOKENS"}]}`,
+			func(t *testing.T, body []byte) {
+				var resp openai.ChatCompletionResponse
+				decodeJSON(t, body, &resp)
+				if resp.Choices[0].FinishReason != openai.FinishReasonLength {
+					t.Errorf("unexpected finish reason: %s", body)
+				}
+			}},
+		{"blocked prompt", http.StatusOK, `{"promptFeedback":{"blockReason":"SAFETY"}}`,
+			func(t *testing.T, body []byte) {
+				var resp openai.ChatCompletionResponse
+				decodeJSON(t, body, &resp)
+				if len(resp.Choices) != 1 || resp.Choices[0].FinishReason != openai.FinishReasonContentFilter {
+					t.Errorf("unexpected response: %s", body)
+				}
+			}},
+		{"rate limited", http.StatusTooManyRequests, `{"error":{"code":429,"message":"quota exceeded","status":"RESOURCE_EXHAUSTED"}}`,
+			func(t *testing.T, body []byte) {
+				var resp openai.ErrorResponse
+				decodeJSON(t, body, &resp)
+				if resp.Error.Type != "rate_limit_error" || resp.Error.Message != "quota exceeded" || resp.Error.Code != "RESOURCE_EXHAUSTED" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+		{"unknown error", http.StatusBadGateway, `bad gateway`,
+			func(t *testing.T, body []byte) {
+				if string(body) != "bad gateway" {
+					t.Errorf("unexpected error: %s", body)
+				}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeGemini(t, func(w http.ResponseWriter, r *http.Request) { writeMaybeGzip(w, r, tt.status, tt.reply) })
+			url := newGeminiTestProxy(t, f)
+
+			// 客户端声明接受 gzip 时响应也要先解压再转换
+			status, encoding, body := postGzip(t, url+"/v1/chat/completions", `{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"hi"}]}`)
+			if status != tt.status || encoding != "" {
+				t.Fatalf("status %d, encoding %q: %q", status, encoding, body)
+			}
+			tt.check(t, body)
+		})
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		check  func(t *testing.T, chunks []openai.ChatCompletionChunk, events []string)
+	}{
+		{"text and function call", []string{
+			`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Hel"}]}}]}`,
+			`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"lo"}]}}]}`,
+			`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]},"finishReason":"STOP"}],
+				"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":4}}`,
+		}, func(t *testing.T, chunks []openai.ChatCompletionChunk, _ []string) {
+			if len(chunks) != 3 {
+				t.Fatalf("unexpected chunks: %+v", chunks)
+			}
+			var content strings.Builder
+			for i, c := range chunks {
+				if c.ID != chunks[0].ID || c.Model != "gemini-2.0-flash" {
+					t.Errorf("unexpected chunk: %+v", c)
+				}
+				if role := c.Choices[0].Delta.Role; (i == 0) != (role == "assistant") {
+					t.Errorf("chunk %d has role %q", i, role)
+				}
+				content.WriteString(c.Choices[0].Delta.Content)
+			}
+			if content.String() != "Hello" {
+				t.Errorf("unexpected content %q", content.String())
+			}
+			last := chunks[2].Choices[0]
+			if len(last.Delta.ToolCalls) != 1 || last.Delta.ToolCalls[0].Index == nil || *last.Delta.ToolCalls[0].Index != 0 ||
+				last.Delta.ToolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+				t.Errorf("unexpected tool calls: %+v", last.Delta.ToolCalls)
+			}
+			if last.FinishReason == nil || *last.FinishReason != openai.FinishReasonToolCalls {
+				t.Errorf("unexpected finish reason: %v", last.FinishReason)
+			}
+			if chunks[2].Usage == nil || chunks[2].Usage.TotalTokens != 7 {
+				t.Errorf("unexpected usage: %+v", chunks[2].Usage)
+			}
+		}},
+		{"error event", []string{
+			`{"candidates":[{"index":0,"content":{"role":"model","parts":[{"text":"Hel"}]}}]}`,
+			`{"error":{"code":503,"message":"overloaded","status":"UNAVAILABLE"}}`,
+		}, func(t *testing.T, _ []openai.ChatCompletionChunk, events []string) {
+			var resp openai.ErrorResponse
+			decodeJSON(t, []byte(events[1]), &resp)
+			if resp.Error.Message != "overloaded" || resp.Error.Type != "server_error" {
+				t.Errorf("unexpected error event: %s", events[1])
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeGemini(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, data := range tt.events {
+					io.WriteString(w, "data: "+strings.Join(strings.Fields(data), " ")+"\n\n")
+					w.(http.Flusher).Flush()
+				}
+			})
+			url := newGeminiTestProxy(t, f)
+
+			status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"gemini-2.0-flash","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if u, _, _ := f.last(); u.Path != "/v1beta/models/gemini-2.0-flash:streamGenerateContent" || u.Query().Get("alt") != "sse" {
+				t.Errorf("unexpected url %s", u)
+			}
+			events := sseData(body)
+			if len(events) == 0 || events[len(events)-1] != sseDone {
+				t.Fatalf("unexpected events: %q", events)
+			}
+			var chunks []openai.ChatCompletionChunk
+			for _, data := range events[:len(events)-1] {
+				var chunk openai.ChatCompletionChunk
+				decodeJSON(t, []byte(data), &chunk)
+				if chunk.Object == "chat.completion.chunk" {
+					chunks = append(chunks, chunk)
+				}
+			}
+			tt.check(t, chunks, events)
+		})
+	}
+}
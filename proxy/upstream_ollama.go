@@ -0,0 +1,418 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// ollamaAdapter Ollama 原生 API 上游，把 chat/completions 请求转换为 /api/chat
+type ollamaAdapter struct {
+	target  *url.URL
+	headers map[string]string
+}
+
+// ollamaRequest /api/chat 请求
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Tools    []openai.Tool   `json:"tools,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+}
+
+// ollamaMessage 消息
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+// ollamaToolCall 工具调用，参数是 JSON 对象而不是字符串
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaOptions 生成参数
+type ollamaOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+}
+
+// ollamaResponse /api/chat 响应，流式响应的每一行也是这个结构
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// ollamaTags /api/tags 响应
+type ollamaTags struct {
+	Models []struct {
+		Name       string    `json:"name"`
+		Model      string    `json:"model"`
+		ModifiedAt time.Time `json:"modified_at"`
+	} `json:"models"`
+}
+
+// ollamaEmbedRequest /api/embed 请求
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// ollamaEmbedResponse /api/embed 响应
+type ollamaEmbedResponse struct {
+	Model           string                   `json:"model"`
+	Embeddings      []openai.EmbeddingVector `json:"embeddings"`
+	PromptEvalCount int                      `json:"prompt_eval_count"`
+}
+
+func (a *ollamaAdapter) RewriteRequest(req *http.Request, body []byte) ([]byte, error) {
+	// 响应需要解析后转换，不转发客户端的 Accept-Encoding，由 Transport 请求 gzip 并解压
+	req.Header.Del("Accept-Encoding")
+
+	switch strings.TrimPrefix(req.URL.Path, "/v1") {
+	case "/chat/completions":
+	case "/embeddings":
+		return a.rewriteEmbeddings(req, body)
+	default:
+		return nil, fmt.Errorf("path %s is not supported by ollama upstream", req.URL.Path)
+	}
+
+	var creq openai.ChatCompletionRequest
+	if err := json.Unmarshal(body, &creq); err != nil {
+		return nil, fmt.Errorf("invalid chat completion request: %w", err)
+	}
+
+	req.URL.Path = path.Join(a.target.Path, "/api/chat")
+	req.Header.Set("Content-Type", "application/json")
+	return json.Marshal(openAIToOllamaRequest(&creq))
+}
+
+// rewriteEmbeddings 把 embeddings 请求转换为 /api/embed，Ollama 只支持文本输入
+func (a *ollamaAdapter) rewriteEmbeddings(req *http.Request, body []byte) ([]byte, error) {
+	var ereq openai.EmbeddingRequest
+	if err := json.Unmarshal(body, &ereq); err != nil {
+		return nil, fmt.Errorf("invalid embeddings request: %w", err)
+	}
+	oreq := ollamaEmbedRequest{Model: ereq.Model}
+	var single string
+	if err := json.Unmarshal(ereq.Input, &single); err == nil {
+		oreq.Input = []string{single}
+	} else if err := json.Unmarshal(ereq.Input, &oreq.Input); err != nil {
+		return nil, fmt.Errorf("ollama upstream only supports text embeddings input")
+	}
+
+	req.URL.Path = path.Join(a.target.Path, "/api/embed")
+	req.Header.Set("Content-Type", "application/json")
+	return json.Marshal(oreq)
+}
+
+// RewriteProbe 健康检查请求 GET /api/tags
+func (a *ollamaAdapter) RewriteProbe(req *http.Request) {
+	req.URL.Path = path.Join(a.target.Path, "/api/tags")
+}
+
+// openAIToOllamaRequest 把 chat/completions 请求转换为 /api/chat 请求
+func openAIToOllamaRequest(creq *openai.ChatCompletionRequest) *ollamaRequest {
+	oreq := &ollamaRequest{
+		Model:  creq.Model,
+		Stream: creq.Stream,
+		Tools:  creq.Tools,
+		Options: &ollamaOptions{
+			Temperature:      creq.Temperature,
+			TopP:             creq.TopP,
+			Stop:             creq.Stop,
+			Seed:             creq.Seed,
+			PresencePenalty:  creq.PresencePenalty,
+			FrequencyPenalty: creq.FrequencyPenalty,
+		},
+	}
+	if maxTokens := creq.MaxOutputTokens(); maxTokens > 0 {
+		oreq.Options.NumPredict = &maxTokens
+	}
+
+	if len(creq.ResponseFormat) > 0 {
+		var format struct {
+			Type       string `json:"type"`
+			JSONSchema struct {
+				Schema json.RawMessage `json:"schema"`
+			} `json:"json_schema"`
+		}
+		if err := json.Unmarshal(creq.ResponseFormat, &format); err == nil {
+			switch {
+			case format.Type == "json_schema" && len(format.JSONSchema.Schema) > 0:
+				oreq.Format = format.JSONSchema.Schema
+			case format.Type == "json_object":
+				oreq.Format = json.RawMessage(`"json"`)
+			}
+		}
+	}
+
+	toolNames := make(map[string]string)
+	for _, msg := range creq.Messages {
+		omsg := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		if omsg.Role == "developer" {
+			omsg.Role = "system"
+		}
+		for _, part := range msg.Parts {
+			if part.Type != "image_url" || part.ImageURL == nil {
+				continue
+			}
+			if _, data, ok := parseDataURL(part.ImageURL.URL); ok {
+				omsg.Images = append(omsg.Images, data)
+			}
+		}
+		for _, tc := range msg.ToolCalls {
+			toolNames[tc.ID] = tc.Function.Name
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = toolInput(tc.Function.Arguments)
+			omsg.ToolCalls = append(omsg.ToolCalls, call)
+		}
+		if msg.Role == "tool" {
+			omsg.ToolName = toolNames[msg.ToolCallID]
+		}
+		oreq.Messages = append(oreq.Messages, omsg)
+	}
+	return oreq
+}
+
+func (a *ollamaAdapter) ModifyResponse(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusBadRequest {
+		return rewriteBody(resp, func(body []byte) []byte {
+			var oresp ollamaResponse
+			if err := json.Unmarshal(body, &oresp); err != nil || oresp.Error == "" {
+				return body
+			}
+			data, _ := json.Marshal(openai.NewErrorResponse(resp.StatusCode, oresp.Error, nil))
+			return data
+		})
+	}
+
+	if resp.Request != nil && strings.HasSuffix(resp.Request.URL.Path, "/api/embed") {
+		return rewriteBody(resp, ollamaEmbeddings)
+	}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "application/x-ndjson") {
+		resp.Body = pipeBody(resp.Body, translateOllamaStream)
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		resp.Header.Set("Content-Type", "text/event-stream")
+		return nil
+	}
+
+	return rewriteBody(resp, func(body []byte) []byte {
+		var oresp ollamaResponse
+		if err := json.Unmarshal(body, &oresp); err != nil {
+			return body
+		}
+		msg := openai.Message{
+			Role:             "assistant",
+			Content:          oresp.Message.Content,
+			ReasoningContent: oresp.Message.Thinking,
+			ToolCalls:        ollamaToolCalls(oresp.Message.ToolCalls, 0, false),
+		}
+		out := openai.ChatCompletionResponse{
+			ID:      fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+			Object:  "chat.completion",
+			Created: ollamaCreated(&oresp),
+			Model:   oresp.Model,
+			Choices: []openai.Choice{{
+				Index:        0,
+				Message:      msg,
+				FinishReason: ollamaFinishReason(oresp.DoneReason, len(msg.ToolCalls) > 0),
+			}},
+			Usage: ollamaUsage(&oresp),
+		}
+		data, _ := json.Marshal(out)
+		return data
+	})
+}
+
+// ollamaEmbeddings 把 /api/embed 响应转换为 embeddings 响应
+func ollamaEmbeddings(body []byte) []byte {
+	var oresp ollamaEmbedResponse
+	if err := json.Unmarshal(body, &oresp); err != nil {
+		return body
+	}
+	out := openai.EmbeddingResponse{
+		Object: "list",
+		Data:   make([]openai.Embedding, 0, len(oresp.Embeddings)),
+		Model:  oresp.Model,
+		Usage:  openai.EmbeddingUsage{PromptTokens: oresp.PromptEvalCount, TotalTokens: oresp.PromptEvalCount},
+	}
+	for i, emb := range oresp.Embeddings {
+		out.Data = append(out.Data, openai.Embedding{Object: "embedding", Index: i, Embedding: emb})
+	}
+	data, _ := json.Marshal(out)
+	return data
+}
+
+// ollamaToolCalls 把工具调用转换为 tool_calls，Ollama 不返回调用 ID，需要生成
+func ollamaToolCalls(calls []ollamaToolCall, start int, withIndex bool) []openai.ToolCall {
+	var out []openai.ToolCall
+	for i, call := range calls {
+		tc := openai.ToolCall{
+			ID:       fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), start+i),
+			Type:     "function",
+			Function: openai.FunctionCall{Name: call.Function.Name, Arguments: string(toolInput(string(call.Function.Arguments)))},
+		}
+		if withIndex {
+			index := start + i
+			tc.Index = &index
+		}
+		out = append(out, tc)
+	}
+	return out
+}
+
+func ollamaFinishReason(doneReason string, hasToolCalls bool) string {
+	if hasToolCalls {
+		return openai.FinishReasonToolCalls
+	}
+	if doneReason == "length" {
+		return openai.FinishReasonLength
+	}
+	return openai.FinishReasonStop
+}
+
+func ollamaUsage(oresp *ollamaResponse) *openai.Usage {
+	return &openai.Usage{
+		PromptTokens:     oresp.PromptEvalCount,
+		CompletionTokens: oresp.EvalCount,
+		TotalTokens:      oresp.PromptEvalCount + oresp.EvalCount,
+	}
+}
+
+func ollamaCreated(oresp *ollamaResponse) int64 {
+	if oresp.CreatedAt.IsZero() {
+		return time.Now().Unix()
+	}
+	return oresp.CreatedAt.Unix()
+}
+
+// translateOllamaStream 把 /api/chat 的 NDJSON 流转换为 chat.completion.chunk SSE 事件
+func translateOllamaStream(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	chunk := openai.ChatCompletionChunk{
+		ID:     fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano()),
+		Object: "chat.completion.chunk",
+	}
+	started := false
+	toolCalls := 0
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var oresp ollamaResponse
+		if err := json.Unmarshal([]byte(line), &oresp); err != nil {
+			continue
+		}
+		if oresp.Error != "" {
+			if err := writeSSE(w, newJSONEvent("", openai.NewErrorResponse(http.StatusInternalServerError, oresp.Error, nil))); err != nil {
+				return err
+			}
+			continue
+		}
+
+		out := chunk
+		out.Model = oresp.Model
+		out.Created = ollamaCreated(&oresp)
+		delta := openai.Delta{
+			Content:          oresp.Message.Content,
+			ReasoningContent: oresp.Message.Thinking,
+			ToolCalls:        ollamaToolCalls(oresp.Message.ToolCalls, toolCalls, true),
+		}
+		toolCalls += len(oresp.Message.ToolCalls)
+		if !started {
+			delta.Role = "assistant"
+			started = true
+		}
+		var finishReason *string
+		if oresp.Done {
+			finishReason = openai.StringPtr(ollamaFinishReason(oresp.DoneReason, toolCalls > 0))
+			out.Usage = ollamaUsage(&oresp)
+		}
+		out.Choices = []openai.ChunkChoice{{Index: 0, Delta: delta, FinishReason: finishReason}}
+		if err := writeSSE(w, newJSONEvent("", out)); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return writeSSE(w, sseEvent{Data: []byte(sseDone)})
+}
+
+// ListModels 通过 /api/tags 列出本地模型
+func (a *ollamaAdapter) ListModels(ctx context.Context, client *http.Client) ([]ModelInfo, error) {
+	tagsURL := *a.target
+	tagsURL.Path = path.Join(a.target.Path, "/api/tags")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tagsURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range a.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list ollama models: %s", resp.Status)
+	}
+
+	var tags ollamaTags
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	models := make([]ModelInfo, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		id := m.Model
+		if id == "" {
+			id = m.Name
+		}
+		models = append(models, ModelInfo{
+			ID:      id,
+			Object:  "model",
+			Created: m.ModifiedAt.Unix(),
+			OwnedBy: "ollama",
+		})
+	}
+	return models, nil
+}
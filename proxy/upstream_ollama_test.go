@@ -0,0 +1,301 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bagaking/openapi-proxy/openai"
+)
+
+// fakeOllama 本地的 Ollama 服务，chat 返回 /api/chat 的响应体，请求体通过 requests 记录
+type fakeOllama struct {
+	*httptest.Server
+	requests map[string][]byte
+}
+
+func newFakeOllama(t *testing.T, chat func(w http.ResponseWriter, req ollamaRequest)) *fakeOllama {
+	f := &fakeOllama{requests: make(map[string][]byte)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f.requests[r.URL.Path] = body
+		switch r.URL.Path {
+		case "/api/chat":
+			var req ollamaRequest
+			if err := json.Unmarshal(body, &req); err != nil {
+				t.Errorf("invalid /api/chat request: %v", err)
+			}
+			chat(w, req)
+		case "/api/embed":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"model":"nomic","embeddings":[[0.1,0.2],[0.3,0.4]],"prompt_eval_count":6}`)
+		case "/api/tags":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"models":[{"name":"llama3:latest","model":"llama3:latest","modified_at":"2024-05-01T00:00:00Z"},{"name":"qwen2"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newOllamaTestProxy(t *testing.T, f *fakeOllama) string {
+	_, srv := newTestProxy(t, Config{Upstreams: []UpstreamConfig{{Name: "ollama", Type: UpstreamTypeOllama, TargetURL: f.URL}}})
+	return srv.URL
+}
+
+func writeNDJSON(w http.ResponseWriter, lines ...string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, line := range lines {
+		io.WriteString(w, line+"\n")
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	f := newFakeOllama(t, func(w http.ResponseWriter, req ollamaRequest) {
+		if req.Model != "llama3" || req.Stream || len(req.Messages) != 2 || req.Messages[0].Role != "system" {
+			t.Errorf("unexpected request: %+v", req)
+		}
+		if req.Options == nil || req.Options.NumPredict == nil || *req.Options.NumPredict != 32 {
+			t.Errorf("max_tokens not mapped to num_predict: %+v", req.Options)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"llama3","created_at":"2024-05-01T00:00:00Z","message":{"role":"assistant","content":"hi"},"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`)
+	})
+	url := newOllamaTestProxy(t, f)
+
+	status, body := postJSON(t, url+"/v1/chat/completions",
+		`{"model":"llama3","max_tokens":32,"messages":[{"role":"developer","content":"be brief"},{"role":"user","content":"hello"}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var resp openai.ChatCompletionResponse
+	decodeJSON(t, body, &resp)
+	if resp.Object != "chat.completion" || len(resp.Choices) != 1 {
+		t.Fatalf("unexpected response: %s", body)
+	}
+	if got := resp.Choices[0]; got.Message.Content != "hi" || got.FinishReason != openai.FinishReasonStop {
+		t.Errorf("unexpected choice: %+v", got)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 5 || resp.Usage.CompletionTokens != 2 || resp.Usage.TotalTokens != 7 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+}
+
+func TestOllamaChatToolCalls(t *testing.T) {
+	f := newFakeOllama(t, func(w http.ResponseWriter, req ollamaRequest) {
+		if len(req.Tools) != 1 {
+			t.Errorf("tools not forwarded: %+v", req.Tools)
+		}
+		// 工具结果消息带上工具名
+		if last := req.Messages[len(req.Messages)-1]; last.Role == "tool" && last.ToolName != "get_weather" {
+			t.Errorf("tool message without tool_name: %+v", last)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"llama3","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":true}`)
+	})
+	url := newOllamaTestProxy(t, f)
+
+	status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"llama3",
+		"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object"}}}],
+		"messages":[{"role":"user","content":"weather?"},
+			{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Rome\"}"}}]},
+			{"role":"tool","tool_call_id":"call_1","content":"sunny"}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var sent ollamaRequest
+	decodeJSON(t, f.requests["/api/chat"], &sent)
+	if args := string(sent.Messages[1].ToolCalls[0].Function.Arguments); args != `{"city":"Rome"}` {
+		t.Errorf("tool call arguments should be a JSON object, got %s", args)
+	}
+
+	var resp openai.ChatCompletionResponse
+	decodeJSON(t, body, &resp)
+	choice := resp.Choices[0]
+	if choice.FinishReason != openai.FinishReasonToolCalls || len(choice.Message.ToolCalls) != 1 {
+		t.Fatalf("unexpected choice: %s", body)
+	}
+	call := choice.Message.ToolCalls[0]
+	if call.ID == "" || call.Type != "function" || call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool call: %+v", call)
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	f := newFakeOllama(t, func(w http.ResponseWriter, req ollamaRequest) {
+		if !req.Stream {
+			t.Error("stream not forwarded")
+		}
+		writeNDJSON(w,
+			`{"model":"llama3","message":{"role":"assistant","content":"Hel"},"done":false}`,
+			`{"model":"llama3","message":{"role":"assistant","content":"lo"},"done":false}`,
+			`{"model":"llama3","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"lookup","arguments":{"q":"x"}}}]},"done":false}`,
+			`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":4}`,
+		)
+	})
+	url := newOllamaTestProxy(t, f)
+
+	status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"llama3","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	events := sseData(body)
+	if len(events) != 5 || events[4] != "[DONE]" {
+		t.Fatalf("unexpected events: %q", events)
+	}
+	var content strings.Builder
+	var chunks []openai.ChatCompletionChunk
+	for _, data := range events[:4] {
+		var chunk openai.ChatCompletionChunk
+		decodeJSON(t, []byte(data), &chunk)
+		if chunk.Object != "chat.completion.chunk" || len(chunk.Choices) != 1 {
+			t.Fatalf("unexpected chunk: %s", data)
+		}
+		content.WriteString(chunk.Choices[0].Delta.Content)
+		chunks = append(chunks, chunk)
+	}
+	if content.String() != "Hello" || chunks[0].Choices[0].Delta.Role != "assistant" {
+		t.Errorf("unexpected content %q, first delta %+v", content.String(), chunks[0].Choices[0].Delta)
+	}
+	calls := chunks[2].Choices[0].Delta.ToolCalls
+	if len(calls) != 1 || calls[0].Index == nil || *calls[0].Index != 0 || calls[0].Function.Arguments != `{"q":"x"}` {
+		t.Errorf("unexpected tool call delta: %+v", calls)
+	}
+	last := chunks[3]
+	if fr := last.Choices[0].FinishReason; fr == nil || *fr != openai.FinishReasonToolCalls {
+		t.Errorf("unexpected finish reason: %v", fr)
+	}
+	if last.Usage == nil || last.Usage.TotalTokens != 7 {
+		t.Errorf("unexpected usage: %+v", last.Usage)
+	}
+}
+
+func TestOllamaStreamError(t *testing.T) {
+	f := newFakeOllama(t, func(w http.ResponseWriter, req ollamaRequest) {
+		writeNDJSON(w,
+			`{"model":"llama3","message":{"role":"assistant","content":"partial"},"done":false}`,
+			`{"error":"model runner crashed"}`,
+		)
+	})
+	url := newOllamaTestProxy(t, f)
+
+	status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"llama3","stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	events := sseData(body)
+	if len(events) != 3 || events[2] != "[DONE]" {
+		t.Fatalf("unexpected events: %q", events)
+	}
+	var errResp openai.ErrorResponse
+	decodeJSON(t, []byte(events[1]), &errResp)
+	if errResp.Error.Message != "model runner crashed" || errResp.Error.Type != "server_error" {
+		t.Errorf("unexpected error event: %s", events[1])
+	}
+}
+
+func TestOllamaErrorResponse(t *testing.T) {
+	f := newFakeOllama(t, func(w http.ResponseWriter, req ollamaRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":"model \"nope\" not found"}`)
+	})
+	url := newOllamaTestProxy(t, f)
+
+	status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"nope","messages":[{"role":"user","content":"hi"}]}`, nil)
+	var errResp openai.ErrorResponse
+	decodeJSON(t, body, &errResp)
+	if status != http.StatusNotFound || errResp.Error.Message != `model "nope" not found` || errResp.Error.Type != "not_found_error" {
+		t.Errorf("unexpected error response %d: %s", status, body)
+	}
+}
+
+func TestOllamaModels(t *testing.T) {
+	f := newFakeOllama(t, nil)
+	url := newOllamaTestProxy(t, f)
+
+	resp, err := http.Get(url + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var models ModelsResponse
+	decodeJSON(t, body, &models)
+	ids := make(map[string]string)
+	for _, m := range models.Data {
+		ids[m.ID] = m.OwnedBy
+	}
+	if ids["llama3:latest"] != "ollama" || ids["qwen2"] != "ollama" {
+		t.Errorf("ollama models not listed: %s", body)
+	}
+}
+
+func TestOllamaEmbeddings(t *testing.T) {
+	f := newFakeOllama(t, nil)
+	url := newOllamaTestProxy(t, f)
+
+	status, body := postJSON(t, url+"/v1/embeddings", `{"model":"nomic","input":["a","b"]}`, nil)
+	if status != http.StatusOK {
+		t.Fatalf("status %d: %s", status, body)
+	}
+	var sent ollamaEmbedRequest
+	decodeJSON(t, f.requests["/api/embed"], &sent)
+	if sent.Model != "nomic" || len(sent.Input) != 2 || sent.Input[1] != "b" {
+		t.Errorf("unexpected /api/embed request: %s", f.requests["/api/embed"])
+	}
+	var resp openai.EmbeddingResponse
+	decodeJSON(t, body, &resp)
+	if len(resp.Data) != 2 || resp.Data[1].Index != 1 || resp.Data[1].Embedding[0] != 0.3 || resp.Usage.PromptTokens != 6 {
+		t.Errorf("unexpected embeddings response: %s", body)
+	}
+
+	// token 数组不能转发给 Ollama
+	status, body = postJSON(t, url+"/v1/embeddings", `{"model":"nomic","input":[[1,2,3]]}`, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("token input should be rejected, got %d: %s", status, body)
+	}
+}
+
+func TestOllamaGzipUpstream(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/chat":
+			writeMaybeGzip(w, r, http.StatusOK, `{"model":"llama3","message":{"role":"assistant","content":"hello"},"done":true,"done_reason":"stop"}`)
+		case "/api/embed":
+			writeMaybeGzip(w, r, http.StatusOK, `{"model":"nomic","embeddings":[[0.1,0.2]],"prompt_eval_count":1}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer up.Close()
+	url := newOllamaTestProxy(t, &fakeOllama{Server: up})
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		object string
+	}{
+		{"chat", "/v1/chat/completions", `{"model":"llama3","messages":[{"role":"user","content":"hi"}]}`, `"object":"chat.completion"`},
+		{"embeddings", "/v1/embeddings", `{"model":"nomic","input":"a"}`, `"object":"list"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 客户端声明接受 gzip 时响应也要先解压再转换
+			status, encoding, body := postGzip(t, url+tt.path, tt.body)
+			if status != http.StatusOK || encoding != "" {
+				t.Fatalf("status %d, encoding %q: %q", status, encoding, body)
+			}
+			if !strings.Contains(string(body), tt.object) {
+				t.Errorf("response not translated: %s", body)
+			}
+		})
+	}
+}
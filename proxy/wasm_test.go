@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// WASM 指令，测试模块直接写出字节码，不依赖编译工具
+var (
+	wasmReturnZero = []byte{0x42, 0x00}                   // i64.const 0，不做修改
+	wasmLoop       = []byte{0x03, 0x40, 0x0c, 0x00, 0x0b} // loop br 0 end，死循环
+	// 输入超过 1000 字节时进入死循环
+	wasmLoopIfLong = concatBytes([]byte{0x20, 0x01, 0x41, 0xe8, 0x07, 0x4b, 0x04, 0x40}, wasmLoop, []byte{0x0b}, wasmReturnZero)
+	// memory.grow 256 页（16MB），失败时 unreachable
+	wasmGrow16MB = concatBytes([]byte{0x41, 0x80, 0x02, 0x40, 0x00, 0x41, 0x7f, 0x46, 0x04, 0x40, 0x00, 0x0b}, wasmReturnZero)
+)
+
+func concatBytes(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+// uleb128 无符号 LEB128 编码
+func uleb128(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// wasmSection 带长度的段
+func wasmSection(id byte, content ...[]byte) []byte {
+	body := concatBytes(content...)
+	return concatBytes([]byte{id}, uleb128(len(body)), body)
+}
+
+// wasmName 带长度的名称
+func wasmName(name string) []byte {
+	return append(uleb128(len(name)), name...)
+}
+
+// buildWasmModule 构造导出 memory、malloc（固定返回 1024）和 before_request 的模块，before_request 的函数体为 code
+func buildWasmModule(code []byte) []byte {
+	malloc := []byte{0x00, 0x41, 0x80, 0x08, 0x0b} // 无局部变量，i32.const 1024
+	hook := concatBytes([]byte{0x00}, code, []byte{0x0b})
+	return concatBytes(
+		[]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+		// (i32) -> i32 和 (i32, i32) -> i64
+		wasmSection(0x01, []byte{0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e}),
+		wasmSection(0x03, []byte{0x02, 0x00, 0x01}),
+		wasmSection(0x05, []byte{0x01, 0x00, 0x01}), // 1 页内存，不限制最大值
+		wasmSection(0x07, []byte{0x03},
+			wasmName("memory"), []byte{0x02, 0x00},
+			wasmName("malloc"), []byte{0x00, 0x00},
+			wasmName("before_request"), []byte{0x00, 0x01}),
+		wasmSection(0x0a, []byte{0x02}, uleb128(len(malloc)), malloc, uleb128(len(hook)), hook),
+	)
+}
+
+// newWasmProxy 创建只注册了 WASM 插件的代理，config 是插件配置中 path 之外的字段
+func newWasmProxy(t *testing.T, upstream string, code []byte, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "guard.wasm")
+	if err := os.WriteFile(path, buildWasmModule(code), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(path)
+	conf := `{"path":` + string(raw)
+	if config != "" {
+		conf += "," + config
+	}
+	conf += "}"
+	_, srv := newTestProxy(t, Config{
+		TargetURL: upstream,
+		Plugins:   []PluginSpec{{Type: "wasm", Config: json.RawMessage(conf)}},
+	})
+	return srv.URL
+}
+
+func TestWasmTimeout(t *testing.T) {
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	short := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+	long := `{"model":"gpt-4o","messages":[{"role":"user","content":"` + strings.Repeat("a", 2000) + `"}]}`
+
+	tests := []struct {
+		name   string
+		config string
+		body   string
+		status int
+	}{
+		{"short input", `"timeout_ms":50`, short, http.StatusOK},
+		{"timeout", `"timeout_ms":50`, long, http.StatusInternalServerError},
+		{"fail open", `"timeout_ms":50,"fail_open":true`, long, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := newWasmProxy(t, f.URL, wasmLoopIfLong, tt.config)
+			start := time.Now()
+			status, body := postJSON(t, url+"/v1/chat/completions", tt.body, nil)
+			if status != tt.status {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if status != http.StatusOK && !strings.Contains(string(body), "wasm:guard: before_request") {
+				t.Errorf("error %s, want the plugin and hook name", body)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("module ran for %v", elapsed)
+			}
+			// 超时的实例被销毁，之后的请求使用新的实例
+			if status, body := postJSON(t, url+"/v1/chat/completions", short, nil); status != http.StatusOK {
+				t.Errorf("after %s: status %d: %s", tt.name, status, body)
+			}
+		})
+	}
+
+	// 超时后释放执行的名额，只有一个实例时之后的调用同样执行到超时，而不是等不到空闲的实例
+	url := newWasmProxy(t, f.URL, concatBytes(wasmLoop, wasmReturnZero), `"timeout_ms":20,"max_instances":1`)
+	for i := 0; i < 3; i++ {
+		status, body := postJSON(t, url+"/v1/chat/completions", short, nil)
+		if status != http.StatusInternalServerError || !strings.Contains(string(body), "deadline exceeded") || strings.Contains(string(body), "no free instance") {
+			t.Fatalf("call %d: status %d: %s", i, status, body)
+		}
+	}
+}
+
+func TestWasmMemoryLimit(t *testing.T) {
+	f := newFakeChat(t, replyJSON(http.StatusOK, chatHello))
+	tests := []struct {
+		name   string
+		config string
+		status int
+	}{
+		{"over limit", `"memory_limit_mb":8`, http.StatusInternalServerError},
+		{"within limit", `"memory_limit_mb":32`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := newWasmProxy(t, f.URL, wasmGrow16MB, tt.config)
+			status, body := postJSON(t, url+"/v1/chat/completions", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+			if status != tt.status {
+				t.Errorf("status %d: %s", status, body)
+			}
+		})
+	}
+}
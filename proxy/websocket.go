@@ -0,0 +1,394 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/bagaking/openapi-proxy/openai"
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
+)
+
+const (
+	// wsKeyProtocolPrefix 浏览器无法设置 header，OpenAI Realtime 允许通过子协议传递 API key
+	wsKeyProtocolPrefix = "openai-insecure-api-key."
+	// maxClosedSessions 保留的已结束会话统计数量
+	maxClosedSessions = 100
+)
+
+// wsHopHeaders 建立上游 WebSocket 连接时由 Dialer 生成、不能从客户端请求复制的 header
+var wsHopHeaders = []string{
+	"Connection",
+	"Upgrade",
+	"Host",
+	"Origin",
+	"Referer",
+	"Content-Length",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+}
+
+// SessionStats WebSocket 会话统计
+type SessionStats struct {
+	ID               string         `json:"id"`
+	Path             string         `json:"path"`
+	Model            string         `json:"model"`
+	Upstream         string         `json:"upstream"`
+	StartedAt        time.Time      `json:"started_at"`
+	Duration         time.Duration  `json:"duration"`
+	ClientMessages   int64          `json:"client_messages"`
+	ClientBytes      int64          `json:"client_bytes"`
+	UpstreamMessages int64          `json:"upstream_messages"`
+	UpstreamBytes    int64          `json:"upstream_bytes"`
+	Events           map[string]int `json:"events"` // 按 type 字段统计的 JSON 事件数
+	Closed           bool           `json:"closed"`
+	CloseCode        int            `json:"close_code,omitempty"`
+}
+
+// wsSession 进行中的会话
+type wsSession struct {
+	stats            SessionStats
+	clientMessages   atomic.Int64
+	clientBytes      atomic.Int64
+	upstreamMessages atomic.Int64
+	upstreamBytes    atomic.Int64
+
+	mu     sync.Mutex
+	events map[string]int
+
+	plugins []pluginPKG.Plugin // 路由的插件链，事件交给其中的 EventPlugin
+}
+
+func (s *wsSession) snapshot() SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	if !stats.Closed {
+		stats.Duration = time.Since(stats.StartedAt)
+	}
+	stats.ClientMessages = s.clientMessages.Load()
+	stats.ClientBytes = s.clientBytes.Load()
+	stats.UpstreamMessages = s.upstreamMessages.Load()
+	stats.UpstreamBytes = s.upstreamBytes.Load()
+	stats.Events = make(map[string]int, len(s.events))
+	for k, v := range s.events {
+		stats.Events[k] = v
+	}
+	return stats
+}
+
+// sessionRegistry 记录进行中和最近结束的会话
+type sessionRegistry struct {
+	mu     sync.Mutex
+	active map[string]*wsSession
+	closed []SessionStats
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{active: make(map[string]*wsSession)}
+}
+
+func (r *sessionRegistry) add(s *wsSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active[s.stats.ID] = s
+}
+
+func (r *sessionRegistry) finish(s *wsSession, closeCode int) SessionStats {
+	s.mu.Lock()
+	s.stats.Closed = true
+	s.stats.CloseCode = closeCode
+	s.stats.Duration = time.Since(s.stats.StartedAt)
+	s.mu.Unlock()
+	stats := s.snapshot()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, s.stats.ID)
+	r.closed = append(r.closed, stats)
+	if len(r.closed) > maxClosedSessions {
+		r.closed = r.closed[len(r.closed)-maxClosedSessions:]
+	}
+	return stats
+}
+
+// WebSocketSessions 返回进行中和最近结束的 WebSocket 会话统计
+func (p *Proxy) WebSocketSessions() []SessionStats {
+	p.sessions.mu.Lock()
+	defer p.sessions.mu.Unlock()
+	out := make([]SessionStats, 0, len(p.sessions.active)+len(p.sessions.closed))
+	for _, s := range p.sessions.active {
+		out = append(out, s.snapshot())
+	}
+	return append(out, p.sessions.closed...)
+}
+
+// handleWebSocket 代理 WebSocket 升级请求（如 Realtime API）：校验客户端、注入上游凭证、双向转发消息
+func (p *Proxy) handleWebSocket(c *gin.Context) {
+	req := c.Request
+	model := req.URL.Query().Get("model")
+
+	// 与普通请求一样记录到流量统计，耗时为整个会话的时长
+	tracked := p.traffic.track(c, req.URL.Path)
+	tracked.route(requestMeta{Model: model}, "")
+	defer func() {
+		if err := recover(); err != nil {
+			tracked.done(true)
+			panic(err)
+		}
+		tracked.done(false)
+	}()
+
+	// 1. 从子协议中取出 API key，其余子协议转发给上游
+	var protocols []string
+	protocolKey := ""
+	for _, proto := range websocket.Subprotocols(req) {
+		if strings.HasPrefix(proto, wsKeyProtocolPrefix) {
+			protocolKey = strings.TrimPrefix(proto, wsKeyProtocolPrefix)
+			continue
+		}
+		protocols = append(protocols, proto)
+	}
+
+	// 2. 校验客户端
+	clientAuth := req.Header.Get("Authorization")
+	if clientAuth == "" && protocolKey != "" {
+		clientAuth = "Bearer " + protocolKey
+	}
+	if len(p.config.AccessKeys) > 0 && !p.validAccessKey(clientAuth, req.Header.Get("api-key")) {
+		p.logger.Error("WebSocket client authentication failed:", req.URL.Path)
+		c.JSON(http.StatusUnauthorized, openai.NewErrorResponse(http.StatusUnauthorized, "invalid access key", nil))
+		return
+	}
+
+	// 3. 按路径和模型匹配路由，交给 AdmitPlugin 检查；插件看到的请求带有客户端的密钥，子协议中的密钥转换为 Authorization
+	stub, _ := json.Marshal(requestMeta{Model: model})
+	checked := req
+	if req.Header.Get("Authorization") == "" && clientAuth != "" {
+		checked = req.Clone(req.Context())
+		checked.Header.Set("Authorization", clientAuth)
+	}
+	pctx := pluginPKG.NewRequestContext(checked, stub)
+	route := p.matchRoute(pctx, req.URL.Path)
+	plugins := p.routePlugins(route)
+	if !p.admit(c, plugins, pctx) {
+		return
+	}
+
+	// 4. 选择上游，改写路径和认证头；配置了访问密钥时上游只使用配置中的凭证
+	if len(p.config.AccessKeys) > 0 {
+		clientAuth = ""
+		req.Header.Del("Authorization")
+		req.Header.Del("api-key")
+	}
+	upstream := p.routeUpstream(route, model)
+	if upstream == nil {
+		p.logger.Error("No upstream available for model:", model)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "no upstream available"})
+		return
+	}
+	tracked.route(requestMeta{Model: model}, upstream.Config.Name)
+	upstream.applyHeaders(req, clientAuth, p.logger)
+	if _, err := upstream.adapter.RewriteRequest(req, stub); err != nil {
+		p.logger.Error("Failed to rewrite request for upstream:", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target := *req.URL
+	target.Host = upstream.target.Host
+	target.Scheme = "ws"
+	if upstream.target.Scheme == "https" {
+		target.Scheme = "wss"
+	}
+	header := req.Header.Clone()
+	for _, h := range wsHopHeaders {
+		header.Del(h)
+	}
+
+	// 5. 先连接上游，失败时把上游的响应返回给客户端
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     protocols,
+	}
+	// 使用上游连接配置中的代理和 TLS 配置
+	if upstream.transport.Proxy != nil {
+		dialer.Proxy = upstream.transport.Proxy
+	}
+	if upstream.transport.TLSClientConfig != nil {
+		dialer.TLSClientConfig = upstream.transport.TLSClientConfig.Clone()
+	}
+	p.logger.Info("Proxying WebSocket to:", target.Scheme+"://"+target.Host+target.Path)
+	upConn, resp, err := dialer.DialContext(req.Context(), target.String(), header)
+	if err != nil {
+		p.logger.Error("Failed to dial upstream WebSocket:", err)
+		if resp != nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+			return
+		}
+		c.JSON(http.StatusBadGateway, openai.NewErrorResponse(http.StatusBadGateway, err.Error(), nil))
+		return
+	}
+	defer upConn.Close()
+
+	// 6. 升级客户端连接，子协议使用上游选择的结果
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	var respHeader http.Header
+	if proto := upConn.Subprotocol(); proto != "" {
+		respHeader = http.Header{"Sec-Websocket-Protocol": {proto}}
+	}
+	clientConn, err := upgrader.Upgrade(c.Writer, req, respHeader)
+	if err != nil {
+		p.logger.Error("Failed to upgrade client connection:", err)
+		return
+	}
+	defer clientConn.Close()
+	// 升级后连接已被接管，101 响应不经过 WriteHeader
+	tracked.record.Status = http.StatusSwitchingProtocols
+
+	// 7. 双向转发，任一方向结束时关闭两端
+	session := &wsSession{
+		stats: SessionStats{
+			ID:        newID("ws"),
+			Path:      req.URL.Path,
+			Model:     model,
+			Upstream:  upstream.Config.Name,
+			StartedAt: time.Now(),
+		},
+		events:  make(map[string]int),
+		plugins: plugins,
+	}
+	p.sessions.add(session)
+	p.inflight.markWebSocket(req.Context())
+	p.logger.Info("WebSocket session started:", session.stats.ID)
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- p.relayWebSocket(req, session, clientConn, upConn, pluginPKG.EventFromClient)
+	}()
+	go func() {
+		errc <- p.relayWebSocket(req, session, upConn, clientConn, pluginPKG.EventFromUpstream)
+	}()
+	pending := 2
+	select {
+	case err = <-errc:
+		pending--
+	case <-p.inflight.drainingCh():
+		// 开始关闭服务时通知两端后断开
+		err = closeGoingAway(clientConn, upConn)
+	case <-req.Context().Done():
+		err = closeGoingAway(clientConn, upConn)
+	}
+	closeCode := 0
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		closeCode = closeErr.Code
+	}
+	clientConn.Close()
+	upConn.Close()
+	for ; pending > 0; pending-- {
+		<-errc
+	}
+
+	stats := p.sessions.finish(session, closeCode)
+	p.logger.Info(fmt.Sprintf("WebSocket session closed: %s duration=%v client=%d msgs/%d bytes upstream=%d msgs/%d bytes code=%d",
+		stats.ID, stats.Duration, stats.ClientMessages, stats.ClientBytes,
+		stats.UpstreamMessages, stats.UpstreamBytes, stats.CloseCode))
+}
+
+// closeGoingAway 向两端发送 1001 关闭帧
+func closeGoingAway(conns ...*websocket.Conn) error {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for _, conn := range conns {
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+	return &websocket.CloseError{Code: websocket.CloseGoingAway, Text: "server shutting down"}
+}
+
+// relayWebSocket 把 src 的消息转发到 dst，JSON 文本消息交给插件观察；src 关闭时把关闭帧转发给 dst
+func (p *Proxy) relayWebSocket(req *http.Request, session *wsSession, src, dst *websocket.Conn, direction string) error {
+	messages, size := &session.clientMessages, &session.clientBytes
+	if direction == pluginPKG.EventFromUpstream {
+		messages, size = &session.upstreamMessages, &session.upstreamBytes
+	}
+
+	for {
+		msgType, data, err := src.ReadMessage()
+		if err != nil {
+			code, text := websocket.CloseGoingAway, ""
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				code, text = closeErr.Code, closeErr.Text
+				// 1005、1006 不能出现在关闭帧中
+				switch code {
+				case websocket.CloseNoStatusReceived:
+					code = websocket.CloseNormalClosure
+				case websocket.CloseAbnormalClosure:
+					code = websocket.CloseGoingAway
+				}
+			}
+			_ = dst.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+			return err
+		}
+		messages.Add(1)
+		size.Add(int64(len(data)))
+		if msgType == websocket.TextMessage && json.Valid(data) {
+			p.observeEvent(req, session, direction, data)
+		}
+		if err := dst.WriteMessage(msgType, data); err != nil {
+			return err
+		}
+	}
+}
+
+// observeEvent 统计事件类型并通知路由插件链中实现了 EventPlugin 的插件
+func (p *Proxy) observeEvent(req *http.Request, session *wsSession, direction string, data []byte) {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &event); err == nil && event.Type != "" {
+		session.mu.Lock()
+		session.events[event.Type]++
+		session.mu.Unlock()
+	}
+
+	for _, plugin := range session.plugins {
+		if ep, ok := plugin.(pluginPKG.EventPlugin); ok {
+			ep.OnEvent(req, direction, data)
+		}
+	}
+}
+
+// validAccessKey 校验客户端携带的访问密钥，支持 Authorization: Bearer 和 api-key 两种方式
+func (p *Proxy) validAccessKey(authorization, apiKey string) bool {
+	key := strings.TrimPrefix(authorization, "Bearer ")
+	if key == "" {
+		key = apiKey
+	}
+	if key == "" {
+		return false
+	}
+	for _, k := range p.config.AccessKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -2,11 +2,14 @@ package proxy
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"net"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	pluginPKG "github.com/bagaking/openapi-proxy/plugin"
 )
 
 // streamResponseWriter 包装 gin.ResponseWriter 以支持流式响应
@@ -101,3 +104,41 @@ func (w *streamResponseWriter) Pusher() http.Pusher {
 	}
 	return nil
 }
+
+// recordWriter 包装 gin.ResponseWriter，在写出的同时记录响应，供 ResponsePlugin（如缓存）使用
+type recordWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func newRecordWriter(w gin.ResponseWriter) *recordWriter {
+	return &recordWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+// WriteHeader 实现 http.ResponseWriter
+func (w *recordWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write 实现 io.Writer
+func (w *recordWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString 实现 io.StringWriter
+func (w *recordWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// response 返回记录下来的响应
+func (w *recordWriter) response() *pluginPKG.Response {
+	return &pluginPKG.Response{
+		StatusCode: w.status,
+		Header:     w.Header().Clone(),
+		Body:       w.body.Bytes(),
+	}
+}